@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Access log
+
+// The access log format, "" when requests aren't logged
+var accessLog = accessLogFormat(os.Getenv("SUB2PORT_ACCESS_LOG"))
+
+// Access log lines in the common formats carry their own time
+var accessLogger = log.New(log.Writer(), "", 0)
+
+// true is the short format, for reading; common, combined, and json are for tools
+func accessLogFormat(value string) string {
+	switch value {
+	case "", "common", "combined", "json":
+		return value
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_ACCESS_LOG: expected true, common, combined, or json, got %q", value)))
+	}
+	if enabled {
+		return "short"
+	}
+	return ""
+}
+
+// Tell clients which replica answered, for tracing bad responses
+var backendHeader = envBool("SUB2PORT_BACKEND_HEADER")
+
+// Records what was sent to the client, and which backend answered
+type accessRecorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	backend route
+}
+
+func (recorder *accessRecorder) WriteHeader(code int) {
+	// Informational answers like 103 Early Hints come before the final status.
+	informational := code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+	if recorder.status == 0 && !informational {
+		recorder.status = code
+	}
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+func (recorder *accessRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(body)
+	recorder.bytes += int64(n)
+	return n, err
+}
+
+// Let http.ResponseController reach the flusher and hijacker
+func (recorder *accessRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+// The backend as "name id image", e.g. "app-1 0123456789ab nginx:1.27"
+func (backend route) String() string {
+	id := string(backend.ID)
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return fmt.Sprintf("%s %s %s", backend.Name, orDash(id), orDash(backend.Image))
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// The replica whose address a response came from, which differs from the
+// picked backend when a hedged request wins
+func servedBy(pool *hostPool, picked route, response *http.Response) route {
+	for _, backend := range pool.backends {
+		if backend.Host+":"+backend.Port == response.Request.URL.Host {
+			return backend
+		}
+	}
+	return picked
+}
+
+func logAccess(request *http.Request, recorder *accessRecorder, forwarded bool, started time.Time) {
+	backend := route{Name: "-"}
+	if forwarded {
+		backend = recorder.backend
+	}
+	duration := time.Since(started)
+	client := clientIP(request)
+	switch accessLog {
+	case "short":
+		slog.Info(fmt.Sprintf("%s %s %s %d %d %s -> %s",
+			request.Host, request.Method, request.RequestURI, recorder.status, recorder.bytes,
+			duration.Round(time.Millisecond), backend))
+	case "common", "combined":
+		// Apache's vhost_common and vhost_combined, followed by the duration in ms and the backend
+		size := "-"
+		if recorder.bytes > 0 {
+			size = strconv.FormatInt(recorder.bytes, 10)
+		}
+		line := fmt.Sprintf("%s %s - - [%s] %q %d %s",
+			orDash(request.Host), client, started.Format("02/Jan/2006:15:04:05 -0700"),
+			request.Method+" "+request.RequestURI+" "+request.Proto, recorder.status, size)
+		if accessLog == "combined" {
+			line += fmt.Sprintf(" %q %q", orDash(request.Referer()), orDash(request.UserAgent()))
+		}
+		accessLogger.Printf("%s %d %q", line, duration.Milliseconds(), backend.Name)
+	case "json":
+		line, _ := json.Marshal(accessEntry{
+			Time:      started.UTC().Format(time.RFC3339Nano),
+			Host:      request.Host,
+			Client:    client,
+			Method:    request.Method,
+			URI:       request.RequestURI,
+			Proto:     request.Proto,
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			Duration:  duration.Seconds(),
+			Backend:   backend.Name,
+			Container: backend.ID,
+			Image:     backend.Image,
+			Referer:   request.Referer(),
+			UserAgent: request.UserAgent(),
+		})
+		accessLogger.Print(string(line))
+	}
+}
+
+type accessEntry struct {
+	Time      string        `json:"time"`
+	Host      string        `json:"host"`
+	Client    string        `json:"client"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  float64       `json:"duration"` // seconds
+	Backend   ContainerName `json:"backend"`
+	Container ContainerID   `json:"container,omitempty"`
+	Image     string        `json:"image,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A minimal ACME (RFC 8555) client for HTTP-01 and TLS-ALPN-01 (RFC 8737) certificates
+
+type acmeClient struct {
+	sync.Mutex // serializes orders, which share the nonce
+	directory  string
+	email      string
+	challenge  string // http-01 or tls-alpn-01
+	key        *ecdsa.PrivateKey
+	client     *http.Client
+	urls       struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+	kid   string // the account URL
+	nonce string
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (problem *acmeProblem) Error() string {
+	return fmt.Sprintf("%s: %s", problem.Type, problem.Detail)
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeAuthorization struct {
+	Status     string `json:"status"`
+	Challenges []struct {
+		Type   string       `json:"type"`
+		URL    string       `json:"url"`
+		Token  string       `json:"token"`
+		Status string       `json:"status"`
+		Error  *acmeProblem `json:"error"`
+	} `json:"challenges"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// Register the account, or find the existing one for the key
+func (acme *acmeClient) register() error {
+	response, err := acme.client.Get(acme.directory)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if err := json.NewDecoder(response.Body).Decode(&acme.urls); err != nil {
+		return fmt.Errorf("directory: %w", err)
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if acme.email != "" {
+		account["contact"] = []string{"mailto:" + acme.email}
+	}
+	response, err = acme.post(acme.urls.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("account: %w", err)
+	}
+	acme.kid = response.Header.Get("Location")
+	return nil
+}
+
+// Order a certificate for a host, answering its challenge with respond
+func (acme *acmeClient) obtain(host HostName, respond func(token, keyAuth string), done func(token string)) (certPEM, keyPEM []byte, err error) {
+	acme.Lock()
+	defer acme.Unlock()
+	if acme.kid == "" {
+		if err := acme.register(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var order acmeOrder
+	response, err := acme.post(acme.urls.NewOrder, map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": string(host)}},
+	}, &order)
+	if err != nil {
+		return nil, nil, fmt.Errorf("order: %w", err)
+	}
+	orderURL := response.Header.Get("Location")
+
+	for _, authURL := range order.Authorizations {
+		if err := acme.authorize(authURL, respond, done); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: string(host)},
+		DNSNames: []string{string(host)},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := acme.post(order.Finalize, map[string]string{"csr": b64.EncodeToString(csr)}, &order); err != nil {
+		return nil, nil, fmt.Errorf("finalize: %w", err)
+	}
+	for attempt := 0; order.Status != "valid"; attempt++ {
+		if order.Status == "invalid" || attempt == 30 {
+			return nil, nil, fmt.Errorf("order is %s", order.Status)
+		}
+		time.Sleep(2 * time.Second)
+		if _, err := acme.post(orderURL, nil, &order); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	response, err = acme.post(order.Certificate, nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("certificate: %w", err)
+	}
+	certPEM, err = io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = marshalKey(key)
+	return certPEM, keyPEM, err
+}
+
+// Answer an authorization's challenge and wait for it to be validated
+func (acme *acmeClient) authorize(authURL string, respond func(token, keyAuth string), done func(token string)) error {
+	var auth acmeAuthorization
+	if _, err := acme.post(authURL, nil, &auth); err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if auth.Status == "valid" {
+		return nil
+	}
+	for _, challenge := range auth.Challenges {
+		if challenge.Type != acme.challenge {
+			continue
+		}
+		respond(challenge.Token, challenge.Token+"."+acme.thumbprint())
+		defer done(challenge.Token)
+		if _, err := acme.post(challenge.URL, struct{}{}, nil); err != nil {
+			return fmt.Errorf("challenge: %w", err)
+		}
+		for attempt := 0; auth.Status == "pending"; attempt++ {
+			if attempt == 30 {
+				return errors.New("challenge timed out")
+			}
+			time.Sleep(2 * time.Second)
+			if _, err := acme.post(authURL, nil, &auth); err != nil {
+				return err
+			}
+		}
+		if auth.Status != "valid" {
+			for _, challenge := range auth.Challenges {
+				if challenge.Error != nil {
+					return challenge.Error
+				}
+			}
+			return fmt.Errorf("authorization is %s", auth.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("no %s challenge offered", acme.challenge)
+}
+
+// POST a JWS, or POST-as-GET when payload is nil, decoding the response into out
+func (acme *acmeClient) post(url string, payload interface{}, out interface{}) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		response, err := acme.postOnce(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Callers can still read the body, which is already closed.
+		response.Body = io.NopCloser(bytes.NewReader(body))
+		if response.StatusCode < 300 {
+			if out != nil {
+				err = json.Unmarshal(body, out)
+			}
+			return response, err
+		}
+		problem := &acmeProblem{}
+		_ = json.Unmarshal(body, problem)
+		// Nonces expire, so a fresh one is worth one more try.
+		if problem.Type == "urn:ietf:params:acme:error:badNonce" && retry == 0 {
+			continue
+		}
+		return nil, problem
+	}
+}
+
+func (acme *acmeClient) postOnce(url string, payload interface{}) (*http.Response, error) {
+	if acme.nonce == "" {
+		response, err := acme.client.Head(acme.urls.NewNonce)
+		if err != nil {
+			return nil, err
+		}
+		_ = response.Body.Close()
+		acme.nonce = response.Header.Get("Replay-Nonce")
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": acme.nonce, "url": url}
+	if acme.kid != "" {
+		protected["kid"] = acme.kid
+	} else {
+		protected["jwk"] = acme.jwk()
+	}
+	acme.nonce = ""
+	header, _ := json.Marshal(protected)
+	body := ""
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = b64.EncodeToString(encoded)
+	}
+	signed := b64.EncodeToString(header) + "." + body
+	signature, err := acme.sign([]byte(signed))
+	if err != nil {
+		return nil, err
+	}
+	jws, _ := json.Marshal(map[string]string{
+		"protected": b64.EncodeToString(header),
+		"payload":   body,
+		"signature": b64.EncodeToString(signature),
+	})
+	response, err := acme.client.Post(url, "application/jose+json", bytes.NewReader(jws))
+	if err != nil {
+		return nil, err
+	}
+	acme.nonce = response.Header.Get("Replay-Nonce")
+	return response, nil
+}
+
+// ES256 signatures are the raw r and s, not ASN.1
+func (acme *acmeClient) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	der, err := ecdsa.SignASN1(rand.Reader, acme.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	var signature struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &signature); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 64)
+	signature.R.FillBytes(raw[:32])
+	signature.S.FillBytes(raw[32:])
+	return raw, nil
+}
+
+type acmeJWK struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// The fields are in the lexicographic order RFC 7638 thumbprints need
+func (acme *acmeClient) jwk() acmeJWK {
+	public, _ := acme.key.PublicKey.ECDH()
+	point := public.Bytes() // 0x04 || x || y
+	return acmeJWK{Crv: "P-256", Kty: "EC", X: b64.EncodeToString(point[1:33]), Y: b64.EncodeToString(point[33:])}
+}
+
+func (acme *acmeClient) thumbprint() string {
+	encoded, _ := json.Marshal(acme.jwk())
+	digest := sha256.Sum256(encoded)
+	return b64.EncodeToString(digest[:])
+}
+
+func marshalKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Admin API
+
+var adminMux = http.NewServeMux()
+
+func init() {
+	adminMux.HandleFunc("POST /events/restart", func(writer http.ResponseWriter, _ *http.Request) {
+		for _, daemon := range daemons {
+			daemon.restartEvents()
+		}
+		writeJSON(writer, http.StatusAccepted, map[string]string{"status": "restarting"})
+	})
+}
+
+// Control-plane listeners, each serving a subset of the admin API
+var controlPlane = []struct {
+	role     string
+	variable string
+	patterns []string // all of adminMux when empty
+}{
+	{"admin", "SUB2PORT_ADMIN", nil},
+	{"metrics", "SUB2PORT_METRICS", []string{"GET /metrics"}},
+	{"health", "SUB2PORT_HEALTH", []string{"GET /livez", "GET /healthz"}},
+}
+
+// Serve the configured control-plane listeners, never on the proxy's address
+func serveControlPlane() {
+	for _, plane := range controlPlane {
+		addr := os.Getenv(plane.variable)
+		if addr == "" {
+			continue
+		}
+		if addr == server.Addr {
+			fatal(fail(failConfig, fmt.Errorf("%s: %s is the proxy listener, the control plane needs its own", plane.variable, addr)))
+		}
+		var handler http.Handler = adminMux
+		if plane.patterns != nil {
+			mux := http.NewServeMux()
+			for _, pattern := range plane.patterns {
+				mux.Handle(pattern, adminMux)
+			}
+			handler = mux
+		}
+		go serveAdmin(plane.role, addr, handler)
+	}
+}
+
+func serveAdmin(role, addr string, handler http.Handler) {
+	log.Printf("# %s listening on %s", role, addr)
+	admin := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: readHeaderTimeout, MaxHeaderBytes: maxHeaderBytes}
+	fatal(fail(failBind, admin.ListenAndServe()))
+}
+
+func writeJSON(writer http.ResponseWriter, code int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Printf("admin: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Host aliases
+
+// Aliases proxied like their canonical host, as "alias=canonical,..."
+var hostAliases = envHosts("SUB2PORT_ALIASES")
+
+// Aliases redirected to their canonical host, as "alias=canonical,..."
+var hostRedirects = envHosts("SUB2PORT_REDIRECTS")
+
+// Read "alias=canonical,..." with both names normalized like request hosts
+func envHosts(name string) map[string]string {
+	hosts := make(map[string]string)
+	for alias, canonical := range envMap(name) {
+		hosts[string(normalizeHost(alias))] = string(normalizeHost(canonical))
+	}
+	return hosts
+}
+
+// The host whose backends serve a host name
+func canonicalHost(host HostName) HostName {
+	if canonical, ok := hostAliases[string(host)]; ok {
+		return HostName(canonical)
+	}
+	return host
+}
+
+// Permanently redirect an alias to its canonical host, keeping the port and path
+func redirectAlias(writer http.ResponseWriter, request *http.Request) bool {
+	canonical, ok := hostRedirects[string(requestHost(request))]
+	if !ok {
+		return false
+	}
+	if _, port, err := net.SplitHostPort(request.Host); err == nil {
+		canonical = net.JoinHostPort(canonical, port)
+	}
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	http.Redirect(writer, request, scheme+"://"+canonical+request.URL.RequestURI(), http.StatusMovedPermanently)
+	return true
+}
+
+func init() {
+	for alias := range hostRedirects {
+		if _, ok := hostAliases[alias]; ok {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_REDIRECTS: %s is also in SUB2PORT_ALIASES", alias)))
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+// Aliases are configured in any case, with or without a trailing dot
+func TestEnvHosts(t *testing.T) {
+	t.Setenv("SUB2PORT_ALIASES", "WWW.App.Test.=App.Test, old.test = new.test.")
+	want := map[string]string{"www.app.test": "app.test", "old.test": "new.test"}
+	if hosts := envHosts("SUB2PORT_ALIASES"); !maps.Equal(hosts, want) {
+		t.Fatalf("expected %v, got %v", want, hosts)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTP Basic authentication in front of a host
+
+// Parse SUB2PORT_AUTH, "<user>:<bcrypt hash>" separated by commas
+func parseAuth(value string) (map[string]*bcryptHash, error) {
+	users := make(map[string]*bcryptHash)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("expected <user>:<bcrypt hash>, got %q", entry)
+		}
+		parsed, err := parseBcrypt(hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", user, err)
+		}
+		users[user] = parsed
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return users, nil
+}
+
+// Credentials that matched, so each client pays for bcrypt once instead of on every request
+var authCache = struct {
+	sync.Mutex
+	passed map[[sha256.Size]byte]bool
+}{passed: make(map[[sha256.Size]byte]bool)}
+
+// The most credentials remembered, forgotten all at once past it
+const authCacheSize = 1024
+
+func authenticated(hash *bcryptHash, user, password string) bool {
+	key := sha256.Sum256(fmt.Appendf(nil, "%x\x00%x\x00%s\x00%s", hash.salt, hash.sum, user, password))
+	authCache.Lock()
+	passed := authCache.passed[key]
+	authCache.Unlock()
+	if passed {
+		return true
+	}
+	if !hash.matches(password) {
+		return false
+	}
+	authCache.Lock()
+	if len(authCache.passed) >= authCacheSize {
+		clear(authCache.passed)
+	}
+	authCache.passed[key] = true
+	authCache.Unlock()
+	return true
+}
+
+// Ask for credentials until the client sends a user's, which the backend doesn't see
+func filterAuth(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if options.Auth == nil {
+		return false
+	}
+	user, password, ok := request.BasicAuth()
+	if hash := options.Auth[user]; ok && hash != nil && authenticated(hash, user, password) {
+		request.Header.Del("Authorization")
+		return false
+	}
+	writer.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(string(requestHost(request)))+`, charset="UTF-8"`)
+	renderError(writer, request, options, http.StatusUnauthorized, fmt.Sprintf("%s needs a user name and password", request.Host))
+	return true
+}
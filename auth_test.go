@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	// Hashed by libxcrypt, the U*U ones are also OpenBSD's and crypt_blowfish's test vectors
+	for hash, password := range map[string]string{
+		"$2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s.": "",
+		"$2b$04$If6bvum7DFjUnE9p2uDeDucp2Wtj9yCnnZ/22ojXzNt6nEEkieowK": "abc",
+		"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW": "U*U",
+		"$2b$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK": "U*U*",
+		"$2y$05$XXXXXXXXXXXXXXXXXXXXXOAcXxm9kjPGEMsLznoKqmqw7tc8WCx4a": "U*U*U",
+	} {
+		parsed, err := parseBcrypt(hash)
+		if err != nil || !parsed.matches(password) || parsed.matches(password+"x") {
+			t.Fatalf("%s: expected only %q to match, %v", hash, password, err)
+		}
+	}
+	// Only the first 72 bytes of a password count.
+	long := "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	parsed, err := parseBcrypt("$2a$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui")
+	if err != nil || !parsed.matches(long) || !parsed.matches(long+"chars after 72 are ignored") || parsed.matches(long[:71]) {
+		t.Fatalf("expected passwords to be cut at 72 bytes, %v", err)
+	}
+	if _, err := parseBcrypt("$2b$15$If6bvum7DFjUnE9p2uDeDucp2Wtj9yCnnZ/22ojXzNt6nEEkieowK"); err == nil {
+		t.Fatal("expected a cost over 14 to be rejected")
+	}
+	// A verified password isn't hashed again, which at cost 31 would take days.
+	parsed, _ = parseBcrypt("$2b$04$If6bvum7DFjUnE9p2uDeDucp2Wtj9yCnnZ/22ojXzNt6nEEkieowK")
+	if parsed.cost = 31; !parsed.matches("abc") {
+		t.Fatal("expected the verified password to be remembered")
+	}
+
+	users, err := parseAuth("admin:$2y$05$abcdefghijklmnopqrstuuOQiyCxlgf/oeuTqixKmWdcYUh4Hjl0a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAuth("admin:secret"); err == nil {
+		t.Fatal("expected a plain password to be rejected")
+	}
+	options := &hostOptions{Auth: users}
+	send := func(user, password string) (*httptest.ResponseRecorder, *http.Request) {
+		request := httptest.NewRequest(http.MethodGet, "http://dashboard.test/", nil)
+		if user != "" {
+			request.SetBasicAuth(user, password)
+		}
+		recorder := httptest.NewRecorder()
+		if !filterAuth(recorder, request, options) {
+			recorder.Code = 0
+		}
+		return recorder, request
+	}
+	for _, credentials := range [][2]string{{"", ""}, {"admin", "wrong"}, {"root", "secret"}} {
+		recorder, _ := send(credentials[0], credentials[1])
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != `Basic realm="dashboard.test", charset="UTF-8"` {
+			t.Fatalf("%v: expected 401 with a challenge, got %d %v", credentials, recorder.Code, recorder.Header())
+		}
+	}
+	for range 2 {
+		recorder, request := send("admin", "secret")
+		if recorder.Code != 0 || request.Header.Get("Authorization") != "" {
+			t.Fatalf("expected the user to pass without forwarding the password, got %d %v", recorder.Code, request.Header)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPS backends
+
+// Build the transport that verifies a container's https backends
+func backendTransport(name ContainerName, vars map[string]string) (*http.Transport, error) {
+	config := &tls.Config{
+		ServerName: cmp.Or(strings.TrimSpace(vars["SUB2PORT_TLS_SERVER_NAME"]), string(name)),
+	}
+
+	if bundle := strings.TrimSpace(vars["SUB2PORT_TLS_CA"]); bundle != "" {
+		// The bundle is inline PEM or a path mounted into the proxy container.
+		pem := []byte(bundle)
+		if !strings.HasPrefix(bundle, "-----BEGIN") {
+			var err error
+			if pem, err = os.ReadFile(bundle); err != nil {
+				return nil, fmt.Errorf("SUB2PORT_TLS_CA: %w", err)
+			}
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("SUB2PORT_TLS_CA: no certificates found")
+		}
+	}
+
+	var pins []string
+	for _, pin := range strings.Split(vars["SUB2PORT_TLS_PINS"], ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			pins = append(pins, strings.TrimPrefix(pin, "sha256/"))
+		}
+	}
+	if len(pins) > 0 {
+		// A pinned key is trusted on its own when no CA bundle is given.
+		config.InsecureSkipVerify = config.RootCAs == nil
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errPinMismatch
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+			if !slices.Contains(pins, base64.StdEncoding.EncodeToString(sum[:])) {
+				return fmt.Errorf("%w: sha256/%s", errPinMismatch, base64.StdEncoding.EncodeToString(sum[:]))
+			}
+			return nil
+		}
+	}
+
+	if insecure := vars["SUB2PORT_TLS_INSECURE"]; insecure == "true" || insecure == "1" {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = nil
+	}
+
+	transport := sharedTransport.Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+var errPinMismatch = errors.New("certificate public key does not match a pin")
+
+// The latest upstream error of each backend
+
+type upstreamError struct {
+	Host    HostName      `json:"host"`
+	Backend ContainerName `json:"backend"`
+	Kind    string        `json:"kind"` // tls or connection
+	Error   string        `json:"error"`
+	Time    time.Time     `json:"time"`
+}
+
+var upstreamErrors = struct {
+	sync.Mutex
+	backends map[ContainerName]upstreamError
+}{backends: make(map[ContainerName]upstreamError)}
+
+var upstreamErrorCount = newCounterVec("sub2port_upstream_errors_total", "Requests that failed to reach a backend.", "host", "kind")
+
+func recordUpstreamError(host HostName, backend ContainerName, err error) {
+	kind := "connection"
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) || errors.Is(err, errPinMismatch) {
+		kind = "tls"
+	}
+	upstreamErrorCount.Inc(string(host), kind)
+	upstreamErrors.Lock()
+	upstreamErrors.backends[backend] = upstreamError{host, backend, kind, err.Error(), time.Now()}
+	upstreamErrors.Unlock()
+}
+
+func init() {
+	adminMux.HandleFunc("GET /errors", func(writer http.ResponseWriter, _ *http.Request) {
+		upstreamErrors.Lock()
+		latest := make([]upstreamError, 0, len(upstreamErrors.backends))
+		for _, err := range upstreamErrors.backends {
+			latest = append(latest, err)
+		}
+		upstreamErrors.Unlock()
+		slices.SortFunc(latest, func(a, b upstreamError) int { return b.Time.Compare(a.Time) })
+		writeJSON(writer, http.StatusOK, latest)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync/atomic"
+)
+
+// Load balancing strategies
+
+// Picks the backend of a request from a host's live backends
+type balancer interface {
+	pick(entry *hostEntry, backends []route, request *http.Request) int
+}
+
+var balancers = map[string]balancer{
+	"round-robin": roundRobin{},
+	"least-conn":  leastConn{},
+	"random":      randomPick{},
+	"ip-hash":     ipHash{},
+}
+
+func (options *hostOptions) balancer() balancer {
+	if options.Balance == nil {
+		return roundRobin{}
+	}
+	return options.Balance
+}
+
+type roundRobin struct{}
+
+func (roundRobin) pick(entry *hostEntry, backends []route, _ *http.Request) int {
+	turn := entry.counter.Add(1) - 1
+	if !weighted(backends) {
+		return int(turn % uint64(len(backends)))
+	}
+	// Multiples of the golden ratio spread turns evenly over [0, 1).
+	return weightedIndex(backends, float64((turn*0x9e3779b97f4a7c15)>>11)/(1<<53))
+}
+
+// The backend with the fewest requests in flight, rotating through ties
+type leastConn struct{}
+
+func (leastConn) pick(entry *hostEntry, backends []route, _ *http.Request) int {
+	start := int(entry.counter.Add(1) % uint64(len(backends)))
+	best := start
+	for offset := range backends {
+		index := (start + offset) % len(backends)
+		if float64(backends[index].active())/backends[index].weight() < float64(backends[best].active())/backends[best].weight() {
+			best = index
+		}
+	}
+	return best
+}
+
+type randomPick struct{}
+
+func (randomPick) pick(_ *hostEntry, backends []route, _ *http.Request) int {
+	return weightedIndex(backends, rand.Float64())
+}
+
+// The same client address reaches the same backend while the backends don't change
+type ipHash struct{}
+
+func (ipHash) pick(_ *hostEntry, backends []route, request *http.Request) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(clientIP(request)))
+	if !weighted(backends) {
+		return int(hash.Sum32() % uint32(len(backends)))
+	}
+	return weightedIndex(backends, float64(hash.Sum32())/(1<<32))
+}
+
+// A backend's share of requests relative to the host's other backends
+func (backend route) weight() float64 {
+	if backend.Weight > 0 {
+		return backend.Weight
+	}
+	return 1
+}
+
+// Whether some backends weigh more than others
+func weighted(backends []route) bool {
+	for _, backend := range backends[1:] {
+		if backend.weight() != backends[0].weight() {
+			return true
+		}
+	}
+	return false
+}
+
+// The backend at fraction x of the backends' total weight, laid end to end
+func weightedIndex(backends []route, x float64) int {
+	total := 0.0
+	for _, backend := range backends {
+		total += backend.weight()
+	}
+	x *= total
+	for i, backend := range backends {
+		if x -= backend.weight(); x < 0 {
+			return i
+		}
+	}
+	return len(backends) - 1
+}
+
+// Requests in flight to a backend
+type inflight = atomic.Int64
+
+func (backend route) active() int64 {
+	if backend.inflight == nil {
+		return 0
+	}
+	return backend.inflight.Load()
+}
+
+// Each backend's share of the requests sent to the host's backends, to check
+// that the balancer spreads them as expected
+func shares(routes []route) []float64 {
+	counts := make([]float64, len(routes))
+	total := 0.0
+	for i, route := range routes {
+		if route.served != nil {
+			counts[i] = float64(route.served.Load())
+		}
+		total += counts[i]
+	}
+	for i := range counts {
+		if total > 0 {
+			counts[i] /= total
+		}
+	}
+	return counts
+}
+
+func init() {
+	registerMetric(&metricFamily{
+		name:   "sub2port_backend_share",
+		kind:   "gauge",
+		help:   "Share of a host's requests sent to each of its backends since they were added.",
+		labels: []string{"host", "backend"},
+		collect: func(emit func(float64, ...string)) {
+			table.hosts.Range(func(key, value any) bool {
+				pool := value.(*hostEntry).pool.Load()
+				routes := slices.Concat(pool.backends, pool.held)
+				for i, share := range shares(routes) {
+					emit(share, string(key.(HostName)), string(routes[i].Name))
+				}
+				return true
+			})
+		},
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Round-robin sends each backend an equal share
+func TestRoundRobinShare(t *testing.T) {
+	fillTable(t, 1)
+	entry := table.lookup("app0.test")
+	request := httptest.NewRequest(http.MethodGet, "http://app0.test/", nil)
+	for range 100 {
+		pool := entry.pool.Load()
+		pool.backends[pool.options().balancer().pick(entry, pool.backends, request)].served.Add(1)
+	}
+	view := viewHost("app0.test", entry.pool.Load())
+	for _, backend := range view.Backends {
+		if backend.Requests != 50 || backend.Share != 0.5 {
+			t.Fatalf("expected 50 requests and half the share on %s, got %d and %v", backend.Name, backend.Requests, backend.Share)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bcrypt password hashes, checked without leaving the standard library
+
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+// Costs over this take seconds a request, letting a few clients tie up every core
+const bcryptMaxCost = 14
+
+// Checks running at once, so guessing passwords can't starve the proxy
+var bcryptChecks = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// The most verified passwords remembered before starting over
+const bcryptVerifiedLimit = 1024
+
+// Digests of the hashes and passwords verified, so a user's next request is cheap
+var bcryptVerified = struct {
+	sync.Mutex
+	digests map[[sha256.Size]byte]bool
+}{digests: make(map[[sha256.Size]byte]bool)}
+
+type bcryptHash struct {
+	cost int
+	salt []byte // 16 bytes
+	sum  []byte // 23 bytes
+}
+
+// Parse a $2a$, $2b$, or $2y$ hash, e.g. from htpasswd -B
+func parseBcrypt(hash string) (*bcryptHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "" || (parts[1] != "2a" && parts[1] != "2b" && parts[1] != "2y") {
+		return nil, errors.New("expected a bcrypt hash like $2y$10$...")
+	}
+	cost, err := strconv.Atoi(parts[2])
+	if err != nil || len(parts[2]) != 2 || cost < 4 || cost > bcryptMaxCost {
+		return nil, fmt.Errorf("expected a bcrypt cost between 04 and %02d", bcryptMaxCost)
+	}
+	if len(parts[3]) != 53 {
+		return nil, errors.New("expected a 22 character salt and a 31 character hash")
+	}
+	salt, err := bcryptEncoding.DecodeString(parts[3][:22])
+	if err != nil {
+		return nil, err
+	}
+	sum, err := bcryptEncoding.DecodeString(parts[3][22:])
+	if err != nil {
+		return nil, err
+	}
+	return &bcryptHash{cost: cost, salt: salt, sum: sum}, nil
+}
+
+func (hash *bcryptHash) matches(password string) bool {
+	digest := sha256.Sum256([]byte(string(hash.salt) + string(hash.sum) + password))
+	bcryptVerified.Lock()
+	verified := bcryptVerified.digests[digest]
+	bcryptVerified.Unlock()
+	if verified {
+		return true
+	}
+	bcryptChecks <- struct{}{}
+	sum := bcryptSum(hash.cost, hash.salt, []byte(password))
+	<-bcryptChecks
+	if subtle.ConstantTimeCompare(sum, hash.sum) != 1 {
+		return false
+	}
+	bcryptVerified.Lock()
+	defer bcryptVerified.Unlock()
+	if len(bcryptVerified.digests) >= bcryptVerifiedLimit {
+		clear(bcryptVerified.digests)
+	}
+	bcryptVerified.digests[digest] = true
+	return true
+}
+
+// The 23 bytes of "OrpheanBeholderScryDoubt" encrypted with the expensive key schedule
+func bcryptSum(cost int, salt, password []byte) []byte {
+	// The key is NUL terminated, and only its first 72 bytes count.
+	key := append(append([]byte{}, password...), 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+	cipher := newBlowfish()
+	cipher.expandKey(key, salt)
+	for range uint64(1) << cost {
+		cipher.expandKey(key, nil)
+		cipher.expandKey(salt, nil)
+	}
+	text := []byte("OrpheanBeholderScryDoubt")
+	var words [6]uint32
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(text[4*i:])
+	}
+	for range 64 {
+		for i := 0; i < 6; i += 2 {
+			words[i], words[i+1] = cipher.encrypt(words[i], words[i+1])
+		}
+	}
+	for i, word := range words {
+		binary.BigEndian.PutUint32(text[4*i:], word)
+	}
+	return text[:23]
+}
+
+// Blowfish, as bcrypt's key schedule uses it
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+func newBlowfish() *blowfish {
+	return &blowfish{p: blowfishP, s: blowfishS}
+}
+
+func (cipher *blowfish) f(x uint32) uint32 {
+	return ((cipher.s[0][x>>24] + cipher.s[1][x>>16&0xff]) ^ cipher.s[2][x>>8&0xff]) + cipher.s[3][x&0xff]
+}
+
+func (cipher *blowfish) encrypt(left, right uint32) (uint32, uint32) {
+	for i := 0; i < 16; i += 2 {
+		left ^= cipher.p[i]
+		right ^= cipher.f(left)
+		right ^= cipher.p[i+1]
+		left ^= cipher.f(right)
+	}
+	return right ^ cipher.p[17], left ^ cipher.p[16]
+}
+
+// Mix a key into the state, and a salt into the blocks encrypted, when there is one
+func (cipher *blowfish) expandKey(key, salt []byte) {
+	offset := 0
+	for i := range cipher.p {
+		cipher.p[i] ^= nextWord(key, &offset)
+	}
+	offset = 0
+	var left, right uint32
+	next := func() (uint32, uint32) {
+		if salt != nil {
+			left ^= nextWord(salt, &offset)
+			right ^= nextWord(salt, &offset)
+		}
+		left, right = cipher.encrypt(left, right)
+		return left, right
+	}
+	for i := 0; i < len(cipher.p); i += 2 {
+		cipher.p[i], cipher.p[i+1] = next()
+	}
+	for box := range cipher.s {
+		for i := 0; i < 256; i += 2 {
+			cipher.s[box][i], cipher.s[box][i+1] = next()
+		}
+	}
+}
+
+// The next 4 bytes of data as a word, wrapping around at its end
+func nextWord(data []byte, offset *int) uint32 {
+	var word uint32
+	for range 4 {
+		word = word<<8 | uint32(data[*offset])
+		*offset = (*offset + 1) % len(data)
+	}
+	return word
+}
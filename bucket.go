@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A token bucket refilled at rate tokens per second up to burst
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take a token, or report how long until one is available
+func (bucket *tokenBucket) reserve() time.Duration {
+	bucket.Lock()
+	defer bucket.Unlock()
+	bucket.refill(time.Now())
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// Take a token, waiting for one if the bucket is empty
+func (bucket *tokenBucket) wait(ctx context.Context) (bool, error) {
+	delay := bucket.reserve()
+	if delay == 0 {
+		return false, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// Take a token if one is available, or report how long until one is, without waiting
+func (bucket *tokenBucket) take() time.Duration {
+	bucket.Lock()
+	defer bucket.Unlock()
+	bucket.refill(time.Now())
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// Whether the bucket has refilled, so dropping it changes nothing
+func (bucket *tokenBucket) full() bool {
+	bucket.Lock()
+	defer bucket.Unlock()
+	bucket.refill(time.Now())
+	return bucket.tokens >= bucket.burst
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	bucket.tokens = min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// Request buffering
+
+// Request bodies up to this size are buffered on hosts with SUB2PORT_BUFFER=request
+var bufferLimit = int64(envInt("SUB2PORT_BUFFER_LIMIT", 1<<20))
+
+// Read a request body into memory so it can be sent again, streaming it when it is too large
+func bufferBody(request *http.Request) error {
+	if request.Body == nil || request.Body == http.NoBody || request.ContentLength > bufferLimit {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, bufferLimit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > bufferLimit {
+		// Send what was read, then the rest as it comes.
+		request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+		return nil
+	}
+	_ = request.Body.Close()
+	request.ContentLength = int64(len(body))
+	request.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	request.Body, _ = request.GetBody()
+	return nil
+}
+
+// The request has no body, or a buffered one that can be sent again
+func replayable(request *http.Request) bool {
+	return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"cmp"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS termination with ACME certificates
+
+// The HTTPS listen address, e.g. ":443"
+var tlsAddr = os.Getenv("SUB2PORT_TLS")
+
+var certDir = cmp.Or(os.Getenv("SUB2PORT_CERT_DIR"), "/var/lib/sub2port/certs")
+
+// Renew certificates this long before they expire
+const renewBefore = 30 * 24 * time.Hour
+
+// Wait this long before ordering a host's certificate again after a failure
+var issueBackoff = envDuration("SUB2PORT_ACME_BACKOFF", time.Hour)
+
+const challengePath = "/.well-known/acme-challenge/"
+
+// The ALPN protocol of TLS-ALPN-01 validation handshakes
+const acmeALPN = "acme-tls/1"
+
+// The acmeIdentifier extension of TLS-ALPN-01 challenge certificates
+var acmeIdentifierOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type certManager struct {
+	acme       *acmeClient
+	certs      sync.Map // HostName -> *tls.Certificate
+	challenges sync.Map // token -> key authorization
+	alpnCerts  sync.Map // HostName -> *tls.Certificate answering its TLS-ALPN-01 challenge
+	issuing    sync.Map // HostName -> chan struct{}, closed when the order finishes
+	failures   sync.Map // HostName -> time.Time
+}
+
+var certs *certManager
+
+var certIssues = newCounterVec("sub2port_acme_orders_total", "ACME certificate orders by result.", "result")
+
+func init() {
+	if tlsAddr == "" {
+		return
+	}
+	challenge := cmp.Or(os.Getenv("SUB2PORT_ACME_CHALLENGE"), "http-01")
+	if challenge != "http-01" && challenge != "tls-alpn-01" {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_ACME_CHALLENGE: expected http-01 or tls-alpn-01, got %q", challenge)))
+	}
+	certs = &certManager{acme: &acmeClient{
+		directory: cmp.Or(os.Getenv("SUB2PORT_ACME_DIRECTORY"), "https://acme-v02.api.letsencrypt.org/directory"),
+		email:     os.Getenv("SUB2PORT_ACME_EMAIL"),
+		challenge: challenge,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}}
+	newGaugeFunc("sub2port_certificates", "Certificates served on the TLS listener.", func() float64 {
+		count := 0
+		certs.certs.Range(func(_, _ any) bool {
+			count++
+			return true
+		})
+		return float64(count)
+	})
+}
+
+// Serve the proxy over TLS, obtaining certificates for routed hosts
+func serveTLS() {
+	if !readOnlyStore() {
+		key, err := loadAccountKey()
+		if err != nil {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_CERT_STORE: %w", err)))
+		}
+		certs.acme.key = key
+	}
+	certs.load()
+	go certs.watchRenewals()
+
+	tlsServer := &http.Server{
+		Addr:              tlsAddr,
+		Handler:           server.Handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ConnState:         trackConn,
+		TLSConfig:         &tls.Config{GetCertificate: certs.getCertificate, NextProtos: []string{"h2", "http/1.1", acmeALPN}},
+	}
+	if ticketsDisabled {
+		tlsServer.TLSConfig.SessionTicketsDisabled = true
+	} else if ticketRotation > 0 {
+		keys := rotateTicketKeys(tlsServer.TLSConfig, nil, time.Now())
+		go watchTicketKeys(tlsServer.TLSConfig, keys)
+	}
+	tlsServer.TLSConfig.GetConfigForClient = limitProtocols(tlsServer.TLSConfig)
+	if sniHandoff != nil {
+		// Connections not passed through come from the SNI listener.
+		log.Printf("# tls listening on %s, behind passthrough", tlsAddr)
+		fatal(tlsServer.ServeTLS(sniHandoff, "", ""))
+	}
+	log.Printf("# tls listening on %s", tlsAddr)
+	fatal(fail(failBind, tlsServer.ListenAndServeTLS("", "")))
+}
+
+// Answer HTTP-01 challenges on the plain listener
+func serveChallenge(writer http.ResponseWriter, request *http.Request) bool {
+	token, ok := strings.CutPrefix(request.URL.Path, challengePath)
+	if certs == nil || !ok {
+		return false
+	}
+	keyAuth, ok := certs.challenges.Load(token)
+	if !ok {
+		return false
+	}
+	writer.Header().Set("Content-Type", "text/plain")
+	_, _ = writer.Write([]byte(keyAuth.(string)))
+	return true
+}
+
+// Redirect plain requests to the TLS listener, for hosts with SUB2PORT_HTTPS_REDIRECT
+func filterHTTPS(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if options.HTTPSRedirect == 0 || tlsAddr == "" || request.TLS != nil || strings.HasPrefix(request.URL.Path, challengePath) {
+		return false
+	}
+	// A trusted proxy in front may have terminated TLS already.
+	if trusted(peerIP(request)) && request.Header.Get("X-Forwarded-Proto") == "https" {
+		return false
+	}
+	host := request.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if _, port, err := net.SplitHostPort(tlsAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(writer, request, "https://"+host+request.URL.RequestURI(), options.HTTPSRedirect)
+	return true
+}
+
+func (manager *certManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := HostName(strings.ToLower(strings.TrimSuffix(hello.ServerName, ".")))
+	// Validation handshakes only offer the ACME protocol, and never see the host's certificate.
+	if slices.Contains(hello.SupportedProtos, acmeALPN) {
+		if cert, ok := manager.alpnCerts.Load(host); ok {
+			return cert.(*tls.Certificate), nil
+		}
+		return nil, fmt.Errorf("no tls-alpn-01 challenge for %q", host)
+	}
+	if cert, ok := manager.certs.Load(host); ok {
+		return cert.(*tls.Certificate), nil
+	}
+	if host == "" || net.ParseIP(string(host)) != nil {
+		return nil, fmt.Errorf("no certificate for %q", host)
+	}
+	// Only hosts declared by a container are worth an order.
+	if !orderable(host) {
+		if cert := manager.wildcardCert(host); cert != nil {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("no certificate for %q", host)
+	}
+	// Expired hosts that came back still have theirs stored, and other replicas may have ordered one.
+	if cert := manager.loadHost(host); cert != nil {
+		return cert, nil
+	}
+	if readOnlyStore() {
+		return nil, fmt.Errorf("no certificate for %q", host)
+	}
+	return manager.issue(host)
+}
+
+// Routed by name, on a path, through an alias, or redirected. Subdomains
+// matched by a wildcard only are when it lists their tenant, so made-up names
+// can't spend the ACME rate limits or fill the store.
+func orderable(host HostName) bool {
+	if strings.HasPrefix(string(host), "*.") {
+		return false
+	}
+	canonical := canonicalHost(host)
+	route := table.wildcard(canonical)
+	if route != canonical {
+		entry := table.lookup(route)
+		if entry == nil {
+			return false
+		}
+		pool := entry.pool.Load()
+		tenant, _, _ := strings.Cut(string(canonical), ".")
+		return len(pool.backends)+len(pool.held) > 0 && slices.Contains(pool.options().Tenants, tenant)
+	}
+	if _, ok := table.paths.Load(route); ok {
+		return true
+	}
+	return table.lookup(route) != nil || hostRedirects[string(host)] != ""
+}
+
+// The stored certificate of the host's wildcard route, e.g. *.app.test, which
+// ACME can't issue over http-01 or tls-alpn-01, so it's provisioned by the operator
+func (manager *certManager) wildcardCert(host HostName) *tls.Certificate {
+	route := table.wildcard(canonicalHost(host))
+	if !strings.HasPrefix(string(route), "*.") {
+		return nil
+	}
+	if cert, ok := manager.certs.Load(route); ok {
+		return cert.(*tls.Certificate)
+	}
+	return manager.loadHost(route)
+}
+
+// Order a host's certificate, sharing the order with concurrent handshakes
+func (manager *certManager) issue(host HostName) (*tls.Certificate, error) {
+	if failed, ok := manager.failures.Load(host); ok && time.Since(failed.(time.Time)) < issueBackoff {
+		return nil, fmt.Errorf("certificate order for %q failed recently", host)
+	}
+	done := make(chan struct{})
+	if pending, loaded := manager.issuing.LoadOrStore(host, done); loaded {
+		<-pending.(chan struct{})
+		if cert, ok := manager.certs.Load(host); ok {
+			return cert.(*tls.Certificate), nil
+		}
+		return nil, fmt.Errorf("no certificate for %q", host)
+	}
+	defer func() {
+		manager.issuing.Delete(host)
+		close(done)
+	}()
+
+	respond := func(token, keyAuth string) { manager.challenges.Store(token, keyAuth) }
+	forget := func(token string) { manager.challenges.Delete(token) }
+	if manager.acme.challenge == "tls-alpn-01" {
+		respond = func(_, keyAuth string) {
+			cert, err := challengeCert(host, keyAuth)
+			if err != nil {
+				log.Printf("! tls-alpn-01 challenge for %s: %v", host, err)
+				return
+			}
+			manager.alpnCerts.Store(host, cert)
+		}
+		forget = func(string) { manager.alpnCerts.Delete(host) }
+	}
+	certPEM, keyPEM, err := manager.acme.obtain(host, respond, forget)
+	if err == nil {
+		err = saveCert(host, certPEM, keyPEM)
+	}
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	}
+	if err != nil {
+		certIssues.Inc("error")
+		manager.failures.Store(host, time.Now())
+		log.Printf("! certificate for %s: %v", host, err)
+		return nil, err
+	}
+	certIssues.Inc("issued")
+	manager.failures.Delete(host)
+	manager.certs.Store(host, &cert)
+	log.Printf("# issued a certificate for %s, expires %s", host, cert.Leaf.NotAfter.Format(time.DateOnly))
+	return &cert, nil
+}
+
+// A self-signed certificate carrying the digest of a TLS-ALPN-01 key authorization
+func challengeCert(host HostName, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	identifier, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: string(host)},
+		DNSNames:        []string{string(host)},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: acmeIdentifierOID, Critical: true, Value: identifier}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Renew certificates close to expiry, while their host is still routed
+func (manager *certManager) watchRenewals() {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		manager.certs.Range(func(key, value any) bool {
+			host, cert := key.(HostName), value.(*tls.Certificate)
+			if time.Until(cert.Leaf.NotAfter) >= renewBefore || !orderable(host) {
+				return true
+			}
+			// Another replica sharing the store may have renewed it already.
+			if stored := manager.loadHost(host); stored != nil && time.Until(stored.Leaf.NotAfter) >= renewBefore {
+				return true
+			}
+			if !readOnlyStore() {
+				_, _ = manager.issue(host)
+			}
+			return true
+		})
+	}
+}
+
+// Load the stored certificates
+func (manager *certManager) load() {
+	names, err := certStorage.list(".crt")
+	if err != nil {
+		log.Printf("! certificates: %v", err)
+	}
+	for _, name := range names {
+		manager.loadHost(HostName(strings.TrimSuffix(name, ".crt")))
+	}
+}
+
+// Load a host's stored certificate, if it has one
+func (manager *certManager) loadHost(host HostName) *tls.Certificate {
+	certPEM, err := certStorage.get(string(host) + ".crt")
+	var keyPEM []byte
+	if err == nil {
+		keyPEM, err = certStorage.get(string(host) + ".key")
+	}
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		log.Printf("! certificate for %s: %v", host, err)
+		return nil
+	}
+	manager.certs.Store(host, &cert)
+	return &cert
+}
+
+// Drop the certificates and failures of expired hosts, which stay stored
+func (manager *certManager) expire(expired func(string) bool) {
+	for _, hosts := range []*sync.Map{&manager.certs, &manager.failures} {
+		hosts.Range(func(key, _ any) bool {
+			if expired(string(key.(HostName))) {
+				hosts.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// The key goes first, so replicas never load a certificate without its key
+func saveCert(host HostName, certPEM, keyPEM []byte) error {
+	if err := certStorage.put(string(host)+".key", keyPEM); err != nil {
+		return err
+	}
+	return certStorage.put(string(host)+".crt", certPEM)
+}
+
+// Load the ACME account key, creating it on first start
+func loadAccountKey() (*ecdsa.PrivateKey, error) {
+	const path = "account.key"
+	encoded, err := certStorage.get(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := marshalKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, certStorage.put(path, encoded)
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, fmt.Errorf("%s: not a PEM key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ECDSA key", path)
+	}
+	return ecKey, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	previous := tlsAddr
+	tlsAddr = ":8443"
+	t.Cleanup(func() { tlsAddr = previous })
+	options := &hostOptions{HTTPSRedirect: http.StatusPermanentRedirect}
+	for target, want := range map[string]string{
+		"http://app.test:8080/login?next=/":         "https://app.test:8443/login?next=/",
+		"http://app.test" + challengePath + "token": "",
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, target, nil)
+		if redirected := filterHTTPS(recorder, request, options); redirected != (want != "") {
+			t.Fatalf("expected %s to be redirected: %v", target, want != "")
+		}
+		if location := recorder.Header().Get("Location"); location != want {
+			t.Fatalf("expected %s to redirect to %q, got %q", target, want, location)
+		}
+	}
+}
+
+func TestTLSALPNChallenge(t *testing.T) {
+	manager := &certManager{}
+	cert, err := challengeCert("alpn.test", "token.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.alpnCerts.Store(HostName("alpn.test"), cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: manager.getCertificate, NextProtos: []string{"http/1.1", acmeALPN}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "alpn.test", NextProtos: []string{acmeALPN}, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := conn.ConnectionState()
+	_ = conn.Close()
+	if state.NegotiatedProtocol != acmeALPN {
+		t.Fatalf("expected %s, got %q", acmeALPN, state.NegotiatedProtocol)
+	}
+	digest := sha256.Sum256([]byte("token.thumbprint"))
+	want, _ := asn1.Marshal(digest[:])
+	extensions := state.PeerCertificates[0].Extensions
+	if !slices.ContainsFunc(extensions, func(extension pkix.Extension) bool {
+		return extension.Id.Equal(acmeIdentifierOID) && extension.Critical && bytes.Equal(extension.Value, want)
+	}) {
+		t.Fatalf("no acmeIdentifier extension in %v", extensions)
+	}
+
+	if _, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "other.test", NextProtos: []string{acmeALPN}, InsecureSkipVerify: true}); err == nil {
+		t.Fatal("expected a handshake without a challenge to fail")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the ACME account key and certificates are kept, shared by replicas
+// pointed at the same store
+
+type certStore interface {
+	// Read a file, failing with os.ErrNotExist when it isn't stored
+	get(name string) ([]byte, error)
+	put(name string, data []byte) error
+	// The names of the stored files ending in suffix
+	list(suffix string) ([]string, error)
+}
+
+// SUB2PORT_CERT_STORE: SUB2PORT_CERT_DIR by default, secrets for Docker
+// secrets, redis://[:password@]host[:port][/db], or consul://host[:port][/prefix]
+var certStorage = func() certStore {
+	store, err := parseCertStore(os.Getenv("SUB2PORT_CERT_STORE"))
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_CERT_STORE: %w", err)))
+	}
+	return store
+}()
+
+func parseCertStore(value string) (certStore, error) {
+	switch value = strings.TrimSpace(value); {
+	case value == "" || value == "dir":
+		return dirStore{dir: certDir}, nil
+	case value == "secrets":
+		return dirStore{dir: "/run/secrets", readOnly: true}, nil
+	case !strings.Contains(value, "://"):
+		return dirStore{dir: value}, nil
+	}
+	location, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch location.Scheme {
+	case "file":
+		return dirStore{dir: location.Path}, nil
+	case "redis":
+		store := &redisStore{addr: location.Host, prefix: "sub2port:certs:"}
+		if _, _, err := net.SplitHostPort(store.addr); err != nil {
+			store.addr = net.JoinHostPort(store.addr, "6379")
+		}
+		store.password, _ = location.User.Password()
+		if db := strings.Trim(location.Path, "/"); db != "" {
+			if _, err := strconv.Atoi(db); err != nil {
+				return nil, fmt.Errorf("redis database %q is not a number", db)
+			}
+			store.db = db
+		}
+		return store, nil
+	case "consul":
+		host := location.Host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "8500")
+		}
+		return &consulStore{
+			base:   "http://" + host + "/v1/kv/",
+			prefix: cmp.Or(strings.Trim(location.Path, "/"), "sub2port/certs") + "/",
+			token:  os.Getenv("CONSUL_HTTP_TOKEN"),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown store %q, expected a path, secrets, redis://, or consul://", value)
+}
+
+// Certificates can only be read, and not issued, from a store provisioned ahead of time
+func readOnlyStore() bool {
+	store, ok := certStorage.(dirStore)
+	return ok && store.readOnly
+}
+
+// Files in a directory, like a mounted volume
+type dirStore struct {
+	dir      string
+	readOnly bool // Docker secrets are provisioned ahead of time
+}
+
+func (store dirStore) get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(store.dir, name))
+}
+
+func (store dirStore) put(name string, data []byte) error {
+	if store.readOnly {
+		return fmt.Errorf("%s is read-only, provision %s ahead of time", store.dir, name)
+	}
+	if err := os.MkdirAll(store.dir, 0o700); err != nil {
+		return err
+	}
+	// Only certificates are public.
+	mode := os.FileMode(0o600)
+	if strings.HasSuffix(name, ".crt") {
+		mode = 0o644
+	}
+	return os.WriteFile(filepath.Join(store.dir, name), data, mode)
+}
+
+func (store dirStore) list(suffix string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(store.dir, "*"+suffix))
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = filepath.Base(path)
+	}
+	return names, err
+}
+
+// Keys in Redis, spoken over a connection per call
+type redisStore struct {
+	addr     string
+	password string
+	db       string
+	prefix   string
+}
+
+func (store *redisStore) get(name string) ([]byte, error) {
+	reply, err := store.do("GET", store.prefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, fmt.Errorf("redis %s: %w", name, os.ErrNotExist)
+	}
+	return reply.([]byte), nil
+}
+
+func (store *redisStore) put(name string, data []byte) error {
+	_, err := store.do("SET", store.prefix+name, string(data))
+	return err
+}
+
+func (store *redisStore) list(suffix string) ([]string, error) {
+	reply, err := store.do("KEYS", store.prefix+"*"+suffix)
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := reply.([]any)
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key, ok := key.([]byte); ok {
+			names = append(names, strings.TrimPrefix(string(key), store.prefix))
+		}
+	}
+	return names, nil
+}
+
+// Send a command, after authenticating and selecting the database, returning the last reply
+func (store *redisStore) do(command ...string) (any, error) {
+	conn, err := net.DialTimeout("tcp", store.addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	commands := [][]string{command}
+	if store.db != "" {
+		commands = slices.Insert(commands, 0, []string{"SELECT", store.db})
+	}
+	if store.password != "" {
+		commands = slices.Insert(commands, 0, []string{"AUTH", store.password})
+	}
+	var request bytes.Buffer
+	for _, args := range commands {
+		fmt.Fprintf(&request, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&request, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	var reply any
+	for range commands {
+		if reply, err = readRESP(reader); err != nil {
+			return nil, fmt.Errorf("redis %s: %w", command[0], err)
+		}
+	}
+	return reply, nil
+}
+
+// Read a RESP reply: a string, []byte, int, []any, or nil
+func readRESP(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, errors.New(rest)
+	case ':':
+		return strconv.Atoi(rest)
+	case '$':
+		size, err := strconv.Atoi(rest)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(rest)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]any, count)
+		for i := range items {
+			if items[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// Keys in Consul's KV store
+type consulStore struct {
+	base   string // the KV URL
+	prefix string // of the keys, ending in /
+	token  string
+	client *http.Client
+}
+
+func (store *consulStore) call(method, path string, body io.Reader) ([]byte, error) {
+	request, err := http.NewRequest(method, store.base+store.prefix+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if store.token != "" {
+		request.Header.Set("X-Consul-Token", store.token)
+	}
+	response, err := store.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	data, err := io.ReadAll(response.Body)
+	switch {
+	case err != nil:
+		return nil, err
+	case response.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("consul %s: %w", path, os.ErrNotExist)
+	case response.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("consul %s %s: %s", method, path, response.Status)
+	}
+	return data, nil
+}
+
+func (store *consulStore) get(name string) ([]byte, error) {
+	return store.call(http.MethodGet, url.PathEscape(name)+"?raw", nil)
+}
+
+func (store *consulStore) put(name string, data []byte) error {
+	_, err := store.call(http.MethodPut, url.PathEscape(name), bytes.NewReader(data))
+	return err
+}
+
+func (store *consulStore) list(suffix string) ([]string, error) {
+	data, err := store.call(http.MethodGet, "?keys&separator=/", nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range keys {
+		if name := strings.TrimPrefix(key, store.prefix); strings.HasSuffix(name, suffix) && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCertStores(t *testing.T) {
+	// Consul's KV API, keyed by path
+	kv := map[string][]byte{}
+	var kvLock sync.Mutex
+	consul := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		kvLock.Lock()
+		defer kvLock.Unlock()
+		key := strings.TrimPrefix(request.URL.Path, "/v1/kv/")
+		switch {
+		case request.Method == http.MethodPut:
+			kv[key], _ = io.ReadAll(request.Body)
+		case request.URL.Query().Has("keys"):
+			keys := []string{}
+			for stored := range kv {
+				if strings.HasPrefix(stored, key) {
+					keys = append(keys, stored)
+				}
+			}
+			_ = json.NewEncoder(writer).Encode(keys)
+		case kv[key] == nil:
+			writer.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = writer.Write(kv[key])
+		}
+	}))
+	t.Cleanup(consul.Close)
+
+	// Redis, answering GET, SET, and KEYS of a prefix
+	redis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = redis.Close() })
+	values := map[string]string{}
+	go func() {
+		for {
+			conn, err := redis.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			command, _ := readRESP(reader)
+			args := command.([]any)
+			kvLock.Lock()
+			switch string(args[0].([]byte)) {
+			case "SET":
+				values[string(args[1].([]byte))] = string(args[2].([]byte))
+				fmt.Fprint(conn, "+OK\r\n")
+			case "GET":
+				if value, ok := values[string(args[1].([]byte))]; ok {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+				} else {
+					fmt.Fprint(conn, "$-1\r\n")
+				}
+			case "KEYS":
+				prefix, suffix, _ := strings.Cut(string(args[1].([]byte)), "*")
+				var keys []string
+				for key := range values {
+					if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix) {
+						keys = append(keys, key)
+					}
+				}
+				fmt.Fprintf(conn, "*%d\r\n", len(keys))
+				for _, key := range keys {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(key), key)
+				}
+			}
+			kvLock.Unlock()
+			_ = conn.Close()
+		}
+	}()
+
+	for _, location := range []string{t.TempDir(), "consul://" + consul.Listener.Addr().String() + "/certs", "redis://" + redis.Addr().String()} {
+		store, err := parseCertStore(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.get("app.test.crt"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s: expected a missing certificate, got %v", location, err)
+		}
+		for _, name := range []string{"app.test.crt", "app.test.key", "account.key"} {
+			if err := store.put(name, []byte("pem of "+name)); err != nil {
+				t.Fatalf("%s: %v", location, err)
+			}
+		}
+		if data, err := store.get("app.test.key"); err != nil || string(data) != "pem of app.test.key" {
+			t.Fatalf("%s: unexpected key %q, %v", location, data, err)
+		}
+		if names, err := store.list(".crt"); err != nil || !slices.Equal(names, []string{"app.test.crt"}) {
+			t.Fatalf("%s: unexpected certificates %v, %v", location, names, err)
+		}
+	}
+	if _, err := parseCertStore("s3://bucket"); err == nil {
+		t.Fatal("expected an unknown store to be rejected")
+	}
+}
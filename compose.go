@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Hosts expected from compose files, answered with 503 until their containers start
+
+// Compose files whose services' hosts are routed at startup, separated by commas
+var composeFiles = os.Getenv("SUB2PORT_COMPOSE")
+
+// The parts of a compose service that configure its routes
+type composeService struct {
+	env    []string // KEY=value
+	labels map[string]string
+}
+
+// Route every host of the compose files' services to a held placeholder, so
+// monitoring and DNS can be set up before the stack is up
+func prewarmCompose() {
+	for _, path := range strings.Split(composeFiles, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		file, err := os.ReadFile(path)
+		if err != nil {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_COMPOSE: %w", err)))
+		}
+		services, err := parseCompose(file)
+		if err != nil {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_COMPOSE: %s: %w", path, err)))
+		}
+		table.Lock()
+		for _, name := range slices.Sorted(maps.Keys(services)) {
+			expectService(ContainerName(name), services[name])
+		}
+		table.Unlock()
+	}
+}
+
+// Bind a service's hosts to a placeholder held until a container of it starts,
+// while holding the table lock
+func expectService(name ContainerName, service *composeService) {
+	// SUB2PORT_FILE is a path in a container that doesn't exist yet.
+	env := slices.DeleteFunc(slices.Clone(service.env), func(pair string) bool {
+		return strings.HasPrefix(pair, "SUB2PORT_FILE=")
+	})
+	delete(service.labels, "sub2port.file")
+	vars := containerVars(nil, "", name, env, service.labels)
+	if vars["SUB2PORT"] == "" {
+		return
+	}
+	options := parseOptions(name, vars)
+	id := ContainerID("compose:" + string(name))
+	for _, entry := range strings.Split(vars["SUB2PORT"], ",") {
+		entry, _, _ = strings.Cut(entry, "->")
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host, _ := parseHostEntry(entry, "")
+		host, _ = splitTenant(host)
+		bindRoute(host, route{Name: name, Options: options, State: "expected"}, true)
+		table.containers[id] = append(table.containers[id], binding{Domain: host, Name: name})
+		log.Printf("# expecting %s from compose service %s", host, name)
+	}
+}
+
+// Read the environment and labels of each service from a compose file's YAML,
+// in block style, the way compose files are written
+func parseCompose(file []byte) (map[string]*composeService, error) {
+	services := make(map[string]*composeService)
+	var service *composeService
+	inServices := false
+	serviceIndent, keyIndent := -1, -1
+	section := "" // environment or labels, while reading its items
+	for number, line := range strings.Split(string(file), "\n") {
+		line = stripComment(strings.TrimRight(line, " \t\r"))
+		text := strings.TrimLeft(line, " ")
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't indent YAML", number+1)
+		}
+		indent := len(line) - len(text)
+		switch {
+		case indent == 0:
+			inServices, service = text == "services:", nil
+		case !inServices:
+		case serviceIndent < 0 || indent == serviceIndent:
+			name, rest, ok := strings.Cut(text, ":")
+			if !ok || strings.TrimSpace(rest) != "" {
+				return nil, fmt.Errorf("line %d: expected a service name", number+1)
+			}
+			serviceIndent, keyIndent, section = indent, -1, ""
+			service = &composeService{labels: make(map[string]string)}
+			services[unquoteYAML(name)] = service
+		case service == nil || indent < serviceIndent:
+		case keyIndent < 0 || indent == keyIndent:
+			key, value, _ := strings.Cut(text, ":")
+			keyIndent, section = indent, ""
+			if key := unquoteYAML(key); (key == "environment" || key == "labels") && strings.TrimSpace(value) == "" {
+				section = key
+			}
+		case indent > keyIndent && section != "":
+			var key, value string
+			if item, ok := strings.CutPrefix(text, "- "); ok {
+				key, value, _ = strings.Cut(unquoteYAML(item), "=")
+			} else {
+				key, value, _ = strings.Cut(text, ":")
+				key, value = unquoteYAML(key), unquoteYAML(value)
+			}
+			if section == "environment" {
+				service.env = append(service.env, key+"="+value)
+			} else {
+				service.labels[key] = value
+			}
+		}
+	}
+	return services, nil
+}
+
+// Cut a # comment outside of quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, char := range line {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case (char == '"' || char == '\'') && (i == 0 || strings.ContainsRune(" :-=", rune(line[i-1]))):
+			quote = char
+		case char == '#' && (i == 0 || line[i-1] == ' '):
+			return strings.TrimRight(line[:i], " ")
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '"' && value[len(value)-1] == '"':
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// Hosts of compose services answer 503 until their containers start
+func TestPrewarmCompose(t *testing.T) {
+	services, err := parseCompose([]byte(`
+version: "3"
+services:
+  web: # the frontend
+    image: nginx
+    environment:
+      SUB2PORT: "web.compose.test:80" # quoted
+      SUB2PORT_BRAND: Bob's shop
+    deploy:
+      labels:
+        sub2port.host: ignored.compose.test
+  api:
+    labels:
+      - sub2port.host=api.compose.test/v1
+      - "sub2port.read-only=true"
+  worker:
+    command: ["work"]
+networks:
+  default:
+    environment:
+      SUB2PORT: not-a-service.test
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 3 || !slices.Equal(services["web"].env, []string{"SUB2PORT=web.compose.test:80", "SUB2PORT_BRAND=Bob's shop"}) ||
+		services["api"].labels["sub2port.host"] != "api.compose.test/v1" || services["api"].labels["sub2port.read-only"] != "true" {
+		t.Fatalf("unexpected services %+v", services)
+	}
+	table.Lock()
+	for name, service := range services {
+		expectService(ContainerName(name), service)
+	}
+	table.Unlock()
+	t.Cleanup(func() {
+		for name := range services {
+			dropRoutes(ContainerID("compose:" + name))
+		}
+	})
+
+	for _, target := range []string{"http://web.compose.test/", "http://api.compose.test/v1/users"} {
+		recorder := httptest.NewRecorder()
+		proxy(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 from %s, got %d", target, recorder.Code)
+		}
+	}
+	if table.lookup("ignored.compose.test") != nil || table.lookup("not-a-service.test") != nil {
+		t.Fatal("expected only the services' container config to be routed")
+	}
+}
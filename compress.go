@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response compression for backends that don't compress, gzip only since the
+// standard library has no brotli encoder
+
+// Media types compressed by SUB2PORT_COMPRESS=true, as path.Match patterns
+var compressibleTypes = []string{
+	"text/*",
+	"application/json", "application/*+json",
+	"application/xml", "application/*+xml",
+	"application/javascript", "application/wasm",
+	"image/svg+xml",
+}
+
+// Parse SUB2PORT_COMPRESS, true or media type patterns separated by commas, nil when off
+func parseCompress(value string) []string {
+	switch value = strings.TrimSpace(value); value {
+	case "", "false":
+		return nil
+	case "true":
+		return compressibleTypes
+	}
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Whether the client takes gzip, e.g. "gzip, deflate, br" but not "gzip;q=0"
+func acceptsGzip(header http.Header) bool {
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if name = strings.TrimSpace(name); name != "gzip" && name != "*" {
+				continue
+			}
+			quality, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+			if q, err := strconv.ParseFloat(quality, 64); !ok || err != nil || q > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Worth compressing: a large enough body of a listed type that isn't already
+// encoded, and that isn't streamed to the client as it comes
+func compressible(response *http.Response, options *hostOptions) bool {
+	request := response.Request
+	switch {
+	case request.Method == http.MethodHead || response.StatusCode < 200 || response.StatusCode == http.StatusNoContent,
+		response.StatusCode == http.StatusNotModified || response.StatusCode == http.StatusPartialContent,
+		response.Header.Get("Content-Encoding") != "" || response.Uncompressed,
+		response.ContentLength >= 0 && response.ContentLength < int64(options.CompressMin),
+		strings.Contains(response.Header.Get("Cache-Control"), "no-transform"),
+		options.Buffer == "stream" || isGRPC(request) || !acceptsGzip(request.Header):
+		return false
+	}
+	return compressibleType(response.Header, options)
+}
+
+// Of a listed type, so whether it's compressed depends on the client's Accept-Encoding
+func compressibleType(header http.Header, options *hostOptions) bool {
+	media, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if media == "text/event-stream" {
+		return false
+	}
+	for _, pattern := range options.Compress {
+		if matched, _ := path.Match(pattern, media); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Tell caches that answers of a compressible type differ by Accept-Encoding,
+// the plain ones too, so they aren't served to clients that take gzip
+func varyEncoding(header http.Header) {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "*" || strings.EqualFold(name, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept-Encoding")
+}
+
+// Compress the response's body as the proxy copies it
+func compressResponse(response *http.Response) {
+	// The proxy flushes answers of unknown length as they come, so those are
+	// flushed through gzip too; the others compress in whole blocks.
+	flush := response.ContentLength < 0
+	header := response.Header
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	// The compressed body is no longer byte for byte what the tag named.
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	response.ContentLength = -1
+	body := &gzipBody{source: response.Body, writer: gzipWriters.Get().(*gzip.Writer), flush: flush}
+	body.writer.Reset(&body.buffer)
+	response.Body = body
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// Compresses the source as it is read, flushing each read when streamed answers shouldn't be held back
+type gzipBody struct {
+	source io.ReadCloser
+	writer *gzip.Writer // nil once returned to the pool
+	flush  bool
+	buffer bytes.Buffer
+	chunk  [32 * 1024]byte
+	ended  bool
+}
+
+func (body *gzipBody) Read(data []byte) (int, error) {
+	for body.buffer.Len() == 0 && !body.ended {
+		if body.writer == nil {
+			return 0, io.ErrClosedPipe
+		}
+		n, err := body.source.Read(body.chunk[:])
+		if n > 0 {
+			_, _ = body.writer.Write(body.chunk[:n])
+			if body.flush {
+				_ = body.writer.Flush()
+			}
+		}
+		if err == io.EOF {
+			_ = body.writer.Close()
+			body.ended = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if body.buffer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return body.buffer.Read(data)
+}
+
+func (body *gzipBody) Close() error {
+	if body.writer != nil {
+		gzipWriters.Put(body.writer)
+		body.writer = nil
+	}
+	return body.source.Close()
+}
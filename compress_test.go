@@ -0,0 +1,79 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>hello</p>", 400)
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/page":
+			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+			writer.Header().Set("ETag", `"v1"`)
+			_, _ = io.WriteString(writer, page)
+		case "/small":
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(writer, `{"ok":true}`)
+		case "/events":
+			writer.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(writer, strings.Repeat("data: tick\n\n", 200))
+		}
+	}))
+	t.Cleanup(backend.Close)
+	routeTo(t, "gzip.test", backend, &hostOptions{Compress: parseCompress("true"), CompressMin: 1024})
+
+	get := func(path, accept string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "http://gzip.test"+path, nil)
+		request.Header.Set("Accept-Encoding", accept)
+		recorder := httptest.NewRecorder()
+		proxy(recorder, request)
+		return recorder.Result()
+	}
+	response := get("/page", "br, gzip")
+	if response.Header.Get("Content-Encoding") != "gzip" || response.Header.Get("ETag") != `W/"v1"` || response.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzipped page, got %v", response.Header)
+	}
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(reader); err != nil || string(body) != page {
+		t.Fatalf("expected the page back, got %d bytes, %v", len(body), err)
+	}
+	for path, accept := range map[string]string{"/page": "gzip;q=0, br", "/small": "gzip", "/events": "gzip"} {
+		if response := get(path, accept); response.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s with %q: expected no compression, got %v", path, accept, response.Header)
+		}
+	}
+	// Plain answers of compressible types vary too, so caches don't hand them to gzip clients.
+	for path, want := range map[string]string{"/page": "Accept-Encoding", "/small": "Accept-Encoding", "/events": ""} {
+		if vary := get(path, "identity").Header.Get("Vary"); vary != want {
+			t.Errorf("%s: expected Vary %q, got %q", path, want, vary)
+		}
+	}
+}
+
+// Answers with a length compress in whole blocks, since the proxy doesn't flush them anyway
+func TestCompressFlush(t *testing.T) {
+	page := strings.Repeat("<p>hello</p>", 400)
+	sizes := make(map[int64]int)
+	for _, length := range []int64{int64(len(page)), -1} {
+		response := &http.Response{Header: http.Header{}, ContentLength: length, Body: io.NopCloser(iotest.OneByteReader(strings.NewReader(page)))}
+		compressResponse(response)
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[length] = len(body)
+	}
+	if sizes[int64(len(page))] > 100 || sizes[-1] < len(page) {
+		t.Fatalf("expected only the answer of unknown length flushed on every read, got %v", sizes)
+	}
+}
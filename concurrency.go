@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adaptive concurrency: each backend's limit on requests in flight grows by
+// one per round of fast answers (additive increase), and shrinks by a share
+// when answers slow down or it says it's overloaded (multiplicative decrease).
+
+type concurrencyLimit struct {
+	min, max int
+}
+
+const (
+	initialConcurrency = 20
+	// Answers this many times slower than the backend's baseline mean it's queueing.
+	concurrencyTolerance = 2
+	concurrencyBackoff   = 0.9
+)
+
+// Parse SUB2PORT_CONCURRENCY, "adaptive [min=<n>] [max=<n>]"
+func parseConcurrency(value string) (*concurrencyLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if fields[0] != "adaptive" {
+		return nil, fmt.Errorf("expected adaptive, got %q", fields[0])
+	}
+	limit := &concurrencyLimit{min: 1, max: 1000}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("expected a positive %s, got %q", key, value)
+		}
+		switch key {
+		case "min":
+			limit.min = n
+		case "max":
+			limit.max = n
+		default:
+			return nil, fmt.Errorf("unknown setting %q, expected min= or max=", field)
+		}
+	}
+	if limit.min > limit.max {
+		return nil, fmt.Errorf("min=%d is over max=%d", limit.min, limit.max)
+	}
+	return limit, nil
+}
+
+// A backend's limit, shared by copies of its route
+type aimdLimiter struct {
+	sync.Mutex
+	bounds    concurrencyLimit
+	limit     float64
+	baseline  time.Duration // the latency of the unloaded backend, following its fastest answers
+	decreased time.Time
+}
+
+func newAIMDLimiter(bounds concurrencyLimit) *aimdLimiter {
+	return &aimdLimiter{bounds: bounds, limit: float64(min(max(initialConcurrency, bounds.min), bounds.max))}
+}
+
+func (limiter *aimdLimiter) current() int {
+	limiter.Lock()
+	defer limiter.Unlock()
+	return int(limiter.limit)
+}
+
+// Adjust the limit to an answer that took latency, or failed with overloaded
+func (limiter *aimdLimiter) observe(latency time.Duration, overloaded bool, now time.Time) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	if !overloaded {
+		if limiter.baseline == 0 || latency < limiter.baseline {
+			limiter.baseline = latency
+		} else {
+			// Drift up slowly, so a backend that got slower for good isn't held to its old best.
+			limiter.baseline += (latency - limiter.baseline) / 1000
+		}
+		overloaded = latency > concurrencyTolerance*limiter.baseline
+	}
+	if !overloaded {
+		// One more per limit's worth of answers, i.e. per round trip at the limit.
+		limiter.limit = math.Min(limiter.limit+1/limiter.limit, float64(limiter.bounds.max))
+		return
+	}
+	// Answers already in flight when the limit dropped shouldn't drop it again.
+	if now.Sub(limiter.decreased) < latency {
+		return
+	}
+	limiter.decreased = now
+	limiter.limit = math.Max(limiter.limit*concurrencyBackoff, float64(limiter.bounds.min))
+}
+
+// Answers slower than this are taken for long polls, which wait for news rather than for room
+const longPollLatency = 10 * time.Second
+
+// An answer whose latency says how loaded the backend is, unlike streams and
+// long polls, which answer when there's something to send
+func loadSample(response *http.Response, options *hostOptions, latency time.Duration) bool {
+	request := response.Request
+	if latency > longPollLatency || options.Buffer == "stream" || isGRPC(request) || request.Header.Get("Upgrade") != "" {
+		return false
+	}
+	media, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	return media != "text/event-stream"
+}
+
+// Statuses of a backend shedding load
+func overloadStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// Count another request in flight on the backend, unless it's at its limit;
+// the count is checked and taken at once, so racing requests can't overshoot it
+func (backend route) admit() bool {
+	for {
+		active := backend.inflight.Load()
+		if backend.limiter != nil && active >= int64(backend.limiter.current()) {
+			return false
+		}
+		if backend.inflight.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
+
+// Hold an alternate backend's count until its answer is copied
+type releaseOnClose struct {
+	io.ReadCloser
+	backend route
+	once    sync.Once
+}
+
+func (body *releaseOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(func() { body.backend.inflight.Add(-1) })
+	return err
+}
+
+var concurrencyLimited = newCounterVec("sub2port_concurrency_limited_total", "Requests rejected with 503 because every backend was at its adaptive concurrency limit.", "host")
+
+// Admit the picked backend, or the next one with room, or false when all are full
+func admitBackend(backends []route, index uint64) (uint64, bool) {
+	for i := range uint64(len(backends)) {
+		if next := (index + i) % uint64(len(backends)); backends[next].admit() {
+			return next, true
+		}
+	}
+	return index, false
+}
+
+func init() {
+	registerMetric(&metricFamily{
+		name:   "sub2port_concurrency_limit",
+		kind:   "gauge",
+		help:   "Requests each backend with SUB2PORT_CONCURRENCY=adaptive may have in flight.",
+		labels: []string{"host", "backend"},
+		collect: func(emit func(float64, ...string)) {
+			table.hosts.Range(func(key, value any) bool {
+				pool := value.(*hostEntry).pool.Load()
+				for _, route := range slices.Concat(pool.backends, pool.held) {
+					if route.limiter != nil {
+						emit(float64(route.limiter.current()), string(key.(HostName)), string(route.Name))
+					}
+				}
+				return true
+			})
+		},
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	bounds, err := parseConcurrency("adaptive min=5 max=30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := newAIMDLimiter(*bounds)
+	now := time.Now()
+	// Fast answers raise the limit by about one per limit's worth of them.
+	for range 21 {
+		limiter.observe(10*time.Millisecond, false, now)
+	}
+	if limit := limiter.current(); limit != 21 {
+		t.Fatalf("expected the limit to grow to 21, got %d", limit)
+	}
+	// A slow answer drops it once, and not again for answers already in flight.
+	limiter.observe(50*time.Millisecond, false, now)
+	limiter.observe(50*time.Millisecond, false, now.Add(10*time.Millisecond))
+	if limit := limiter.current(); limit != 18 {
+		t.Fatalf("expected one decrease to 18, got %d", limit)
+	}
+	for i := range 50 {
+		limiter.observe(time.Millisecond, true, now.Add(time.Duration(i+1)*time.Second))
+	}
+	if limit := limiter.current(); limit != 5 {
+		t.Fatalf("expected the limit to stop at min=5, got %d", limit)
+	}
+
+	full, free := new(inflight), new(inflight)
+	full.Store(5)
+	backends := []route{{Name: "full", inflight: full, limiter: limiter}, {Name: "free", inflight: free, limiter: limiter}}
+	if index, ok := admitBackend(backends, 0); !ok || index != 1 {
+		t.Fatalf("expected the free backend, got %d %v", index, ok)
+	}
+	free.Store(5)
+	if _, ok := admitBackend(backends, 0); ok {
+		t.Fatal("expected every backend to be full")
+	}
+	for _, bad := range []string{"static", "adaptive min=0", "adaptive min=10 max=5", "adaptive step=2"} {
+		if _, err := parseConcurrency(bad); err == nil {
+			t.Errorf("expected %q to fail", bad)
+		}
+	}
+}
+
+// A retried request charges the backend that answered, not the one that couldn't be dialed
+func TestConcurrencyChargesAnsweringBackend(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(up.Close)
+	options := &hostOptions{Retries: 1, Concurrency: &concurrencyLimit{min: 1, max: 100}}
+	downName := routeTo(t, "charged.test", down, options)
+	routeTo(t, "charged.test", up, options)
+
+	for range 2 {
+		recorder := httptest.NewRecorder()
+		proxy(recorder, httptest.NewRequest(http.MethodGet, "http://charged.test/", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected the retry to be answered, got %d", recorder.Code)
+		}
+	}
+	for _, backend := range table.lookup("charged.test").pool.Load().backends {
+		backend.limiter.Lock()
+		observed := backend.limiter.baseline > 0
+		backend.limiter.Unlock()
+		if observed != (backend.Name != downName) {
+			t.Errorf("%s: expected only the answering backend to be observed, got %v", backend.Name, observed)
+		}
+		if active := backend.active(); active != 0 {
+			t.Errorf("%s: expected every slot given back, got %d in flight", backend.Name, active)
+		}
+	}
+}
+
+// Racing requests can't take more slots than the limit, and retries take one too
+func TestConcurrencyAdmission(t *testing.T) {
+	limiter := newAIMDLimiter(concurrencyLimit{min: 5, max: 5})
+	backend := route{Name: "racing", inflight: new(inflight), limiter: limiter}
+	var admitted atomic.Int32
+	var wait sync.WaitGroup
+	for range 100 {
+		wait.Go(func() {
+			if backend.admit() {
+				admitted.Add(1)
+			}
+		})
+	}
+	wait.Wait()
+	if admitted.Load() != 5 || backend.active() != 5 {
+		t.Fatalf("expected 5 admitted, got %d with %d in flight", admitted.Load(), backend.active())
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(up.Close)
+	address := func(server *httptest.Server, name ContainerName, inflight *inflight) route {
+		host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		return route{Name: name, Host: host, Port: port, Options: &hostOptions{Scheme: "http"}, inflight: inflight, limiter: limiter}
+	}
+	full := backend.inflight
+	retry := &retryTransport{host: "admission.test", backends: []route{address(down, "down", new(inflight)), address(up, "full", full)}, retries: 1}
+	request := matchedTo(httptest.NewRequest(http.MethodGet, "http://"+down.Listener.Addr().String()+"/", nil), "admission.test", &hostOptions{})
+	request.RequestURI = ""
+	if _, err := retry.RoundTrip(request); err == nil || full.Load() != 5 {
+		t.Fatalf("expected no retry on the full backend, got %v with %d in flight", err, full.Load())
+	}
+}
+
+// Streams and long polls answer when there's news, which says nothing about load
+func TestLoadSample(t *testing.T) {
+	for _, test := range []struct {
+		media   string
+		latency time.Duration
+		want    bool
+	}{
+		{"application/json", time.Millisecond, true},
+		{"text/event-stream; charset=utf-8", time.Millisecond, false},
+		{"application/json", time.Minute, false},
+	} {
+		response := &http.Response{Request: httptest.NewRequest(http.MethodGet, "/", nil), Header: http.Header{"Content-Type": {test.media}}}
+		if got := loadSample(response, &hostOptions{}, test.latency); got != test.want {
+			t.Errorf("%s after %s: expected %v, got %v", test.media, test.latency, test.want, got)
+		}
+	}
+}
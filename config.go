@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Container config
+
+// Longest SUB2PORT_FILE read from a container
+const maxConfigFile = 1 << 20
+
+// Collect a container's SUB2PORT* vars from its env, labels, and SUB2PORT_FILE
+func containerVars(daemon *dockerDaemon, containerID ContainerID, name ContainerName, env []string, labels map[string]string) map[string]string {
+	vars := make(map[string]string)
+	for _, pair := range env {
+		if key, value, ok := strings.Cut(pair, "="); ok && strings.HasPrefix(key, "SUB2PORT") {
+			vars[key] = value
+		}
+	}
+
+	// Env vars win over labels.
+	for label, value := range labels {
+		if key := labelVar(label); key != "" {
+			if _, ok := vars[key]; !ok {
+				vars[key] = value
+			}
+		}
+	}
+
+	// Env vars and labels win over the file.
+	if path := strings.TrimSpace(vars["SUB2PORT_FILE"]); path != "" {
+		file, err := daemon.readFile(containerID, path)
+		if err != nil {
+			log.Printf("%s: SUB2PORT_FILE: %v", name, err)
+		}
+		for key, value := range parseConfigFile(file) {
+			if _, ok := vars[key]; !ok {
+				vars[key] = value
+			}
+		}
+	}
+
+	// SUB2PORT_0..N append to SUB2PORT in numeric order.
+	var numbers []int
+	for key := range vars {
+		if number, err := strconv.Atoi(strings.TrimPrefix(key, "SUB2PORT_")); err == nil && number >= 0 {
+			numbers = append(numbers, number)
+		}
+	}
+	slices.Sort(numbers)
+	entries := []string{}
+	if config := strings.TrimSpace(vars["SUB2PORT"]); config != "" {
+		entries = append(entries, config)
+	}
+	for _, number := range numbers {
+		key := "SUB2PORT_" + strconv.Itoa(number)
+		if value := strings.TrimSpace(vars[key]); value != "" {
+			entries = append(entries, value)
+		}
+		delete(vars, key)
+	}
+	if len(entries) > 0 {
+		vars["SUB2PORT"] = strings.Join(entries, ",")
+	}
+	return vars
+}
+
+// Map a sub2port.<option> label to its SUB2PORT_<OPTION> var, e.g. sub2port.read-only
+func labelVar(label string) string {
+	option, ok := strings.CutPrefix(label, "sub2port.")
+	if !ok || option == "" {
+		return ""
+	}
+	if option == "host" {
+		return "SUB2PORT"
+	}
+	return "SUB2PORT_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(option))
+}
+
+// Parse a config file of host entries and SUB2PORT_<OPTION>=<value> lines
+func parseConfigFile(file []byte) map[string]string {
+	vars := make(map[string]string)
+	var hosts []string
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && strings.HasPrefix(key, "SUB2PORT") {
+			vars[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if config := vars["SUB2PORT"]; config != "" {
+		hosts = append(hosts, config)
+	}
+	if len(hosts) > 0 {
+		vars["SUB2PORT"] = strings.Join(hosts, ",")
+	}
+	return vars
+}
+
+// Read a file out of a container
+func (daemon *dockerDaemon) readFile(containerID ContainerID, path string) ([]byte, error) {
+	daemon.throttle()
+	query := url.Values{"path": {path}}
+	response, err := daemon.client.Get(daemon.base + "/containers/" + string(containerID) + "/archive?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", path, response.Status)
+	}
+	archive := tar.NewReader(response.Body)
+	header, err := archive.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil, fmt.Errorf("%s: not a regular file", path)
+	}
+	if header.Size > maxConfigFile {
+		return nil, fmt.Errorf("%s: larger than %d bytes", path, maxConfigFile)
+	}
+	return io.ReadAll(archive)
+}
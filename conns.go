@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Client connection limits
+
+var idleTimeout = envDuration("SUB2PORT_IDLE_TIMEOUT", 2*time.Minute)
+
+// Slow clients can't hold connections open by trickling in their headers.
+var readHeaderTimeout = envDuration("SUB2PORT_READ_HEADER_TIMEOUT", 10*time.Second)
+
+// Whole requests and responses, unlimited by default for uploads, downloads, and streams
+var readTimeout = envDuration("SUB2PORT_READ_TIMEOUT", 0)
+var writeTimeout = envDuration("SUB2PORT_WRITE_TIMEOUT", 0)
+
+var maxHeaderBytes = envInt("SUB2PORT_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes)
+var maxConnLifetime = envDuration("SUB2PORT_MAX_CONN_LIFETIME", 0)
+var maxConns = envInt("SUB2PORT_MAX_CONNS", 0)
+
+type clientConn struct {
+	opened time.Time
+	state  http.ConnState
+}
+
+var clientConns = struct {
+	sync.Mutex
+	conns map[net.Conn]*clientConn
+}{conns: make(map[net.Conn]*clientConn)}
+
+var reapedConns = newCounterVec("sub2port_client_connections_reaped_total", "Client connections closed by the proxy.", "reason")
+
+func init() {
+	registerMetric(&metricFamily{
+		name:   "sub2port_client_connections",
+		kind:   "gauge",
+		help:   "Open client connections.",
+		labels: []string{"state"},
+		collect: func(emit func(float64, ...string)) {
+			counts := make(map[http.ConnState]int)
+			clientConns.Lock()
+			for _, conn := range clientConns.conns {
+				counts[conn.state]++
+			}
+			clientConns.Unlock()
+			emit(float64(counts[http.StateNew]+counts[http.StateActive]), "active")
+			emit(float64(counts[http.StateIdle]), "idle")
+		},
+	})
+}
+
+// Track client connections, closing ones that outlived the max lifetime once idle
+func trackConn(conn net.Conn, state http.ConnState) {
+	clientConns.Lock()
+	defer clientConns.Unlock()
+	switch state {
+	case http.StateNew:
+		clientConns.conns[conn] = &clientConn{opened: time.Now(), state: state}
+	case http.StateClosed, http.StateHijacked:
+		delete(clientConns.conns, conn)
+	default:
+		tracked := clientConns.conns[conn]
+		if tracked == nil {
+			return
+		}
+		tracked.state = state
+		if state == http.StateIdle && maxConnLifetime > 0 && time.Since(tracked.opened) > maxConnLifetime {
+			reapedConns.Inc("lifetime")
+			_ = conn.Close()
+		}
+	}
+}
+
+// Close the longest idle-capable connection to make room for a new client
+func reapIdleConn() bool {
+	clientConns.Lock()
+	defer clientConns.Unlock()
+	var oldest net.Conn
+	var opened time.Time
+	for conn, tracked := range clientConns.conns {
+		if tracked.state == http.StateIdle && (oldest == nil || tracked.opened.Before(opened)) {
+			oldest, opened = conn, tracked.opened
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	reapedConns.Inc("limit")
+	_ = oldest.Close()
+	return true
+}
+
+// Accept at most limit connections at once, reaping idle ones at the limit
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func (listener *limitListener) Accept() (net.Conn, error) {
+	select {
+	case listener.slots <- struct{}{}:
+	default:
+		reapIdleConn()
+		listener.slots <- struct{}{}
+	}
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		<-listener.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: sync.OnceFunc(func() { <-listener.slots })}, nil
+}
+
+type limitConn struct {
+	net.Conn
+	release func()
+}
+
+func (conn *limitConn) Close() error {
+	err := conn.Conn.Close()
+	conn.release()
+	return err
+}
+
+func listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil || maxConns <= 0 {
+		return listener, err
+	}
+	return &limitListener{Listener: listener, slots: make(chan struct{}, maxConns)}, nil
+}
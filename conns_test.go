@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerLimits(t *testing.T) {
+	if server.ReadHeaderTimeout != 10*time.Second || server.ReadTimeout != 0 || server.WriteTimeout != 0 || server.MaxHeaderBytes != http.DefaultMaxHeaderBytes {
+		t.Fatalf("unexpected defaults: read header %s, read %s, write %s, %d header bytes", server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.MaxHeaderBytes)
+	}
+	// A client that trickles in its headers is cut off.
+	front := httptest.NewUnstartedServer(server.Handler)
+	front.Config.ReadHeaderTimeout = 50 * time.Millisecond
+	front.Start()
+	t.Cleanup(front.Close)
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: slow.test\r\n")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+
+	// A backend that never answers is given up on.
+	hung := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { time.Sleep(time.Second) }))
+	t.Cleanup(hung.Close)
+	transport := sharedTransport.Clone()
+	transport.ResponseHeaderTimeout = 50 * time.Millisecond
+	routeTo(t, "hung.test", hung, &hostOptions{Transport: transport})
+	recorder := httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://hung.test/", nil))
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", recorder.Code)
+	}
+}
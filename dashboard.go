@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status dashboard on the admin listener
+
+type routeChange struct {
+	Time      time.Time     `json:"time"`
+	Change    string        `json:"change"` // + or -
+	Host      HostName      `json:"host"`
+	Backends  int           `json:"backends"` // left on the host
+	Container ContainerName `json:"container"`
+	Port      string        `json:"port"`
+}
+
+// The latest route changes, oldest first
+var recentChanges struct {
+	sync.Mutex
+	changes []routeChange
+}
+
+const keptChanges = 100
+
+func recordChange(change routeChange) {
+	recentChanges.Lock()
+	defer recentChanges.Unlock()
+	if len(recentChanges.changes) == keptChanges {
+		recentChanges.changes = slices.Delete(recentChanges.changes, 0, 1)
+	}
+	recentChanges.changes = append(recentChanges.changes, change)
+}
+
+// The latest route changes, newest first
+func latestChanges() []routeChange {
+	recentChanges.Lock()
+	defer recentChanges.Unlock()
+	changes := slices.Clone(recentChanges.changes)
+	slices.Reverse(changes)
+	return changes
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"percent": func(share float64) string { return fmt.Sprintf("%.1f%%", share*100) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>sub2port</title>
+<style>
+body { font: 14px system-ui, sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.3em 1em 0.3em 0; text-align: left; vertical-align: top; }
+th { border-bottom: 1px solid #888; }
+.held { color: #b60; }
+.removed { color: #a00; }
+</style>
+</head>
+<body>
+<h1>sub2port</h1>
+<h2>Hosts ({{len .Hosts}})</h2>
+<table>
+<tr><th>Host</th><th>Backend</th><th>Address</th><th>Node</th><th>State</th><th>Weight</th><th>Share</th><th>Image</th></tr>
+{{range .Hosts}}{{$host := .Host}}{{range .Backends}}<tr{{if .Held}} class="held"{{end}}>
+<td>{{$host}}</td><td>{{.Name}}</td><td>{{.Address}}</td><td>{{.Node}}</td><td>{{.State}}</td><td>{{.Weight}}</td><td>{{percent .Share}}</td><td>{{.Image}}</td>
+</tr>
+{{end}}{{end}}</table>
+<h2>Recent changes</h2>
+<table>
+<tr><th>Time</th><th>Host</th><th>Backend</th><th>Backends left</th></tr>
+{{range .Changes}}<tr{{if eq .Change "-"}} class="removed"{{end}}>
+<td>{{.Time.Format "15:04:05"}}</td><td>{{.Change}} {{.Host}}</td><td>{{.Container}}:{{.Port}}</td><td>{{.Backends}}</td>
+</tr>
+{{else}}<tr><td colspan="4">None since the proxy started</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func init() {
+	// What is routable at a glance, refreshed every few seconds
+	adminMux.HandleFunc("GET /{$}", func(writer http.ResponseWriter, _ *http.Request) {
+		hosts := []hostView{}
+		table.hosts.Range(func(key, value any) bool {
+			hosts = append(hosts, viewHost(key.(HostName), value.(*hostEntry).pool.Load()))
+			return true
+		})
+		slices.SortFunc(hosts, func(a, b hostView) int {
+			return strings.Compare(string(a.Host), string(b.Host))
+		})
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := dashboardTemplate.Execute(writer, struct {
+			Hosts   []hostView
+			Changes []routeChange
+		}{hosts, latestChanges()})
+		if err != nil {
+			log.Printf("dashboard: %v", err)
+		}
+	})
+	adminMux.HandleFunc("GET /changes", func(writer http.ResponseWriter, _ *http.Request) {
+		writeJSON(writer, http.StatusOK, latestChanges())
+	})
+}
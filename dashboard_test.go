@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	table.Lock()
+	bindRoute("dashboard.test", route{Name: "dashboard", Host: "10.0.0.9", Port: "8080", Options: &hostOptions{}, State: "routed"}, false)
+	bindRoute("dashboard.test", route{Name: "dashboard-new", Host: "10.0.0.10", Port: "8080", Options: &hostOptions{}, State: "starting"}, true)
+	table.containers["dashboard"] = []binding{{Domain: "dashboard.test", Name: "dashboard"}}
+	table.containers["dashboard-new"] = []binding{{Domain: "dashboard.test", Name: "dashboard-new"}}
+	logRoute("+", "dashboard.test", 1, "dashboard", "8080", "")
+	table.Unlock()
+	t.Cleanup(func() {
+		dropRoutes("dashboard")
+		dropRoutes("dashboard-new")
+	})
+
+	recorder := httptest.NewRecorder()
+	adminMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	page := recorder.Body.String()
+	for _, want := range []string{"<td>dashboard.test</td><td>dashboard</td><td>10.0.0.9:8080</td>", "<td>starting</td>", "dashboard.test</td><td>dashboard:8080</td>"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected the dashboard to show %q, got:\n%s", want, page)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	adminMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/changes", nil))
+	if !strings.HasPrefix(recorder.Body.String(), `[{"time":`) || !strings.Contains(recorder.Body.String(), `"container":"dashboard"`) {
+		t.Fatalf("expected the latest changes, got %s", recorder.Body)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Per-request debug logs
+
+// Requests with this token in X-Sub2port-Debug log every proxy decision
+var debugToken = os.Getenv("SUB2PORT_DEBUG_TOKEN")
+
+// Hosts whose every request is traced, toggled through the admin API
+var debugHosts sync.Map // HostName -> true
+
+// The decisions of one traced request, logged as "# debug <id> +<elapsed> ..."
+type debugTrace struct {
+	id      string
+	started time.Time
+}
+
+func init() {
+	adminMux.HandleFunc("PUT /hosts/{host}/debug", func(writer http.ResponseWriter, request *http.Request) {
+		var enabled bool
+		if err := json.NewDecoder(request.Body).Decode(&enabled); err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "expected true or false"})
+			return
+		}
+		if enabled {
+			debugHosts.Store(HostName(request.PathValue("host")), true)
+		} else {
+			debugHosts.Delete(HostName(request.PathValue("host")))
+		}
+		writeJSON(writer, http.StatusOK, map[string]bool{"debug": enabled})
+	})
+}
+
+// Start a trace if the request carries the debug token or its host is traced
+func startTrace(writer http.ResponseWriter, request *http.Request, host HostName) *debugTrace {
+	var traced bool
+	if debugToken != "" {
+		if value := request.Header.Get("X-Sub2port-Debug"); value != "" {
+			traced = subtle.ConstantTimeCompare([]byte(value), []byte(debugToken)) == 1
+			// The token is for the proxy, not the backend.
+			request.Header.Del("X-Sub2port-Debug")
+		}
+	}
+	if _, ok := debugHosts.Load(host); !ok && !traced {
+		return nil
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	trace := &debugTrace{id: hex.EncodeToString(id), started: time.Now()}
+	writer.Header().Set("X-Sub2port-Debug-Id", trace.id)
+	trace.log("%s %s%s from %s, routed as %s", request.Method, request.Host, request.URL.RequestURI(), request.RemoteAddr, host)
+	return trace
+}
+
+// Log a decision, if the request is traced
+func (trace *debugTrace) log(format string, args ...any) {
+	if trace == nil {
+		return
+	}
+	elapsed := time.Since(trace.started).Round(time.Microsecond)
+	log.Printf("# debug %s +%s "+format, append([]any{trace.id, elapsed}, args...)...)
+}
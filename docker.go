@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Docker API
+
+type dockerContainer struct {
+	ID ContainerID `json:"Id"`
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         ContainerID       `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+type dockerInspect struct {
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+		Health  *struct {
+			Status string `json:"Status"` // starting, healthy, or unhealthy
+		} `json:"Health"` // nil without a HEALTHCHECK
+	} `json:"State"`
+	Config struct {
+		Image        string              `json:"Image"`
+		Env          []string            `json:"Env"`
+		Labels       map[string]string   `json:"Labels"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	} `json:"Config"`
+	HostConfig struct {
+		NanoCpus  int64 `json:"NanoCpus"`
+		CpuQuota  int64 `json:"CpuQuota"`
+		CpuPeriod int64 `json:"CpuPeriod"`
+		Memory    int64 `json:"Memory"` // bytes, 0 when unlimited
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// The host port a container port is published on, if any
+func (container *dockerInspect) publishedPort(port string) string {
+	for _, binding := range container.NetworkSettings.Ports[port+"/tcp"] {
+		// Loopback bindings are not reachable from other hosts or containers.
+		if ip := net.ParseIP(binding.HostIP); ip != nil && ip.IsLoopback() {
+			continue
+		}
+		if binding.HostPort != "" {
+			return binding.HostPort
+		}
+	}
+	return ""
+}
+
+// A Docker daemon whose containers are routed
+type dockerDaemon struct {
+	Endpoint  string // as configured, e.g. tcp://10.0.0.2:2375
+	Addr      string // the host address of published ports, empty to skip off-network containers
+	Connect   bool   // connect off-network containers to the proxy network
+	base      string // the API base URL
+	client    *http.Client
+	transport *http.Transport // of the client
+
+	joined sync.Map // containers connected to the network by sub2port
+	died   sync.Map // containers whose die event removed their routes, before the stop that may follow
+
+	limiter  *tokenBucket // shared by every API call except the event stream
+	inflight sync.Mutex
+	inspects map[ContainerID]*inspectCall // in flight, shared by duplicate callers
+	cache    sync.Map                     // ContainerID -> cachedInspect, dropped by invalidate
+
+	cancelLock sync.Mutex
+	cancel     context.CancelFunc // stops the running event loop
+
+	scanning sync.Mutex // one scan at a time, from the event loop or reconciliation
+
+	info atomic.Pointer[dockerInfo] // the docker host's name, labels, and resources, fetched once
+
+	queued    atomic.Int64 // events waiting for a worker
+	connected atomic.Bool  // scanned and listening for events
+	lastSeen  atomic.Int64 // unix nanos of the last event or resync
+	lastEvent atomic.Int64 // daemon unix nanos of the last event read, where a reconnect resumes
+	pingOK    atomic.Bool  // answered the last ping
+}
+
+// localDaemon talks to the Docker daemon at DOCKER_HOST, over the unix socket by default.
+var localDaemon = newLocalDaemon()
+
+func init() {
+	// Reach ports published by off-network containers through the docker host. DOCKER_HOST
+	// often names a socket proxy rather than the host, so only an explicit gateway is used.
+	localDaemon.Addr = os.Getenv("SUB2PORT_HOST_GATEWAY")
+	localDaemon.Connect = envBool("SUB2PORT_AUTO_CONNECT")
+}
+
+// Every daemon being watched, local first
+var daemons = []*dockerDaemon{localDaemon}
+
+var eventsQuery = dockerQuery("/events", map[string][]string{
+	"type":  {"container"},
+	"event": {"start", "stop", "die", "destroy", "rename", "update", "health_status"},
+})
+
+// Docker API calls per second and burst allowed for each daemon
+var dockerRate = envInt("SUB2PORT_DOCKER_RATE", 20)
+var dockerBurst = envInt("SUB2PORT_DOCKER_BURST", 50)
+
+// Containers inspected at once while scanning
+var scanWorkers = max(envInt("SUB2PORT_SCAN_WORKERS", 8), 1)
+
+// Events buffered between the stream and the workers that apply them
+var eventQueue = max(envInt("SUB2PORT_EVENT_QUEUE", 1024), 1)
+var eventWorkers = max(envInt("SUB2PORT_EVENT_WORKERS", 4), 1)
+
+// The longest an inspect is reused without an event from its container
+var inspectCacheTTL = envDuration("SUB2PORT_INSPECT_CACHE_TTL", time.Minute)
+
+var dockerThrottled = newCounterVec("sub2port_docker_throttled_total", "Docker API calls delayed by the rate limit.", "daemon")
+var dockerCoalesced = newCounterVec("sub2port_docker_coalesced_total", "Container inspects answered by an identical call in flight.", "daemon")
+var inspectCache = newCounterVec("sub2port_inspect_cache_total", "Container inspects by cache result.", "daemon", "result")
+var eventsBlocked = newCounterVec("sub2port_events_blocked_total", "Events that waited for room in a full event queue.", "daemon")
+
+func init() {
+	registerMetric(&metricFamily{
+		name:   "sub2port_events_queued",
+		kind:   "gauge",
+		help:   "Events read from the stream and not yet applied.",
+		labels: []string{"daemon"},
+		collect: func(emit func(float64, ...string)) {
+			for _, daemon := range daemons {
+				emit(float64(daemon.queued.Load()), daemon.Endpoint)
+			}
+		},
+	})
+}
+
+func newDockerDaemon(endpoint string) (*dockerDaemon, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	daemon := &dockerDaemon{
+		Endpoint: endpoint,
+		limiter:  newTokenBucket(float64(dockerRate), dockerBurst),
+		inspects: make(map[ContainerID]*inspectCall),
+	}
+	daemon.transport = http.DefaultTransport.(*http.Transport).Clone()
+	switch endpointURL.Scheme {
+	case "unix":
+		socket := endpointURL.Path
+		daemon.base = "http://localhost"
+		daemon.transport.Proxy = nil
+		daemon.transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	case "tcp":
+		daemon.Addr = endpointURL.Hostname()
+		daemon.base = "http://" + endpointURL.Host
+	case "ssh":
+		daemon.Addr = endpointURL.Hostname()
+		daemon.base = "http://" + endpointURL.Hostname()
+		daemon.transport.Proxy = nil
+		daemon.transport.DialContext = sshDialer(endpointURL)
+	default:
+		return nil, fmt.Errorf("unsupported docker endpoint %q", endpoint)
+	}
+	daemon.client = &http.Client{Transport: daemon.transport}
+	return daemon, nil
+}
+
+func (daemon *dockerDaemon) String() string {
+	return daemon.Endpoint
+}
+
+var errNotFound = errors.New("not found")
+
+func (daemon *dockerDaemon) throttle() {
+	if waited, _ := daemon.limiter.wait(context.Background()); waited {
+		dockerThrottled.Inc(daemon.Endpoint)
+	}
+}
+
+func (daemon *dockerDaemon) get(path string, out interface{}) error {
+	daemon.throttle()
+	response, err := daemon.client.Get(daemon.base + path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", path, errNotFound)
+	}
+	if debugLogging() {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		slog.Debug(fmt.Sprintf("# docker %s GET %s: %s", daemon, path, bytes.TrimSpace(body)),
+			"daemon", daemon.Endpoint, "path", path, "response", json.RawMessage(body))
+		return json.Unmarshal(body, out)
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+func (daemon *dockerDaemon) post(path string, in interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	daemon.throttle()
+	response, err := daemon.client.Post(daemon.base+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		var message struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&message)
+		return fmt.Errorf("%s: %s", response.Status, message.Message)
+	}
+	return nil
+}
+
+// Connect a container to the proxy network and return its address there
+func (daemon *dockerDaemon) connect(containerID ContainerID) (string, error) {
+	err := daemon.post("/networks/"+url.PathEscape(networkName)+"/connect", map[string]string{
+		"Container": string(containerID),
+	})
+	if err != nil {
+		return "", err
+	}
+	daemon.joined.Store(containerID, true)
+	daemon.invalidate(containerID)
+
+	container, err := daemon.inspect(containerID)
+	if err != nil {
+		return "", err
+	}
+	return container.NetworkSettings.Networks[networkName].IPAddress, nil
+}
+
+// Undo connect once the container's routes are removed
+func (daemon *dockerDaemon) disconnect(containerID ContainerID) {
+	err := daemon.post("/networks/"+url.PathEscape(networkName)+"/disconnect", map[string]string{
+		"Container": string(containerID),
+	})
+	if err != nil {
+		log.Printf("disconnect %s: %v", containerID[:12], err)
+	}
+	daemon.invalidate(containerID)
+}
+
+type inspectCall struct {
+	done      chan struct{}
+	container dockerInspect
+	err       error
+	stale     bool // the container changed while it was in flight, so it isn't cached or shared
+}
+
+type cachedInspect struct {
+	container dockerInspect
+	at        time.Time
+}
+
+// Inspect a container, sharing the result with concurrent inspects of it
+// and reusing it until the container changes
+func (daemon *dockerDaemon) inspect(containerID ContainerID) (dockerInspect, error) {
+	if cached, ok := daemon.cache.Load(containerID); ok && time.Since(cached.(cachedInspect).at) < inspectCacheTTL {
+		inspectCache.Inc(daemon.Endpoint, "hit")
+		return cached.(cachedInspect).container, nil
+	}
+	inspectCache.Inc(daemon.Endpoint, "miss")
+
+	daemon.inflight.Lock()
+	if call := daemon.inspects[containerID]; call != nil {
+		daemon.inflight.Unlock()
+		dockerCoalesced.Inc(daemon.Endpoint)
+		<-call.done
+		return call.container, call.err
+	}
+	call := &inspectCall{done: make(chan struct{})}
+	daemon.inspects[containerID] = call
+	daemon.inflight.Unlock()
+
+	call.err = daemon.get("/containers/"+string(containerID)+"/json", &call.container)
+
+	daemon.inflight.Lock()
+	if !call.stale {
+		if call.err == nil {
+			daemon.cache.Store(containerID, cachedInspect{call.container, time.Now()})
+		}
+		delete(daemon.inspects, containerID)
+	}
+	daemon.inflight.Unlock()
+	close(call.done)
+	return call.container, call.err
+}
+
+// Forget a container's cached inspect once it changed, including one still in
+// flight, which may have been answered before the change
+func (daemon *dockerDaemon) invalidate(containerID ContainerID) {
+	daemon.inflight.Lock()
+	defer daemon.inflight.Unlock()
+	if call := daemon.inspects[containerID]; call != nil {
+		call.stale = true
+		delete(daemon.inspects, containerID)
+	}
+	daemon.cache.Delete(containerID)
+}
+
+type dockerInfo struct {
+	Name     string   `json:"Name"`   // the docker host's hostname
+	Labels   []string `json:"Labels"` // engine labels, as key=value
+	NCPU     int      `json:"NCPU"`
+	MemTotal int64    `json:"MemTotal"`
+}
+
+// The docker host's name, labels, and resources, fetched on first use
+func (daemon *dockerDaemon) host() (*dockerInfo, error) {
+	if info := daemon.info.Load(); info != nil {
+		return info, nil
+	}
+	info := &dockerInfo{}
+	if err := daemon.get("/info", info); err != nil {
+		return nil, err
+	}
+	daemon.info.Store(info)
+	return info, nil
+}
+
+func (daemon *dockerDaemon) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, daemon.base+"/_ping", nil)
+	if err != nil {
+		return err
+	}
+	response, err := daemon.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("ping: %s", response.Status)
+	}
+	return nil
+}
+
+func (daemon *dockerDaemon) watchEvents() {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		daemon.cancelLock.Lock()
+		daemon.cancel = cancel
+		daemon.cancelLock.Unlock()
+
+		err := daemon.eventLoop(ctx)
+		restarted := ctx.Err() != nil
+		cancel()
+		if restarted {
+			log.Printf("# events restarted on %s", daemon)
+			continue
+		}
+		if err != nil {
+			log.Printf("events %s: %v", daemon, err)
+			reportFailure(fail(failDocker, fmt.Errorf("events %s: %w", daemon, err)))
+		}
+		time.Sleep(time.Second) // back off before reconnecting
+	}
+}
+
+// Reconnect the event stream and rescan without touching live routes
+func (daemon *dockerDaemon) restartEvents() {
+	daemon.cancelLock.Lock()
+	defer daemon.cancelLock.Unlock()
+	if daemon.cancel != nil {
+		daemon.cancel()
+	}
+}
+
+// Listen for docker events
+func (daemon *dockerDaemon) eventLoop(ctx context.Context) error {
+	// Start listening for events before scanning to avoid race conditions.
+	// Replay what happened while reconnecting, which the scan below can't see, e.g. a quick restart.
+	query := eventsQuery
+	if since := daemon.lastEvent.Load(); since > 0 {
+		since++ // the last event was already handled
+		query += fmt.Sprintf("&since=%d.%09d", since/1e9, since%1e9)
+		log.Printf("# events resuming on %s from %s", daemon, time.Unix(0, since).Format(time.RFC3339))
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, daemon.base+query, nil)
+	if err != nil {
+		return err
+	}
+	response, err := daemon.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	daemon.scanContainers()
+	daemon.markSeen()
+	daemon.connected.Store(true)
+	defer daemon.connected.Store(false)
+
+	// Route changes run on workers, so a slow inspect can't stall reading the stream.
+	// Events are sharded by container to keep each container's events in order.
+	shards := make([]chan dockerEvent, eventWorkers)
+	var workers sync.WaitGroup
+	for i := range shards {
+		shards[i] = make(chan dockerEvent, max(eventQueue/eventWorkers, 1))
+		workers.Go(func() {
+			for event := range shards[i] {
+				daemon.handleEvent(event)
+				daemon.queued.Add(-1)
+			}
+		})
+	}
+	defer workers.Wait()
+	for _, shard := range shards {
+		defer close(shard)
+	}
+
+	jsonDecoder := json.NewDecoder(response.Body)
+	for {
+		var event dockerEvent
+		if err := jsonDecoder.Decode(&event); err != nil {
+			return err
+		}
+		daemon.markSeen()
+		if debugLogging() {
+			slog.Debug(fmt.Sprintf("# docker %s event %s %s %s", daemon, event.Type, event.Action, event.Actor.ID),
+				"daemon", daemon.Endpoint, "type", event.Type, "action", event.Action, "id", event.Actor.ID, "time_nano", event.TimeNano)
+		}
+		daemon.lastEvent.Store(max(daemon.lastEvent.Load(), event.TimeNano))
+		daemon.invalidate(event.Actor.ID)
+
+		hash := fnv.New32a()
+		hash.Write([]byte(event.Actor.ID))
+		shard := shards[hash.Sum32()%uint32(len(shards))]
+		daemon.queued.Add(1)
+		select {
+		case shard <- event:
+		default:
+			eventsBlocked.Inc(daemon.Endpoint)
+			shard <- event
+		}
+	}
+}
+
+func (daemon *dockerDaemon) handleEvent(event dockerEvent) {
+	switch {
+	// Query the container's network on start and add routes if on our network
+	case event.Action == "start":
+		daemon.died.Delete(event.Actor.ID)
+		addRoutes(daemon, event.Actor.ID)
+	// Remove routes when a container exits, which crashes report with die alone
+	case event.Action == "die":
+		daemon.died.Store(event.Actor.ID, true)
+		removeRoutes(event.Actor.ID)
+	// Stopped containers die first, so only count a stop that came alone as a change.
+	case event.Action == "stop":
+		if _, died := daemon.died.LoadAndDelete(event.Actor.ID); !died {
+			removeRoutes(event.Actor.ID)
+		}
+	case event.Action == "destroy":
+		daemon.died.Delete(event.Actor.ID)
+	// Put routes in or out of rotation as health checks pass or fail, e.g. "health_status: healthy"
+	case strings.HasPrefix(event.Action, "health_status"):
+		addRoutes(daemon, event.Actor.ID)
+	}
+}
+
+// Sync the route table with the daemon's containers on the network, counting repairs
+func (daemon *dockerDaemon) scanContainers() (added, removed int) {
+	daemon.scanning.Lock()
+	defer daemon.scanning.Unlock()
+
+	// Containers outside the network may publish ports or be connected to it.
+	query := "/containers/json"
+	if daemon.Addr == "" && !daemon.Connect {
+		query = dockerQuery(query, map[string][]string{"network": {networkName}})
+	}
+	var containers []dockerContainer
+	if err := daemon.get(query, &containers); err != nil {
+		log.Printf("containers %s: %v", daemon, err)
+		return 0, 0
+	}
+	// Inspect with a bounded pool, publishing each container's routes as it completes.
+	running := make(map[ContainerID]bool)
+	var unrouted []ContainerID
+	queue := make(chan ContainerID)
+	var workers sync.WaitGroup
+	for range min(scanWorkers, len(containers)) {
+		workers.Go(func() {
+			for containerID := range queue {
+				addRoutes(daemon, containerID)
+			}
+		})
+	}
+	for _, container := range containers {
+		running[container.ID] = true
+		table.RLock()
+		_, routed := table.containers[container.ID]
+		table.RUnlock()
+		if !routed {
+			unrouted = append(unrouted, container.ID)
+			queue <- container.ID
+		}
+	}
+	close(queue)
+	workers.Wait()
+	table.RLock()
+	for _, containerID := range unrouted {
+		if _, routed := table.containers[containerID]; routed {
+			added++
+		}
+	}
+	table.RUnlock()
+
+	daemon.cache.Range(func(key, _ any) bool {
+		if !running[key.(ContainerID)] {
+			daemon.cache.Delete(key)
+		}
+		return true
+	})
+
+	// Drop routes of containers that stopped while no stream was listening.
+	var stale []ContainerID
+	table.RLock()
+	for containerID, owner := range table.owners {
+		if owner == daemon && !running[containerID] {
+			stale = append(stale, containerID)
+		}
+	}
+	table.RUnlock()
+	for _, containerID := range stale {
+		// A start event may have routed it since the list was taken.
+		if container, err := daemon.inspect(containerID); err == nil && container.State.Running {
+			continue
+		}
+		removeRoutes(containerID)
+		removed++
+	}
+	return added, removed
+}
+
+// Escape JSON queries for the Docker API
+func dockerQuery(path string, filters interface{}) string {
+	query, _ := json.Marshal(filters)
+	return path + "?filters=" + url.QueryEscape(string(query))
+}
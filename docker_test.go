@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventsRouteAndUnroute(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("events-a", "10.0.0.1", "SUB2PORT=events.test")
+	fake.run("events-b", "10.0.0.2", "SUB2PORT=events.test")
+	eventually(t, "two backends", routedTo("events.test", 2))
+
+	fake.stop("events-a")
+	eventually(t, "one backend", routedTo("events.test", 1))
+	fake.stop("events-b")
+	eventually(t, "the host to be removed", func() bool { return table.lookup("events.test") == nil })
+}
+
+// Crashed containers only die, and crash loops are quarantined without a stop
+func TestDieStartLoop(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("crashing", "10.0.0.1", "SUB2PORT=crashing.test")
+	eventually(t, "the backend", routedTo("crashing.test", 1))
+	fake.emit("die", "crashing")
+	eventually(t, "the host to be removed", func() bool { return table.lookup("crashing.test") == nil })
+	for range flapLimit {
+		fake.emit("start", "crashing")
+		fake.emit("die", "crashing")
+	}
+	eventually(t, "the container to be quarantined", func() bool { return quarantined("crashing") })
+	eventually(t, "its routes to be held", func() bool {
+		entry := table.lookup("crashing.test")
+		return entry != nil && len(entry.pool.Load().backends) == 0 && len(entry.pool.Load().held) == 1
+	})
+
+	// A stop after the die is the same exit, not another route change.
+	fake.run("stopped", "10.0.0.2", "SUB2PORT=stopped.test")
+	eventually(t, "the backend", routedTo("stopped.test", 1))
+	fake.emit("die", "stopped")
+	eventually(t, "the host to be removed", func() bool { return table.lookup("stopped.test") == nil })
+	fake.stop("stopped")
+	eventually(t, "the stop", func() bool {
+		_, died := daemon.died.Load(ContainerID("stopped"))
+		return !died
+	})
+	flaps.Lock()
+	changes := len(flaps.containers["stopped"].changes)
+	flaps.Unlock()
+	if changes != 2 {
+		t.Fatalf("expected a start and an exit, got %d route changes", changes)
+	}
+}
+
+// A released container starts over, so crash-looping again quarantines it again
+func TestQuarantineAgain(t *testing.T) {
+	previousMin, previousMax := quarantineMin, quarantineMax
+	quarantineMin, quarantineMax = 300*time.Millisecond, 300*time.Millisecond
+	t.Cleanup(func() { quarantineMin, quarantineMax = previousMin, previousMax })
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("relapsing", "10.0.0.1", "SUB2PORT=relapsing.test")
+	eventually(t, "the backend", routedTo("relapsing.test", 1))
+	for round := range 2 {
+		for range 2 {
+			fake.emit("die", "relapsing")
+			fake.emit("start", "relapsing")
+		}
+		eventually(t, fmt.Sprintf("quarantine %d", round+1), func() bool { return quarantined("relapsing") })
+		eventually(t, fmt.Sprintf("release %d", round+1), func() bool { return !quarantined("relapsing") })
+		eventually(t, "its routes to be restored", routedTo("relapsing.test", 1))
+		// Restoring the routes isn't a change of its own.
+		flaps.Lock()
+		changes := len(flaps.containers["relapsing"].changes)
+		flaps.Unlock()
+		if changes != 0 {
+			t.Fatalf("expected no route changes after the release, got %d", changes)
+		}
+	}
+}
+
+// Events of a container are handled in order, so the last one wins
+func TestEventOrder(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("order", "10.0.0.1", "SUB2PORT=order.test")
+	for range 20 {
+		fake.stop("order")
+		fake.run("order", "10.0.0.1", "SUB2PORT=order.test")
+	}
+	fake.stop("order")
+	fake.run("order-last", "10.0.0.2", "SUB2PORT=order-last.test")
+	// Events are sharded by container, so wait for a later container's too.
+	eventually(t, "a later container", routedTo("order-last.test", 1))
+	eventually(t, "the stopped container to be removed", func() bool { return table.lookup("order.test") == nil })
+}
+
+// A reconnected stream replays what happened while it was down
+func TestEventsResume(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("resume-a", "10.0.0.1", "SUB2PORT=resume-a.test")
+	eventually(t, "resume-a.test", routedTo("resume-a.test", 1))
+
+	fake.setDown(true)
+	fake.disconnect()
+	eventually(t, "the stream to drop", func() bool { return !daemon.connected.Load() })
+	fake.run("resume-b", "10.0.0.2", "SUB2PORT=resume-b.test")
+	fake.stop("resume-a")
+	fake.setDown(false)
+
+	eventually(t, "resume-b.test", routedTo("resume-b.test", 1))
+	eventually(t, "resume-a.test to be removed", func() bool { return table.lookup("resume-a.test") == nil })
+	if query := fake.lastQuery(); !strings.Contains(query, "since=") {
+		t.Fatalf("expected the reconnect to resume with since, got %q", query)
+	}
+}
+
+// A scan repairs routes of containers whose events were missed
+func TestScanRepairs(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+
+	fake.run("scan-a", "10.0.0.1", "SUB2PORT=scan-a.test")
+	if added, removed := daemon.scanContainers(); added != 1 || removed != 0 {
+		t.Fatalf("expected 1 added and 0 removed, got %d and %d", added, removed)
+	}
+
+	fake.set("scan-a", func(container *dockerInspect) { container.State.Running = false })
+	fake.set("scan-b", func(container *dockerInspect) {
+		container.State.Running = true
+		container.Config.Env = []string{"SUB2PORT=scan-b.test"}
+		container.NetworkSettings.Networks = map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		}{"fake": {IPAddress: "10.0.0.2"}}
+	})
+	// The stopped container's inspect is cached until its event, which was missed.
+	daemon.invalidate("scan-a")
+	if added, removed := daemon.scanContainers(); added != 1 || removed != 1 {
+		t.Fatalf("expected 1 added and 1 removed, got %d and %d", added, removed)
+	}
+	if table.lookup("scan-a.test") != nil || !routedTo("scan-b.test", 1)() {
+		t.Fatal("expected scan-b.test to replace scan-a.test")
+	}
+}
+
+// An inspect answered before the container changed is neither cached nor shared
+func TestInspectInvalidation(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	setIP := func(ip string) {
+		fake.set("inspected", func(container *dockerInspect) {
+			container.State.Running = true
+			container.NetworkSettings.Networks = map[string]struct {
+				IPAddress string `json:"IPAddress"`
+			}{"fake": {IPAddress: ip}}
+		})
+	}
+	ipOf := func(container dockerInspect) string { return container.NetworkSettings.Networks["fake"].IPAddress }
+	setIP("10.0.0.1")
+	reading, release := make(chan struct{}), make(chan struct{})
+	var inspects atomic.Int32
+	fake.inspected = func() {
+		// Hold only the first inspect, which answers with the old address.
+		if inspects.Add(1) == 1 {
+			close(reading)
+			<-release
+		}
+	}
+
+	first := make(chan dockerInspect)
+	go func() {
+		container, _ := daemon.inspect("inspected")
+		first <- container
+	}()
+	<-reading
+	// The container changes, and its event arrives, while the first inspect is in flight.
+	setIP("10.0.0.2")
+	daemon.invalidate("inspected")
+	if container, err := daemon.inspect("inspected"); err != nil || ipOf(container) != "10.0.0.2" {
+		t.Fatalf("expected a fresh inspect after the change, got %q: %v", ipOf(container), err)
+	}
+	close(release)
+	if container := <-first; ipOf(container) != "10.0.0.1" {
+		t.Fatalf("expected the first inspect to see the old address, got %q", ipOf(container))
+	}
+	if container, err := daemon.inspect("inspected"); err != nil || ipOf(container) != "10.0.0.2" {
+		t.Fatalf("expected the stale inspect not to be cached, got %q: %v", ipOf(container), err)
+	}
+}
+
+func TestHealthGating(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.set("health", func(container *dockerInspect) {
+		container.State.Health = &struct {
+			Status string `json:"Status"`
+		}{"starting"}
+	})
+	fake.run("health", "10.0.0.1", "SUB2PORT=health.test")
+	eventually(t, "a held backend", func() bool {
+		backends, held := backendCount("health.test")
+		return backends == 0 && held == 1
+	})
+
+	fake.set("health", func(container *dockerInspect) { container.State.Health.Status = "healthy" })
+	fake.emit("health_status: healthy", "health")
+	eventually(t, "the backend in rotation", routedTo("health.test", 1))
+
+	fake.set("health", func(container *dockerInspect) { container.State.Health.Status = "unhealthy" })
+	fake.emit("health_status: unhealthy", "health")
+	eventually(t, "the backend held again", routedTo("health.test", 0))
+}
+
+// Requests keep being answered while containers come and go under them
+func TestChurnDuringTraffic(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+	start := func(containerID ContainerID) {
+		server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		t.Cleanup(server.Close)
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		fake.run(containerID, "127.0.0.1", "SUB2PORT=churn.test:"+port)
+	}
+	start("churn-stable")
+	eventually(t, "the stable backend", routedTo("churn.test", 1))
+
+	done := make(chan struct{})
+	var workers sync.WaitGroup
+	for range 8 {
+		workers.Go(func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				recorder := httptest.NewRecorder()
+				proxy(recorder, httptest.NewRequest(http.MethodGet, "http://churn.test/", nil))
+				if recorder.Code != http.StatusOK {
+					t.Errorf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+				}
+			}
+		})
+	}
+	for i := range 50 {
+		containerID := ContainerID(fmt.Sprintf("churn-%d", i))
+		start(containerID)
+		eventually(t, "the churned backend", routedTo("churn.test", 2))
+		fake.stop(containerID)
+		eventually(t, "the churned backend to be removed", routedTo("churn.test", 1))
+	}
+	close(done)
+	workers.Wait()
+
+	stable := table.lookup("churn.test").pool.Load().backends[0]
+	if stable.inflight.Load() != 0 {
+		t.Fatalf("expected no requests in flight, got %d", stable.inflight.Load())
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Docker endpoints from DOCKER_HOST
+
+// The daemon at DOCKER_HOST, the local unix socket by default
+func newLocalDaemon() *dockerDaemon {
+	endpoint := cmp.Or(os.Getenv("DOCKER_HOST"), "unix:///var/run/docker.sock")
+	daemon, err := newDockerDaemon(endpoint)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("DOCKER_HOST: %w", err)))
+	}
+	if err := daemon.useDockerTLS(); err != nil {
+		fatal(fail(failConfig, fmt.Errorf("DOCKER_HOST: %w", err)))
+	}
+	return daemon
+}
+
+// Talk TLS to a tcp:// endpoint when DOCKER_TLS_VERIFY or DOCKER_TLS is set
+func (daemon *dockerDaemon) useDockerTLS() error {
+	config, err := dockerTLS()
+	if err != nil {
+		return fmt.Errorf("DOCKER_CERT_PATH: %w", err)
+	}
+	if config != nil && strings.HasPrefix(daemon.Endpoint, "tcp://") {
+		daemon.transport.TLSClientConfig = config
+		daemon.base = "https://" + strings.TrimPrefix(daemon.base, "http://")
+	}
+	return nil
+}
+
+// TLS settings of DOCKER_HOST as the docker CLI reads them, nil without TLS
+func dockerTLS() (*tls.Config, error) {
+	verify := os.Getenv("DOCKER_TLS_VERIFY") != ""
+	if !verify && os.Getenv("DOCKER_TLS") == "" {
+		return nil, nil
+	}
+	home, _ := os.UserHomeDir()
+	certPath := cmp.Or(os.Getenv("DOCKER_CERT_PATH"), filepath.Join(home, ".docker"))
+	config := &tls.Config{InsecureSkipVerify: !verify}
+	if verify {
+		ca, err := os.ReadFile(filepath.Join(certPath, "ca.pem"))
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("ca.pem: no certificates")
+		}
+	}
+	// The client certificate is optional, e.g. behind a TLS-terminating socket proxy.
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err == nil {
+		config.Certificates = []tls.Certificate{cert}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Dial the daemon through "docker system dial-stdio" over the ssh client, as the docker CLI does
+func sshDialer(endpoint *url.URL) func(context.Context, string, string) (net.Conn, error) {
+	args := []string{"-o", "ConnectTimeout=30"}
+	if endpoint.User != nil {
+		args = append(args, "-l", endpoint.User.Username())
+	}
+	if port := endpoint.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", endpoint.Hostname(), "docker", "system", "dial-stdio")
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		command := exec.Command("ssh", args...)
+		stdin, err := command.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := command.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		command.Stderr = os.Stderr
+		if err := command.Start(); err != nil {
+			return nil, fmt.Errorf("ssh: %w", err)
+		}
+		return &stdioConn{command: command, stdin: stdin, stdout: stdout, host: endpoint.Host}, nil
+	}
+}
+
+// A connection over the stdin and stdout of a command
+type stdioConn struct {
+	command *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	host    string
+}
+
+func (conn *stdioConn) Read(buffer []byte) (int, error)  { return conn.stdout.Read(buffer) }
+func (conn *stdioConn) Write(buffer []byte) (int, error) { return conn.stdin.Write(buffer) }
+
+func (conn *stdioConn) Close() error {
+	_ = conn.stdin.Close()
+	_ = conn.command.Process.Kill()
+	_ = conn.command.Wait()
+	return nil
+}
+
+func (conn *stdioConn) LocalAddr() net.Addr  { return stdioAddr("stdio") }
+func (conn *stdioConn) RemoteAddr() net.Addr { return stdioAddr(conn.host) }
+
+// Deadlines aren't supported; requests are bounded by their contexts instead.
+func (conn *stdioConn) SetDeadline(time.Time) error      { return nil }
+func (conn *stdioConn) SetReadDeadline(time.Time) error  { return nil }
+func (conn *stdioConn) SetWriteDeadline(time.Time) error { return nil }
+
+type stdioAddr string
+
+func (addr stdioAddr) Network() string { return "ssh" }
+func (addr stdioAddr) String() string  { return string(addr) }
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// DOCKER_TLS applies to every tcp:// daemon, not only DOCKER_HOST
+func TestDockerHostsTLS(t *testing.T) {
+	t.Setenv("DOCKER_TLS", "1")
+	t.Setenv("DOCKER_CERT_PATH", t.TempDir())
+	for endpoint, want := range map[string]string{
+		"tcp://10.0.0.2:2376": "https://10.0.0.2:2376",
+		"unix:///docker.sock": "http://localhost",
+	} {
+		daemon, err := newDockerDaemon(endpoint)
+		if err == nil {
+			err = daemon.useDockerTLS()
+		}
+		if err != nil || daemon.base != want {
+			t.Fatalf("%s: expected %s, got %s: %v", endpoint, want, daemon.base, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Early hints: 103 answers with the preload links a page's last answer had,
+// sent while the backend is still working on this one
+
+// The most paths remembered before starting over
+const earlyHintsLimit = 4096
+
+var earlyHints = struct {
+	sync.Mutex
+	links map[string][]string // by host and path
+}{links: make(map[string][]string)}
+
+// The route and the path the client asked for, the same before and after a prefix rewrite
+func earlyHintsKey(request *http.Request) string {
+	state := stateOf(request)
+	return string(state.host) + " " + state.path
+}
+
+// Send the links remembered for the request's path, if any
+func sendEarlyHints(writer http.ResponseWriter, request *http.Request) {
+	// 1xx answers can't be sent to HTTP/1.0 clients.
+	if request.Method != http.MethodGet || !request.ProtoAtLeast(1, 1) {
+		return
+	}
+	earlyHints.Lock()
+	links := earlyHints.links[earlyHintsKey(request)]
+	earlyHints.Unlock()
+	if len(links) == 0 {
+		return
+	}
+	header := writer.Header()
+	header["Link"] = links
+	writer.WriteHeader(http.StatusEarlyHints)
+	// The final answer brings its own links.
+	header.Del("Link")
+}
+
+// Remember the preload links of a page for the next request of its path
+func rememberEarlyHints(response *http.Response) {
+	request := response.Request
+	if request.Method != http.MethodGet || response.StatusCode < 200 || response.StatusCode > 299 {
+		return
+	}
+	if media, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); media != "text/html" {
+		return
+	}
+	var links []string
+	for _, value := range response.Header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			if link = strings.TrimSpace(link); preloadLink(link) {
+				links = append(links, link)
+			}
+		}
+	}
+	key := earlyHintsKey(request)
+	earlyHints.Lock()
+	defer earlyHints.Unlock()
+	if len(links) == 0 {
+		delete(earlyHints.links, key)
+		return
+	}
+	if _, ok := earlyHints.links[key]; !ok && len(earlyHints.links) >= earlyHintsLimit {
+		clear(earlyHints.links)
+	}
+	earlyHints.links[key] = links
+}
+
+// Links with rel=preload, modulepreload, or preconnect, e.g. </app.css>; rel=preload; as=style
+func preloadLink(link string) bool {
+	_, params, ok := strings.Cut(link, ";")
+	if !ok {
+		return false
+	}
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+			switch strings.ToLower(rel) {
+			case "preload", "modulepreload", "preconnect":
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEarlyHints(t *testing.T) {
+	var served atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if served.Add(1) == 1 {
+			writer.Header().Set("Link", "</own.css>; rel=preload; as=style")
+			writer.WriteHeader(http.StatusEarlyHints)
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Header().Set("Link", `</app.css>; rel=preload; as=style, </feed>; rel="alternate"`)
+		_, _ = io.WriteString(writer, "<html></html>")
+	}))
+	t.Cleanup(backend.Close)
+	routeTo(t, "hints.test", backend, &hostOptions{EarlyHints: true})
+	front := httptest.NewServer(http.HandlerFunc(proxy))
+	t.Cleanup(front.Close)
+
+	get := func() (hints []string, status int) {
+		trace := &httptrace.ClientTrace{Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			hints = append(hints, fmt.Sprint(code, " ", header.Get("Link")))
+			return nil
+		}}
+		request, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, front.URL+"/", nil)
+		request.Host = "hints.test"
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		return hints, response.StatusCode
+	}
+	// The backend's own hints are passed on, and the page's preloads remembered.
+	if hints, status := get(); status != http.StatusOK || !slices.Equal(hints, []string{"103 </own.css>; rel=preload; as=style"}) {
+		t.Fatalf("expected the backend's hints, got %d %q", status, hints)
+	}
+	if hints, status := get(); status != http.StatusOK || !slices.Equal(hints, []string{"103 </app.css>; rel=preload; as=style"}) {
+		t.Fatalf("expected the remembered hints, got %d %q", status, hints)
+	}
+
+	// Pages behind a rewritten prefix are remembered by the path the client asked for.
+	request := matchedTo(httptest.NewRequest(http.MethodGet, "http://hints.test/app/", nil), "hints.test/app", nil)
+	rewritePrefix(request, "hints.test/app", "/")
+	header := http.Header{"Content-Type": {"text/html"}, "Link": {"</app.js>; rel=modulepreload"}}
+	rememberEarlyHints(&http.Response{StatusCode: http.StatusOK, Header: header, Request: request})
+	hinted := httptest.NewRecorder()
+	sendEarlyHints(hinted, matchedTo(httptest.NewRequest(http.MethodGet, "http://hints.test/app/", nil), "hints.test/app", nil))
+	if hinted.Code != http.StatusEarlyHints {
+		t.Fatalf("expected the rewritten page's hints, got %d", hinted.Code)
+	}
+
+	recorder := &accessRecorder{ResponseWriter: httptest.NewRecorder()}
+	recorder.WriteHeader(http.StatusEarlyHints)
+	recorder.WriteHeader(http.StatusOK)
+	if recorder.status != http.StatusOK {
+		t.Fatalf("expected 200 to be logged, got %d", recorder.status)
+	}
+}
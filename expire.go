@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Expiry of metrics and state kept for hosts and containers that are gone
+
+// Forget a host or container this long after its last route is removed, 0 keeps everything
+var expireAfter = envDuration("SUB2PORT_METRICS_TTL", time.Hour)
+
+// When each host and container was first found gone, keyed by label and value
+var missing = struct {
+	sync.Mutex
+	since map[[2]string]time.Time
+}{since: make(map[[2]string]time.Time)}
+
+func watchExpiry() {
+	if expireAfter <= 0 {
+		return
+	}
+	for range time.Tick(min(expireAfter, time.Minute)) {
+		expire(time.Now())
+	}
+}
+
+// Drop the series and state of hosts and containers gone for longer than expireAfter
+func expire(now time.Time) {
+	// Requests answered at the proxy are counted with backend "-".
+	live := map[string]map[string]bool{"host": {}, "container": {"-": true}}
+	table.hosts.Range(func(key, _ any) bool {
+		// Hosts routed only on paths keep their certificates.
+		domain, _ := splitRoute(key.(HostName))
+		live["host"][string(key.(HostName))] = true
+		live["host"][string(domain)] = true
+		return true
+	})
+	table.RLock()
+	for _, bindings := range table.containers {
+		for _, binding := range bindings {
+			live["container"][string(binding.Name)] = true
+		}
+	}
+	table.RUnlock()
+
+	missing.Lock()
+	defer missing.Unlock()
+	seen := make(map[[2]string]bool)
+	expired := func(label string) func(string) bool {
+		return func(value string) bool {
+			if live[label][value] {
+				return false
+			}
+			key := [2]string{label, value}
+			seen[key] = true
+			since, ok := missing.since[key]
+			if !ok {
+				missing.since[key] = now
+				return false
+			}
+			return now.Sub(since) >= expireAfter
+		}
+	}
+
+	metricRegistry.Lock()
+	vecs := metricRegistry.vecs
+	metricRegistry.Unlock()
+	for _, vec := range vecs {
+		vec.expire("host", expired("host"))
+		vec.expire("container", expired("container"))
+		vec.expire("backend", expired("container"))
+	}
+
+	upstreamErrors.Lock()
+	for name := range upstreamErrors.backends {
+		if expired("container")(string(name)) {
+			delete(upstreamErrors.backends, name)
+		}
+	}
+	upstreamErrors.Unlock()
+
+	if certs != nil {
+		certs.expire(expired("host"))
+	}
+
+	// Values no state mentions anymore start over if they come back.
+	for key := range missing.since {
+		if !seen[key] {
+			delete(missing.since, key)
+		}
+	}
+}
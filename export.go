@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Route table export and import
+
+type exportedRoute struct {
+	Host    HostName          `json:"host"`
+	Backend string            `json:"backend"` // host:port
+	Source  string            `json:"source"`  // container, static, manual, or import
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type tableExport struct {
+	Version  int               `json:"version"`
+	Routes   []exportedRoute   `json:"routes"`
+	ReadOnly map[HostName]bool `json:"read_only,omitempty"` // admin overrides
+}
+
+// Where a binding came from, by its ID
+func routeSource(id ContainerID) string {
+	if source, _, ok := strings.Cut(string(id), ":"); ok {
+		return source
+	}
+	return "container"
+}
+
+func exportTable() tableExport {
+	export := tableExport{Version: 1, Routes: []exportedRoute{}, ReadOnly: make(map[HostName]bool)}
+	table.RLock()
+	for id, bindings := range table.containers {
+		for _, binding := range bindings {
+			entry := table.lookup(binding.Domain)
+			if entry == nil {
+				continue
+			}
+			pool := entry.pool.Load()
+			for _, route := range slices.Concat(pool.backends, pool.held) {
+				if route.Name == binding.Name {
+					export.Routes = append(export.Routes, exportedRoute{
+						Host:    binding.Domain,
+						Backend: net.JoinHostPort(route.Host, route.Port),
+						Source:  routeSource(id),
+						Options: route.Options.Vars,
+					})
+					break
+				}
+			}
+		}
+	}
+	table.RUnlock()
+	slices.SortFunc(export.Routes, func(a, b exportedRoute) int {
+		return strings.Compare(string(a.Host)+" "+a.Backend, string(b.Host)+" "+b.Backend)
+	})
+	readOnlyOverrides.Range(func(key, value any) bool {
+		export.ReadOnly[key.(HostName)] = value.(bool)
+		return true
+	})
+	return export
+}
+
+// Bind exported routes by address, skipping container routes unless asked,
+// since containers are found again by the instance that runs them
+func importTable(export tableExport, containers bool) (int, error) {
+	if export.Version != 1 {
+		return 0, fmt.Errorf("unsupported export version %d", export.Version)
+	}
+	table.Lock()
+	defer table.Unlock()
+	imported := 0
+	for _, route := range export.Routes {
+		if route.Source == "container" && !containers {
+			continue
+		}
+		id := ContainerID("import:" + string(route.Host) + "->" + route.Backend)
+		if _, exists := table.containers[id]; exists {
+			continue
+		}
+		if err := bindAddress(id, route.Host, route.Backend, route.Options); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	for host, enabled := range export.ReadOnly {
+		readOnlyOverrides.Store(normalizeRoute(string(host)), enabled)
+	}
+	return imported, nil
+}
+
+func init() {
+	adminMux.HandleFunc("GET /export", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Disposition", `attachment; filename="sub2port-routes.json"`)
+		writeJSON(writer, http.StatusOK, exportTable())
+	})
+	// Add ?containers=true to also bind container routes by their address
+	adminMux.HandleFunc("POST /import", func(writer http.ResponseWriter, request *http.Request) {
+		var export tableExport
+		if err := json.NewDecoder(request.Body).Decode(&export); err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		imported, err := importTable(export, request.URL.Query().Get("containers") == "true")
+		if err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]interface{}{"error": err.Error(), "imported": imported})
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]int{"imported": imported})
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Failures and exit codes
+
+// What an operator has to fix
+type failureKind string
+
+const (
+	failConfig  failureKind = "config"  // a setting is invalid
+	failDocker  failureKind = "docker"  // the docker API can't be reached
+	failNetwork failureKind = "network" // the proxy network is missing or can't be joined
+	failBind    failureKind = "bind"    // a listener can't bind its address
+)
+
+// Exit codes by kind, so scripts can tell configuration from environment problems
+var exitCodes = map[failureKind]int{
+	failConfig:  2,
+	failDocker:  3,
+	failNetwork: 4,
+	failBind:    5,
+}
+
+type failure struct {
+	Kind failureKind
+	Err  error
+}
+
+func fail(kind failureKind, err error) *failure {
+	return &failure{Kind: kind, Err: err}
+}
+
+func (failure *failure) Error() string {
+	return string(failure.Kind) + ": " + failure.Err.Error()
+}
+
+func (failure *failure) Unwrap() error {
+	return failure.Err
+}
+
+// Exit with the failure's code, or 1 for other errors
+func fatal(err error) {
+	code := 1
+	var failure *failure
+	if errors.As(err, &failure) {
+		code = exitCodes[failure.Kind]
+	}
+	log.Printf("! %v", err)
+	os.Exit(code)
+}
+
+// Recent failures that didn't stop the proxy
+var failures struct {
+	sync.Mutex
+	recent []failureReport
+}
+
+type failureReport struct {
+	Kind  failureKind `json:"kind"`
+	Error string      `json:"error"`
+	At    time.Time   `json:"at"`
+}
+
+const maxFailures = 20
+
+func reportFailure(failure *failure) {
+	failures.Lock()
+	defer failures.Unlock()
+	failures.recent = append(failures.recent, failureReport{failure.Kind, failure.Err.Error(), time.Now().UTC()})
+	if len(failures.recent) > maxFailures {
+		failures.recent = failures.recent[len(failures.recent)-maxFailures:]
+	}
+}
+
+var started = time.Now()
+
+func init() {
+	adminMux.HandleFunc("GET /status", func(writer http.ResponseWriter, _ *http.Request) {
+		status := "ok"
+		for _, daemon := range daemons {
+			if daemon.degraded() {
+				status = "degraded"
+			}
+		}
+		failures.Lock()
+		recent := append([]failureReport{}, failures.recent...)
+		failures.Unlock()
+		writeJSON(writer, http.StatusOK, map[string]interface{}{
+			"status":         status,
+			"network":        networkName,
+			"uptime_seconds": int(time.Since(started).Seconds()),
+			"failures":       recent,
+			"exit_codes":     exitCodes,
+		})
+	})
+}
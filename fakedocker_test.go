@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A scripted Docker daemon serving the API calls sub2port makes
+type fakeDocker struct {
+	sync.Mutex
+	containers map[ContainerID]*dockerInspect
+	events     []dockerEvent // every event so far, replayed from since
+	streams    map[chan dockerEvent]bool
+	clock      int64    // timeNano of the latest event
+	queries    []string // of every events request
+	down       bool     // refuse event streams, as while the daemon restarts
+	inspected  func()   // called between reading a container and answering its inspect, nil for none
+}
+
+// Start a fake on the test's network and a daemon of sub2port talking to it
+func newFakeDocker(t *testing.T) (*fakeDocker, *dockerDaemon) {
+	t.Helper()
+	fake := &fakeDocker{
+		containers: make(map[ContainerID]*dockerInspect),
+		streams:    make(map[chan dockerEvent]bool),
+		clock:      time.Now().UnixNano(),
+	}
+	server := httptest.NewServer(fake)
+	daemon, err := newDockerDaemon("tcp://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := networkName
+	networkName = "fake"
+	t.Cleanup(func() {
+		fake.disconnect()
+		server.Close()
+		networkName = previous
+		for containerID := range fake.containers {
+			dropRoutes(containerID)
+		}
+		// Forget route changes, so reruns of a test aren't quarantined as flapping.
+		flaps.Lock()
+		for containerID := range fake.containers {
+			delete(flaps.containers, ContainerName(containerID))
+		}
+		flaps.Unlock()
+	})
+	return fake, daemon
+}
+
+func (fake *fakeDocker) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := request.URL.Path
+	switch {
+	case path == "/_ping":
+		_, _ = writer.Write([]byte("OK"))
+	case path == "/containers/json":
+		fake.Lock()
+		containers := []dockerContainer{}
+		for containerID, container := range fake.containers {
+			if container.State.Running {
+				containers = append(containers, dockerContainer{ID: containerID})
+			}
+		}
+		fake.Unlock()
+		_ = json.NewEncoder(writer).Encode(containers)
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+		containerID := ContainerID(strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json"))
+		fake.Lock()
+		container, ok := fake.containers[containerID]
+		var encoded []byte
+		if ok {
+			encoded, _ = json.Marshal(container)
+		}
+		inspected := fake.inspected
+		fake.Unlock()
+		if inspected != nil {
+			inspected()
+		}
+		if !ok {
+			http.Error(writer, "no such container", http.StatusNotFound)
+			return
+		}
+		_, _ = writer.Write(encoded)
+	case path == "/info":
+		_ = json.NewEncoder(writer).Encode(dockerInfo{Name: "fake-node", Labels: []string{"zone=eu"}, NCPU: 4, MemTotal: 8 << 30})
+	case path == "/events":
+		fake.streamEvents(writer, request)
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+// Replay the events from since, then stream new ones until disconnected
+func (fake *fakeDocker) streamEvents(writer http.ResponseWriter, request *http.Request) {
+	fake.Lock()
+	fake.queries = append(fake.queries, request.URL.RawQuery)
+	if fake.down {
+		fake.Unlock()
+		http.Error(writer, "daemon is restarting", http.StatusServiceUnavailable)
+		return
+	}
+	var since int64
+	if value := request.URL.Query().Get("since"); value != "" {
+		seconds, nanos, _ := strings.Cut(value, ".")
+		whole, _ := strconv.ParseInt(seconds, 10, 64)
+		fraction, _ := strconv.ParseInt(nanos, 10, 64)
+		since = whole*1e9 + fraction
+	}
+	encoder := json.NewEncoder(writer)
+	for _, event := range fake.events {
+		if since > 0 && event.TimeNano >= since {
+			_ = encoder.Encode(event)
+		}
+	}
+	stream := make(chan dockerEvent, 100)
+	fake.streams[stream] = true
+	fake.Unlock()
+	writer.(http.Flusher).Flush()
+
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			_ = encoder.Encode(event)
+			writer.(http.Flusher).Flush()
+		case <-request.Context().Done():
+			fake.Lock()
+			delete(fake.streams, stream)
+			fake.Unlock()
+			return
+		}
+	}
+}
+
+// Change a container without an event, as if the event was missed
+func (fake *fakeDocker) set(containerID ContainerID, change func(*dockerInspect)) {
+	fake.Lock()
+	defer fake.Unlock()
+	container := fake.containers[containerID]
+	if container == nil {
+		container = &dockerInspect{Name: "/" + string(containerID)}
+		fake.containers[containerID] = container
+	}
+	change(container)
+}
+
+// Publish a container event to every stream
+func (fake *fakeDocker) emit(action string, containerID ContainerID) {
+	fake.Lock()
+	defer fake.Unlock()
+	fake.clock += int64(time.Millisecond)
+	event := dockerEvent{Type: "container", Action: action, TimeNano: fake.clock}
+	event.Actor.ID = containerID
+	fake.events = append(fake.events, event)
+	for stream := range fake.streams {
+		stream <- event
+	}
+}
+
+// Start a container on the network with SUB2PORT env vars
+func (fake *fakeDocker) run(containerID ContainerID, ip string, env ...string) {
+	fake.set(containerID, func(container *dockerInspect) {
+		container.State.Running = true
+		container.Config.Env = env
+		container.NetworkSettings.Networks = map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		}{"fake": {IPAddress: ip}}
+	})
+	fake.emit("start", containerID)
+}
+
+func (fake *fakeDocker) stop(containerID ContainerID) {
+	fake.set(containerID, func(container *dockerInspect) { container.State.Running = false })
+	fake.emit("stop", containerID)
+}
+
+// End every event stream, as when the connection drops
+func (fake *fakeDocker) disconnect() {
+	fake.Lock()
+	defer fake.Unlock()
+	for stream := range fake.streams {
+		close(stream)
+		delete(fake.streams, stream)
+	}
+}
+
+func (fake *fakeDocker) setDown(down bool) {
+	fake.Lock()
+	defer fake.Unlock()
+	fake.down = down
+}
+
+func (fake *fakeDocker) lastQuery() string {
+	fake.Lock()
+	defer fake.Unlock()
+	return fake.queries[len(fake.queries)-1]
+}
+
+// Run the daemon's event loop, reconnecting right away, until the test ends
+func watchFake(t *testing.T, daemon *dockerDaemon) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			_ = daemon.eventLoop(ctx)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	eventually(t, "the event stream to connect", daemon.connected.Load)
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// The host's backends in rotation and held
+func backendCount(host HostName) (int, int) {
+	entry := table.lookup(host)
+	if entry == nil {
+		return 0, 0
+	}
+	pool := entry.pool.Load()
+	return len(pool.backends), len(pool.held)
+}
+
+func routedTo(host HostName, backends int) func() bool {
+	return func() bool {
+		count, _ := backendCount(host)
+		return count == backends
+	}
+}
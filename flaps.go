@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Route flap detection
+
+type flapState struct {
+	changes    []time.Time // route changes within the window
+	flapping   bool
+	suppressed int // changes not logged while flapping
+
+	daemon      *dockerDaemon // where the latest container with this name runs
+	containerID ContainerID
+	holdDown    time.Duration // the current quarantine length, doubled while it keeps flapping
+	until       time.Time     // quarantined until
+	released    time.Time     // out of quarantine since
+	restoring   bool          // its routes are being restored, which isn't a change of its own
+}
+
+var flaps = struct {
+	sync.Mutex
+	containers map[ContainerName]*flapState
+}{containers: make(map[ContainerName]*flapState)}
+
+var flapWindow = envDuration("SUB2PORT_FLAP_WINDOW", time.Minute)
+var flapLimit = envInt("SUB2PORT_FLAP_LIMIT", 4)
+
+// Flapping containers are quarantined for an escalating hold-down, 0 disables
+var quarantineMin = envDuration("SUB2PORT_QUARANTINE", 30*time.Second)
+var quarantineMax = envDuration("SUB2PORT_QUARANTINE_MAX", 10*time.Minute)
+
+var flapCount = newCounterVec("sub2port_route_flaps_total", "Route changes of containers that are flapping.", "container")
+var quarantines = newCounterVec("sub2port_quarantines_total", "Hold-downs of crash-looping containers.", "container")
+
+// Record a route change and report whether it should be logged
+func recordFlap(daemon *dockerDaemon, containerID ContainerID, name ContainerName) bool {
+	now := time.Now()
+	flaps.Lock()
+	defer flaps.Unlock()
+
+	state := flaps.containers[name]
+	if state == nil {
+		state = &flapState{}
+		flaps.containers[name] = state
+	}
+	state.daemon, state.containerID = daemon, containerID
+	if state.restoring {
+		state.restoring = false
+		return true
+	}
+	state.changes = append(trimChanges(state.changes, now), now)
+	if !state.flapping && len(state.changes) >= flapLimit {
+		state.flapping = true
+		log.Printf("! %s is flapping: %d route changes in %s, muting its route logs", name, len(state.changes), flapWindow)
+		if quarantineMin > 0 && state.until.IsZero() {
+			quarantine(name, state)
+		}
+	}
+	if state.flapping {
+		state.suppressed++
+		flapCount.Inc(string(name))
+		return false
+	}
+	return true
+}
+
+// Summarize containers that stopped flapping and forget quiet ones
+func watchFlaps() {
+	for range time.Tick(flapWindow) {
+		now := time.Now()
+		flaps.Lock()
+		for name, state := range flaps.containers {
+			state.changes = trimChanges(state.changes, now)
+			// The hold-down escalates for containers that flap again soon after a quarantine.
+			if len(state.changes) > 0 || !state.until.IsZero() || now.Sub(state.released) < quarantineMax {
+				continue
+			}
+			if state.flapping {
+				log.Printf("! %s settled after %d muted route changes", name, state.suppressed)
+			}
+			delete(flaps.containers, name)
+		}
+		flaps.Unlock()
+	}
+}
+
+func trimChanges(changes []time.Time, now time.Time) []time.Time {
+	for len(changes) > 0 && now.Sub(changes[0]) > flapWindow {
+		changes = changes[1:]
+	}
+	return changes
+}
+
+// Hold a flapping container's routes so its hosts answer 503 until it settles
+func quarantine(name ContainerName, state *flapState) {
+	state.holdDown = min(max(state.holdDown*2, quarantineMin), quarantineMax)
+	state.until = time.Now().Add(state.holdDown)
+	quarantines.Inc(string(name))
+	log.Printf("! %s quarantined for %s", name, state.holdDown)
+	time.AfterFunc(state.holdDown, func() { release(name) })
+}
+
+// Restore a container's routes, or extend the hold-down while it still flaps
+func release(name ContainerName) {
+	flaps.Lock()
+	state := flaps.containers[name]
+	if state == nil {
+		flaps.Unlock()
+		return
+	}
+	// Restarting during the hold-down (a stop and a start) means it still flaps.
+	held := state.until.Add(-state.holdDown)
+	restarts := 0
+	for _, change := range state.changes {
+		if change.After(held) {
+			restarts++
+		}
+	}
+	if restarts >= 2 {
+		quarantine(name, state)
+		flaps.Unlock()
+		return
+	}
+	// It starts over, so flapping again quarantines it again.
+	state.until, state.released = time.Time{}, time.Now()
+	state.changes, state.flapping, state.suppressed = nil, false, 0
+	state.restoring = true
+	daemon, containerID := state.daemon, state.containerID
+	flaps.Unlock()
+
+	log.Printf("! %s released from quarantine", name)
+	addRoutes(daemon, containerID)
+}
+
+func quarantined(name ContainerName) bool {
+	flaps.Lock()
+	defer flaps.Unlock()
+	state := flaps.containers[name]
+	return state != nil && !state.until.IsZero()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Forward auth: an external service, e.g. oauth2-proxy or Authelia, decides who gets in
+
+type forwardAuth struct {
+	url     string
+	headers []string // copied from the service's 2xx answer to the request, e.g. Remote-User
+	signIn  string   // where a 401 redirects, with {url} for the requested page; the 401 is passed on when empty
+}
+
+// Parse SUB2PORT_FORWARD_AUTH and the options that go with it
+func parseForwardAuth(vars map[string]string) (*forwardAuth, error) {
+	address := strings.TrimSpace(vars["SUB2PORT_FORWARD_AUTH"])
+	if address == "" {
+		return nil, nil
+	}
+	if location, err := url.Parse(address); err != nil || (location.Scheme != "http" && location.Scheme != "https") || location.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL, got %q", address)
+	}
+	return &forwardAuth{
+		url:     address,
+		headers: parseHeaderNames(vars["SUB2PORT_FORWARD_AUTH_HEADERS"]),
+		signIn:  strings.TrimSpace(vars["SUB2PORT_FORWARD_AUTH_SIGNIN"]),
+	}, nil
+}
+
+// The service's redirects are for the client to follow
+var forwardAuthClient = &http.Client{
+	Transport: sharedTransport,
+	Timeout:   10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// The most of an auth service's answer passed on to the client
+const forwardAuthBodyLimit = 64 << 10
+
+// Ask the auth service about the request, passing its answer on unless it is a 2xx
+func filterForwardAuth(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	auth := options.ForwardAuth
+	if auth == nil {
+		return false
+	}
+	check, err := http.NewRequestWithContext(request.Context(), http.MethodGet, auth.url, nil)
+	if err != nil {
+		renderError(writer, request, options, http.StatusInternalServerError, err.Error())
+		return true
+	}
+	// The service sees the client's credentials and what it asked for.
+	check.Header = request.Header.Clone()
+	check.Header.Del("Content-Length")
+	check.Header.Del("Content-Type")
+	proto := "http"
+	if request.TLS != nil {
+		proto = "https"
+	}
+	check.Header.Set("X-Forwarded-Method", request.Method)
+	check.Header.Set("X-Forwarded-Proto", proto)
+	check.Header.Set("X-Forwarded-Host", request.Host)
+	check.Header.Set("X-Forwarded-Uri", request.URL.RequestURI())
+	check.Header.Set("X-Forwarded-For", clientIP(request))
+	check.Header.Set("X-Original-Url", requestURL(request))
+	check.Header.Set("X-Original-Method", request.Method)
+
+	response, err := forwardAuthClient.Do(check)
+	if err != nil {
+		if errors.Is(request.Context().Err(), context.Canceled) {
+			return true
+		}
+		log.Printf("forward auth %s: %v", request.Host, err)
+		renderError(writer, request, options, http.StatusBadGateway, "the auth service did not answer")
+		return true
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		for _, name := range auth.headers {
+			// The client can't claim to be someone the service didn't say.
+			request.Header.Del(name)
+			if values := response.Header.Values(name); len(values) > 0 {
+				request.Header[name] = values
+			}
+		}
+		return false
+	}
+	if response.StatusCode == http.StatusUnauthorized && auth.signIn != "" {
+		http.Redirect(writer, request, strings.ReplaceAll(auth.signIn, "{url}", url.QueryEscape(requestURL(request))), http.StatusFound)
+		return true
+	}
+	// e.g. a redirect to the sign-in page, with the cookies it sets
+	for name, values := range response.Header {
+		if name == "Content-Length" || name == "Connection" || name == "Transfer-Encoding" {
+			continue
+		}
+		writer.Header()[name] = values
+	}
+	writer.WriteHeader(response.StatusCode)
+	_, _ = io.Copy(writer, io.LimitReader(response.Body, forwardAuthBodyLimit))
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestForwardAuth(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Header.Get("Cookie") {
+		case "session=alice":
+			writer.Header().Set("Remote-User", "alice")
+			writer.WriteHeader(http.StatusOK)
+		case "":
+			writer.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.Redirect(writer, request, "https://sso.test/login?rd="+url.QueryEscape(request.Header.Get("X-Original-Url")), http.StatusFound)
+		}
+	}))
+	t.Cleanup(service.Close)
+	auth, err := parseForwardAuth(map[string]string{
+		"SUB2PORT_FORWARD_AUTH":         service.URL + "/verify",
+		"SUB2PORT_FORWARD_AUTH_HEADERS": "remote-user",
+		"SUB2PORT_FORWARD_AUTH_SIGNIN":  "https://sso.test/login?rd={url}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := &hostOptions{ForwardAuth: auth}
+	send := func(cookie string) (*httptest.ResponseRecorder, *http.Request) {
+		request := matchedTo(httptest.NewRequest(http.MethodGet, "http://app.test/private?a=1", nil), "app.test", options)
+		request.Header.Set("Remote-User", "mallory")
+		if cookie != "" {
+			request.Header.Set("Cookie", cookie)
+		}
+		recorder := httptest.NewRecorder()
+		if !filterForwardAuth(recorder, request, options) {
+			recorder.Code = 0
+		}
+		return recorder, request
+	}
+
+	recorder, request := send("session=alice")
+	if recorder.Code != 0 || request.Header.Get("Remote-User") != "alice" {
+		t.Fatalf("expected alice to pass, got %d %v", recorder.Code, request.Header)
+	}
+	want := "https://sso.test/login?rd=" + url.QueryEscape("http://app.test/private?a=1")
+	for _, cookie := range []string{"", "session=expired"} {
+		recorder, _ = send(cookie)
+		if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != want {
+			t.Fatalf("%q: expected a redirect to sign in, got %d %v", cookie, recorder.Code, recorder.Header())
+		}
+	}
+
+	options.ForwardAuth = &forwardAuth{url: "http://127.0.0.1:1/verify"}
+	if recorder, _ = send("session=alice"); recorder.Code != http.StatusBadGateway {
+		t.Fatalf("expected a down auth service to keep clients out, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Forwarding headers
+
+// Proxies in front of sub2port whose forwarding headers are kept, from SUB2PORT_TRUSTED_PROXIES
+var trustedProxies = parseTrustedProxies(os.Getenv("SUB2PORT_TRUSTED_PROXIES"))
+
+func parseTrustedProxies(value string) []netip.Prefix {
+	prefixes, err := parsePrefixes(value)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_TRUSTED_PROXIES: %w", err)))
+	}
+	return prefixes
+}
+
+func trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && containsIP(trustedProxies, addr.Unmap())
+}
+
+// The address of the peer, without its port
+func peerIP(request *http.Request) string {
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return ip
+}
+
+// The client's address: the peer, or the last untrusted address a trusted peer forwarded for
+func clientIP(request *http.Request) string {
+	ip := peerIP(request)
+	if !trusted(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if hop := strings.TrimSpace(forwarded[i]); hop != "" {
+			ip = hop
+			if !trusted(hop) {
+				break
+			}
+		}
+	}
+	return ip
+}
+
+// Tell the backend who the client is and how it connected. Headers from
+// trusted proxies are kept and appended to, others are replaced.
+func setForwarded(request *http.Request) {
+	header := request.Header
+	peer := peerIP(request)
+	proto := "http"
+	if request.TLS != nil {
+		proto = "https"
+	}
+	port := map[string]string{"http": "80", "https": "443"}[proto]
+	if local, ok := request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, localPort, err := net.SplitHostPort(local.String()); err == nil {
+			port = localPort
+		}
+	}
+	if !trusted(peer) {
+		// The reverse proxy sets X-Forwarded-For to the peer when it is missing.
+		for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Port", "Forwarded"} {
+			header.Del(name)
+		}
+	}
+	if header.Get("X-Forwarded-Proto") == "" {
+		header.Set("X-Forwarded-Proto", proto)
+	}
+	if header.Get("X-Forwarded-Host") == "" {
+		header.Set("X-Forwarded-Host", request.Host)
+	}
+	if header.Get("X-Forwarded-Port") == "" {
+		header.Set("X-Forwarded-Port", port)
+	}
+	// RFC 7239: IPv6 addresses are bracketed and quoted
+	node := peer
+	if strings.Contains(peer, ":") {
+		node = `"[` + peer + `]"`
+	}
+	element := fmt.Sprintf("for=%s;host=%q;proto=%s", node, request.Host, proto)
+	if prior := strings.Join(header.Values("Forwarded"), ", "); prior != "" {
+		element = prior + ", " + element
+	}
+	header.Set("Forwarded", element)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Forwarding headers of trusted proxies are appended to, others are replaced
+func TestForwardedHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"} {
+			fmt.Fprintf(writer, "%s: %s\n", name, request.Header.Get(name))
+		}
+	}))
+	t.Cleanup(backend.Close)
+	routeTo(t, "forwarded.test", backend, nil)
+	previous := trustedProxies
+	trustedProxies = parseTrustedProxies("10.0.0.0/8")
+	t.Cleanup(func() { trustedProxies = previous })
+
+	for peer, want := range map[string]string{
+		"192.0.2.1:1234": "X-Forwarded-For: 192.0.2.1\nX-Forwarded-Proto: http\nX-Forwarded-Host: forwarded.test\nForwarded: for=192.0.2.1;host=\"forwarded.test\";proto=http\n",
+		"10.0.0.2:1234":  "X-Forwarded-For: 198.51.100.1, 10.0.0.2\nX-Forwarded-Proto: https\nX-Forwarded-Host: app.test\nForwarded: for=198.51.100.1, for=10.0.0.2;host=\"forwarded.test\";proto=http\n",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "http://forwarded.test/", nil)
+		request.RemoteAddr = peer
+		request.Header.Set("X-Forwarded-For", "198.51.100.1")
+		request.Header.Set("X-Forwarded-Proto", "https")
+		request.Header.Set("X-Forwarded-Host", "app.test")
+		request.Header.Set("Forwarded", "for=198.51.100.1")
+		recorder := httptest.NewRecorder()
+		proxy(recorder, request)
+		if recorder.Body.String() != want {
+			t.Errorf("expected from %s:\n%s\ngot:\n%s", peer, want, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func FuzzNormalizeHost(f *testing.F) {
+	for _, seed := range []string{"app.test", "App.Test.:8080", "[::1]:80", "[::1]", "a:b:c", "..", "", "x.:", "İ.test", "[:]:"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, host string) {
+		normal := normalizeHost(host)
+		if again := normalizeHost(string(normal)); again != normal {
+			t.Fatalf("%q normalizes to %q, then to %q", host, normal, again)
+		}
+		if strings.HasSuffix(string(normal), ".") {
+			t.Fatalf("%q normalizes to %q, with a trailing dot", host, normal)
+		}
+	})
+}
+
+func FuzzParseHostEntry(f *testing.F) {
+	for _, seed := range []string{"app.test", "app.test:8080", "App.test:", "[::1]:80", ":80", "a:b:c", " app.test ", "app.test/api:8080", "app.test/:3000", "App.test//API//"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, entry string) {
+		route, port := parseHostEntry(entry, "80")
+		host, path := splitRoute(route)
+		if normalizeHost(string(host)) != host {
+			t.Fatalf("%q routes %q, which isn't normalized", entry, host)
+		}
+		if strings.HasSuffix(path, "/") {
+			t.Fatalf("%q routes path %q with a trailing slash", entry, path)
+		}
+		if port == "" {
+			t.Fatalf("%q routes %q without a port", entry, host)
+		}
+	})
+}
+
+func FuzzParseConfigFile(f *testing.F) {
+	f.Add([]byte("app.test\nSUB2PORT_METHODS=GET\n# comment\n"))
+	f.Add([]byte("SUB2PORT=a.test,b.test:8080\r\nSUB2PORT_0=c.test\n=\n"))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		for key := range parseConfigFile(file) {
+			if !strings.HasPrefix(key, "SUB2PORT") {
+				t.Fatalf("file sets %q, which isn't a SUB2PORT var", key)
+			}
+		}
+	})
+}
+
+func FuzzParseOptions(f *testing.F) {
+	f.Add("SUB2PORT_METHODS", "GET, PUT")
+	f.Add("SUB2PORT_SCHEDULE", "off 0 1 * * *;on 0 5 * * *")
+	f.Add("SUB2PORT_READ_ONLY", "405")
+	f.Add("SUB2PORT_HEDGE", "-1s")
+	f.Add("SUB2PORT_ERROR_PAGE", "{{.Host")
+	previous := log.Writer()
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(previous) })
+	f.Fuzz(func(t *testing.T, key, value string) {
+		// These read files of the proxy container, which aren't fuzzed.
+		if key == "SUB2PORT_SORRY" || key == "SUB2PORT_TLS_CA" {
+			t.Skip()
+		}
+		vars := map[string]string{"SUB2PORT": "app.test", key: value}
+		parseOptions("fuzz", vars)
+		lintOptions(vars)
+	})
+}
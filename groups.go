@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Routing a host to groups of backends by a request header
+
+// A container's SUB2PORT_MATCH, e.g. "X-Tenant: acme" or "Accept-Language: de, fr"
+type headerMatch struct {
+	header string   // canonical header name
+	values []string // lower case, any of which selects the container
+}
+
+func parseHeaderMatch(value string) (*headerMatch, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	header, values, ok := strings.Cut(value, ":")
+	header = strings.TrimSpace(header)
+	if !ok || header == "" || strings.ContainsAny(header, " \t") {
+		return nil, fmt.Errorf("expected \"<header>: <value>[, ...]\", got %q", value)
+	}
+	match := &headerMatch{header: http.CanonicalHeaderKey(header)}
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			match.values = append(match.values, value)
+		}
+	}
+	if len(match.values) == 0 {
+		return nil, fmt.Errorf("no values to match %s against", header)
+	}
+	return match, nil
+}
+
+// How well a request matches, lower is better, or -1 when it doesn't
+func (match *headerMatch) rank(request *http.Request) int {
+	if match.header != "Accept-Language" {
+		if slices.Contains(match.values, strings.ToLower(strings.TrimSpace(request.Header.Get(match.header)))) {
+			return 0
+		}
+		return -1
+	}
+	// The client's languages by weight, without those it refuses
+	for rank, tag := range acceptedLangs(request.Header.Get("Accept-Language")) {
+		primary, _, _ := strings.Cut(tag, "-")
+		if slices.Contains(match.values, tag) || slices.Contains(match.values, primary) {
+			return rank
+		}
+	}
+	return -1
+}
+
+// The backends of the group a request belongs to: those whose SUB2PORT_MATCH it
+// matches best, or else those without one, or else all of them
+func (pool *hostPool) group(request *http.Request) *hostPool {
+	if !slices.ContainsFunc(pool.backends, func(backend route) bool { return backend.Options.Match != nil }) {
+		return pool
+	}
+	best := -1
+	ranks := make([]int, len(pool.backends))
+	for i, backend := range pool.backends {
+		ranks[i] = -1
+		if backend.Options.Match != nil {
+			ranks[i] = backend.Options.Match.rank(request)
+		}
+		if ranks[i] >= 0 && (best < 0 || ranks[i] < best) {
+			best = ranks[i]
+		}
+	}
+	group := &hostPool{held: pool.held}
+	for i, backend := range pool.backends {
+		if ranks[i] == best && (best >= 0 || backend.Options.Match == nil) {
+			group.backends = append(group.backends, backend)
+		}
+	}
+	if len(group.backends) == 0 {
+		return pool
+	}
+	return group
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Requests go to the group of backends matching their headers best
+func TestHeaderGroups(t *testing.T) {
+	backend := func(name ContainerName, match string) route {
+		options := parseOptions(name, map[string]string{"SUB2PORT_MATCH": match})
+		return route{Name: name, Options: options}
+	}
+	pool := &hostPool{backends: []route{
+		backend("default", ""),
+		backend("german", "Accept-Language: de"),
+		backend("french", "accept-language: fr, fr-ca"),
+		backend("acme", "X-Tenant: acme"),
+	}}
+	for headers, want := range map[string]string{
+		"":                                     "default",
+		"Accept-Language: de-AT":               "german",
+		"Accept-Language: en, fr;q=0.8, de":    "german",
+		"Accept-Language: en;q=0.5, fr;q=0.9":  "french",
+		"Accept-Language: fr;q=0, de-CH;q=0.1": "german",
+		"Accept-Language: fr;q=0":              "default",
+		"Accept-Language: ja":                  "default",
+		"X-Tenant: ACME":                       "acme",
+		"X-Tenant: other":                      "default",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "http://groups.test/", nil)
+		if name, value, ok := strings.Cut(headers, ": "); ok {
+			request.Header.Set(name, value)
+		}
+		var names []string
+		for _, backend := range pool.group(request).backends {
+			names = append(names, string(backend.Name))
+		}
+		if strings.Join(names, ",") != want {
+			t.Errorf("expected %q to go to %s, got %v", headers, want, names)
+		}
+	}
+	// Without a default group, unmatched requests go to any backend.
+	pool.backends = pool.backends[1:]
+	if group := pool.group(httptest.NewRequest(http.MethodGet, "http://groups.test/", nil)); group != pool {
+		t.Fatalf("expected every backend, got %v", group.backends)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC over HTTP/2, streamed both ways with its trailers
+
+func isGRPC(request *http.Request) bool {
+	contentType := request.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") || strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// gRPC needs HTTP/2, which plain http backends speak without TLS
+var grpcTransport = func() *http.Transport {
+	transport := sharedTransport.Clone()
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}()
+
+// HTTP/1 on the plain listener, and HTTP/2 for gRPC clients that connect with prior knowledge
+func plainProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+// The gRPC status of a proxy error, as gRPC clients map HTTP statuses
+func grpcStatus(code int) int {
+	switch code {
+	case http.StatusBadRequest:
+		return 13 // INTERNAL
+	case http.StatusUnauthorized:
+		return 16 // UNAUTHENTICATED
+	case http.StatusForbidden:
+		return 7 // PERMISSION_DENIED
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return 12 // UNIMPLEMENTED
+	case http.StatusGatewayTimeout:
+		return 4 // DEADLINE_EXCEEDED
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return 14 // UNAVAILABLE
+	}
+	return 2 // UNKNOWN
+}
+
+// Answer a gRPC call with a trailers-only response, which clients read as the call's status
+func writeGRPCError(writer http.ResponseWriter, code int, message string) {
+	writer.Header().Set("Content-Type", "application/grpc")
+	writer.Header().Set("Grpc-Status", strconv.Itoa(grpcStatus(code)))
+	writer.Header().Set("Grpc-Message", grpcMessage(message))
+	writer.WriteHeader(http.StatusOK)
+}
+
+// grpc-message is percent-encoded, outside of printable ASCII
+func grpcMessage(message string) string {
+	var encoded strings.Builder
+	for i := range len(message) {
+		if char := message[i]; char < ' ' || char > '~' || char == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", char)
+		} else {
+			encoded.WriteByte(char)
+		}
+	}
+	return encoded.String()
+}
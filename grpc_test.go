@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGRPC(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.ProtoMajor != 2 {
+			http.Error(writer, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/grpc")
+		writer.Header().Set("Trailer", "Grpc-Status")
+		message, _ := io.ReadAll(request.Body)
+		_, _ = writer.Write(message)
+		writer.Header().Set("Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	t.Cleanup(backend.Close)
+	routeTo(t, "grpc.test", backend, nil)
+
+	call := func(host string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "http://"+host+"/echo.Echo/Say", strings.NewReader("\x00\x00\x00\x00\x02hi"))
+		request.Header.Set("Content-Type", "application/grpc")
+		recorder := httptest.NewRecorder()
+		proxy(recorder, request)
+		return recorder.Result()
+	}
+	response := call("grpc.test")
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "\x00\x00\x00\x00\x02hi" || response.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("unexpected answer %d %q, trailers %v", response.StatusCode, body, response.Trailer)
+	}
+
+	response = call("nowhere.test")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Grpc-Status") != "14" {
+		t.Fatalf("expected UNAVAILABLE, got %d %v", response.StatusCode, response.Header)
+	}
+	if message := grpcMessage("no backend: 100%\n"); message != "no backend: 100%25%0A" {
+		t.Fatalf("unexpected grpc-message %q", message)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Header normalization for picky backends, and rules adding headers they don't set
+
+// Merge and dedupe repeated headers and cap cookies, as the host asks
+func normalizeHeaders(header http.Header, options *hostOptions) {
+	for _, name := range options.MergeHeaders {
+		if values := header[name]; len(values) > 1 {
+			separator := ", "
+			if name == "Cookie" {
+				separator = "; "
+			}
+			header[name] = []string{strings.Join(values, separator)}
+		}
+	}
+	for _, name := range options.DedupeHeaders {
+		if values := header[name]; len(values) > 1 {
+			header[name] = values[:1]
+		}
+	}
+	if options.CookieLimit > 0 {
+		capCookies(header, options.CookieLimit)
+	}
+}
+
+// Drop cookies past the limit, keeping the ones the client sent first
+func capCookies(header http.Header, limit int) {
+	lines := header["Cookie"]
+	size := 0
+	for _, line := range lines {
+		size += len(line)
+	}
+	if size <= limit {
+		return
+	}
+	var kept []string
+	size = 0
+	for _, line := range lines {
+		for _, cookie := range strings.Split(line, ";") {
+			cookie = strings.TrimSpace(cookie)
+			if cookie == "" {
+				continue
+			}
+			if size+len(cookie) > limit {
+				break
+			}
+			kept = append(kept, cookie)
+			size += len(cookie) + len("; ")
+		}
+	}
+	if len(kept) == 0 {
+		header.Del("Cookie")
+		return
+	}
+	header["Cookie"] = []string{strings.Join(kept, "; ")}
+}
+
+// Canonical header names from a comma separated list
+func parseHeaderNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// A change to a header: set, add, set if missing, or remove
+type headerRule struct {
+	op    byte // '=', '+', '?', or '-'
+	name  string
+	value string
+}
+
+// Parse rules, one per line: "Name: value" sets, "+Name: value" adds a line,
+// "?Name: value" sets when missing, and "-Name" removes
+func parseHeaderRules(value string) ([]headerRule, error) {
+	var rules []headerRule
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		rule := headerRule{op: '='}
+		if strings.ContainsRune("+?-", rune(line[0])) {
+			rule.op, line = line[0], line[1:]
+		}
+		name, value, ok := strings.Cut(line, ":")
+		rule.name, rule.value = http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value)
+		switch {
+		case rule.name == "" || strings.ContainsAny(rule.name, " \t"):
+			return nil, fmt.Errorf("expected a header name in %q", line)
+		case rule.op == '-' && ok:
+			return nil, fmt.Errorf("expected -%s without a value", rule.name)
+		case rule.op != '-' && !ok:
+			return nil, fmt.Errorf("expected %s: <value>", rule.name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func applyHeaderRules(header http.Header, rules []headerRule) {
+	for _, rule := range rules {
+		switch rule.op {
+		case '=':
+			header.Set(rule.name, rule.value)
+		case '+':
+			header.Add(rule.name, rule.value)
+		case '?':
+			if header.Get(rule.name) == "" {
+				header.Set(rule.name, rule.value)
+			}
+		case '-':
+			header.Del(rule.name)
+		}
+	}
+}
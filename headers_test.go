@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHeaders(t *testing.T) {
+	header := http.Header{
+		"Accept":     {"text/html", "application/json"},
+		"Cookie":     {"a=1; b=2", "c=3"},
+		"X-Tenant":   {"one", "two"},
+		"User-Agent": {"curl"},
+	}
+	normalizeHeaders(header, &hostOptions{MergeHeaders: parseHeaderNames("accept, cookie"), DedupeHeaders: parseHeaderNames("x-tenant"), CookieLimit: 10})
+	want := http.Header{
+		"Accept":     {"text/html, application/json"},
+		"Cookie":     {"a=1; b=2"},
+		"X-Tenant":   {"one"},
+		"User-Agent": {"curl"},
+	}
+	if fmt.Sprint(header) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, header)
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	rules, err := parseHeaderRules("?x-frame-options: DENY\n  Content-Security-Policy: default-src 'self'; img-src *\n+Vary: Origin\n-server\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"X-Frame-Options": {"SAMEORIGIN"}, "Server": {"nginx"}, "Vary": {"Accept"}}
+	applyHeaderRules(header, rules)
+	want := http.Header{
+		"X-Frame-Options":         {"SAMEORIGIN"},
+		"Content-Security-Policy": {"default-src 'self'; img-src *"},
+		"Vary":                    {"Accept", "Origin"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Fatalf("expected %v, got %v", want, header)
+	}
+	for _, bad := range []string{"X-Frame-Options DENY", "-Server: nginx", ": value", "Bad Name: value"} {
+		if _, err := parseHeaderRules(bad); err == nil {
+			t.Errorf("expected %q to fail", bad)
+		}
+	}
+}
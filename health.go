@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Event stream health
+
+// Resync when a stream is silent for this long
+var streamTimeout = envDuration("SUB2PORT_EVENTS_TIMEOUT", 5*time.Minute)
+
+// A daemon slower than this to answer a ping is unreachable
+var pingTimeout = 5 * time.Second
+
+var resyncs = newCounterVec("sub2port_events_resyncs_total", "Event stream restarts triggered by silence.", "daemon")
+
+func init() {
+	registerMetric(&metricFamily{
+		name:   "sub2port_events_degraded",
+		kind:   "gauge",
+		help:   "Whether the event stream is disconnected, silent, or the daemon is unreachable.",
+		labels: []string{"daemon"},
+		collect: func(emit func(float64, ...string)) {
+			for _, daemon := range daemons {
+				degraded := 0.0
+				if daemon.degraded() {
+					degraded = 1
+				}
+				emit(degraded, daemon.Endpoint)
+			}
+		},
+	})
+	registerMetric(&metricFamily{
+		name:   "sub2port_events_silence_seconds",
+		kind:   "gauge",
+		help:   "Seconds since the last event, resync, or answered ping.",
+		labels: []string{"daemon"},
+		collect: func(emit func(float64, ...string)) {
+			for _, daemon := range daemons {
+				emit(daemon.silence().Seconds(), daemon.Endpoint)
+			}
+		},
+	})
+
+	// The process is alive as long as it can answer.
+	adminMux.HandleFunc("GET /livez", func(writer http.ResponseWriter, _ *http.Request) {
+		writeJSON(writer, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	// Routes are trustworthy only while every stream is healthy.
+	adminMux.HandleFunc("GET /healthz", func(writer http.ResponseWriter, _ *http.Request) {
+		status, code := "ok", http.StatusOK
+		var details []map[string]interface{}
+		for _, daemon := range daemons {
+			if daemon.degraded() {
+				status, code = "degraded", http.StatusServiceUnavailable
+			}
+			details = append(details, map[string]interface{}{
+				"endpoint":        daemon.Endpoint,
+				"connected":       daemon.connected.Load(),
+				"reachable":       daemon.pingOK.Load(),
+				"silence_seconds": int(daemon.silence().Seconds()),
+			})
+		}
+		writeJSON(writer, code, map[string]interface{}{
+			"status":          status,
+			"timeout_seconds": int(streamTimeout.Seconds()),
+			"daemons":         details,
+		})
+	})
+}
+
+func (daemon *dockerDaemon) markSeen() {
+	daemon.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (daemon *dockerDaemon) silence() time.Duration {
+	return time.Since(time.Unix(0, daemon.lastSeen.Load()))
+}
+
+func (daemon *dockerDaemon) degraded() bool {
+	return !daemon.connected.Load() || !daemon.pingOK.Load() || daemon.silence() > streamTimeout
+}
+
+// Ping the daemons and resync event streams that go silent
+func watchStream() {
+	ticker := time.NewTicker(max(streamTimeout/4, time.Second))
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		for _, daemon := range daemons {
+			daemon.checkStream()
+		}
+	}
+}
+
+func (daemon *dockerDaemon) checkStream() {
+	reachable := daemon.ping() == nil
+	daemon.pingOK.Store(reachable)
+	// A daemon that answers is only quiet, as hosts without churn are.
+	if reachable {
+		daemon.markSeen()
+	}
+	if daemon.connected.Load() && daemon.silence() > streamTimeout {
+		resyncs.Inc(daemon.Endpoint)
+		daemon.restartEvents()
+	}
+}
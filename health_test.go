@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Quiet daemons that answer pings are left alone, and silent ones that hang are resynced
+func TestStreamWatchdog(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+	fake.run("watchdog", "10.0.0.1", "SUB2PORT=watchdog.test")
+	eventually(t, "the backend", routedTo("watchdog.test", 1))
+
+	stale := time.Now().Add(-2 * streamTimeout).UnixNano()
+	daemon.lastSeen.Store(stale)
+	daemon.checkStream()
+	if !daemon.pingOK.Load() || daemon.silence() > streamTimeout || daemon.degraded() {
+		t.Fatalf("expected an answered ping to count as a sign of life, silent for %s", daemon.silence())
+	}
+
+	previous := pingTimeout
+	pingTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { pingTimeout = previous })
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		select {
+		case <-request.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(hung.Close)
+	t.Cleanup(func() { close(release) })
+	silent, err := newDockerDaemon("tcp://" + hung.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	silent.cancel = cancel
+	silent.connected.Store(true)
+	silent.lastSeen.Store(stale)
+	start := time.Now()
+	silent.checkStream()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the ping to time out, took %s", elapsed)
+	}
+	if silent.pingOK.Load() || ctx.Err() == nil {
+		t.Fatal("expected the hung daemon to be unreachable and its stream resynced")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Hedged requests
+
+var hedgeWins = newCounterVec("sub2port_hedge_wins_total", "Hedged requests answered first by the second replica.", "host")
+
+// Send a request to a second replica when the first is slow, using the first answer
+type hedgedTransport struct {
+	host      HostName
+	primary   http.RoundTripper
+	alternate route
+	delay     time.Duration
+	answered  route // the picked backend until the alternate or a retry of the primary answers
+}
+
+// Safe to send twice: idempotent, without a body, and not opening a WebSocket
+func hedgeable(request *http.Request) bool {
+	if request.Header.Get("Upgrade") != "" {
+		return false
+	}
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return request.ContentLength == 0
+	}
+	return false
+}
+
+type attempt struct {
+	index    int
+	response *http.Response
+	err      error
+}
+
+func (hedge *hedgedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	results := make(chan attempt, 2)
+	var cancels []context.CancelFunc
+	start := func(request *http.Request, transport http.RoundTripper) {
+		ctx, cancel := context.WithCancel(request.Context())
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			response, err := transport.RoundTrip(request.WithContext(ctx))
+			results <- attempt{index, response, err}
+		}()
+	}
+	start(request, hedge.primary)
+	pending := 1
+	// The alternate takes a slot like any request, held until its answer is copied or dropped.
+	release := func(index int) {
+		if index > 0 {
+			hedge.alternate.inflight.Add(-1)
+		}
+	}
+
+	timer := time.NewTimer(hedge.delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if hedge.alternate.removed() || !hedge.alternate.admit() {
+				continue
+			}
+			if !budget.retry() {
+				release(1)
+				continue
+			}
+			second := request.Clone(request.Context())
+			second.URL.Scheme = hedge.alternate.Options.Scheme
+			second.URL.Host = hedge.alternate.Host + ":" + hedge.alternate.Port
+			start(second, transportFor(hedge.alternate))
+			stateOf(request).trace.log("hedging on %s after %s", hedge.alternate.Name, hedge.delay)
+			pending++
+		case result := <-results:
+			pending--
+			if result.err != nil && pending > 0 {
+				cancels[result.index]()
+				release(result.index)
+				continue // the other attempt may still succeed
+			}
+			// Abort the loser and release its connection.
+			for index, cancel := range cancels {
+				if index != result.index {
+					cancel()
+				}
+			}
+			go func(pending int) {
+				for range pending {
+					loser := <-results
+					if loser.response != nil {
+						_ = loser.response.Body.Close()
+					}
+					release(loser.index)
+				}
+			}(pending)
+			if result.index > 0 {
+				hedge.answered = hedge.alternate
+			} else if retry, ok := hedge.primary.(*retryTransport); ok {
+				hedge.answered = retry.answered
+			}
+			if result.err != nil {
+				cancels[result.index]()
+				release(result.index)
+				return nil, result.err
+			}
+			if result.index > 0 {
+				hedgeWins.Inc(string(hedge.host))
+				result.response.Body = &releaseOnClose{ReadCloser: result.response.Body, backend: hedge.alternate}
+			}
+			result.response.Body = &cancelOnClose{result.response.Body, cancels[result.index]}
+			return result.response, nil
+		}
+	}
+}
+
+// Keep the winner's request alive until its body is consumed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+func transportFor(backend route) http.RoundTripper {
+	transport := http.RoundTripper(sharedTransport)
+	if backend.Options.HTTP1Backends {
+		transport = http1Transport
+	}
+	if backend.Options.Transport != nil {
+		transport = backend.Options.Transport
+	}
+	return routeTransport{backend.retired, transport}
+}
+
+// Send to a backend unless it was removed since it was picked
+type routeTransport struct {
+	retired   *atomic.Bool
+	transport http.RoundTripper
+}
+
+func (backend routeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if backend.retired != nil && backend.retired.Load() {
+		return nil, errRemoved
+	}
+	if backend.transport == sharedTransport && isGRPC(request) {
+		return grpcTransport.RoundTrip(request)
+	}
+	return backend.transport.RoundTrip(request)
+}
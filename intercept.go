@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Intercepted backend statuses
+
+// A backend status replaced at the proxy, handled by proxyError
+type interceptedStatus int
+
+func (code interceptedStatus) Error() string {
+	return fmt.Sprintf("intercepted %d", int(code))
+}
+
+// Parse SUB2PORT_INTERCEPT, e.g. "401 https://login.test/?next={url}; 404 page"
+func parseIntercepts(value string) (map[int]string, error) {
+	intercepts := make(map[int]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		status, action, _ := strings.Cut(entry, " ")
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("expected a status between 400 and 599, got %q", status)
+		}
+		action = strings.TrimSpace(action)
+		if action != "page" {
+			if target, err := url.Parse(action); err != nil || action == "" || target.Scheme == "" && !strings.HasPrefix(action, "/") {
+				return nil, fmt.Errorf("expected page or a redirect URL for %d, got %q", code, action)
+			}
+		}
+		intercepts[code] = action
+	}
+	return intercepts, nil
+}
+
+// Answer an intercepted status with the host's error page or a redirect
+func serveIntercept(writer http.ResponseWriter, request *http.Request, options *hostOptions, code int) {
+	action := options.Intercept[code]
+	if action == "page" {
+		renderError(writer, request, options, code, "")
+		return
+	}
+	// {url} is the page the client asked for, e.g. to come back after logging in.
+	http.Redirect(writer, request, strings.ReplaceAll(action, "{url}", url.QueryEscape(requestURL(request))), http.StatusFound)
+}
+
+// The URL the client asked for, as proxy saw it before pointing the request at its backend
+func requestURL(request *http.Request) string {
+	return stateOf(request).url
+}
+
+// The URL of a request as the client sent it
+func clientURL(request *http.Request) string {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + request.Host + request.URL.RequestURI()
+}
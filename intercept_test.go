@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Backend statuses can be answered with a redirect or the host's error page
+func TestIntercept(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(request.URL.Path, "/"))
+		writer.WriteHeader(code)
+	}))
+	t.Cleanup(backend.Close)
+	intercepts, err := parseIntercepts("401 https://login.test/?next={url}; 404 page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routeTo(t, "intercept.test", backend, &hostOptions{Intercept: intercepts})
+
+	for path, want := range map[string]int{"/401": http.StatusFound, "/404": http.StatusNotFound, "/403": http.StatusForbidden} {
+		recorder := httptest.NewRecorder()
+		proxy(recorder, httptest.NewRequest(http.MethodGet, "http://intercept.test"+path, nil))
+		if recorder.Code != want {
+			t.Fatalf("expected %s to be answered with %d, got %d", path, want, recorder.Code)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://intercept.test/401", nil))
+	if location := recorder.Header().Get("Location"); location != "https://login.test/?next=http%3A%2F%2Fintercept.test%2F401" {
+		t.Fatalf("expected a redirect to the login host, got %q", location)
+	}
+
+	// The client comes back to the URL it asked for, not the one rewritten for the backend.
+	address, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	table.Lock()
+	bindRoute("intercept.test/app", route{Name: "intercept-app", Host: address, Port: port, Rewrite: "/", Options: &hostOptions{Scheme: "http", Intercept: intercepts}}, false)
+	table.containers["intercept-app"] = []binding{{Domain: "intercept.test/app", Name: "intercept-app"}}
+	table.Unlock()
+	t.Cleanup(func() { dropRoutes("intercept-app") })
+	recorder = httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://intercept.test/app/401?page=2", nil))
+	if location := recorder.Header().Get("Location"); location != "https://login.test/?next=http%3A%2F%2Fintercept.test%2Fapp%2F401%3Fpage%3D2" {
+		t.Fatalf("expected a redirect back to the client's URL, got %q", location)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Access by client address, e.g. to keep admin UIs on the internal network
+
+type ipAccess struct {
+	allow []netip.Prefix // only these get in, anyone when empty
+	deny  []netip.Prefix // these are kept out, even when allowed
+}
+
+// Addresses and CIDR ranges, separated by commas
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Parse SUB2PORT_ALLOW and SUB2PORT_DENY, nil when neither is set
+func parseIPAccess(vars map[string]string) (*ipAccess, error) {
+	allow, err := parsePrefixes(vars["SUB2PORT_ALLOW"])
+	if err != nil {
+		return nil, fmt.Errorf("SUB2PORT_ALLOW: %w", err)
+	}
+	deny, err := parsePrefixes(vars["SUB2PORT_DENY"])
+	if err != nil {
+		return nil, fmt.Errorf("SUB2PORT_DENY: %w", err)
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	return &ipAccess{allow: allow, deny: deny}, nil
+}
+
+func containsIP(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (access *ipAccess) permits(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if containsIP(access.deny, addr) {
+		return false
+	}
+	return len(access.allow) == 0 || containsIP(access.allow, addr)
+}
+
+// Answer clients outside the host's allowed addresses with 403
+func filterIPAccess(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if options.IPAccess == nil || options.IPAccess.permits(clientIP(request)) {
+		return false
+	}
+	renderError(writer, request, options, http.StatusForbidden, fmt.Sprintf("%s can't be reached from your network", request.Host))
+	return true
+}
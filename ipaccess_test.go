@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPAccess(t *testing.T) {
+	access, err := parseIPAccess(map[string]string{"SUB2PORT_ALLOW": "10.0.0.0/8, 192.168.1.7", "SUB2PORT_DENY": "10.0.0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := trustedProxies
+	trustedProxies = parseTrustedProxies("172.16.0.0/12")
+	t.Cleanup(func() { trustedProxies = previous })
+	options := &hostOptions{IPAccess: access}
+	for _, test := range []struct {
+		peer, forwarded string
+		allowed         bool
+	}{
+		{"10.1.2.3", "", true},
+		{"[::ffff:192.168.1.7]", "", true},
+		{"10.0.0.5", "", false},
+		{"192.168.1.8", "", false},
+		{"172.16.0.2", "10.1.2.3", true},
+		{"172.16.0.2", "10.1.2.3, 203.0.113.9", false},
+		{"203.0.113.9", "10.1.2.3", false},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "http://admin.test/", nil)
+		request.RemoteAddr = test.peer + ":4000"
+		if test.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		recorder := httptest.NewRecorder()
+		if filtered := filterIPAccess(recorder, request, options); filtered == test.allowed || (filtered && recorder.Code != http.StatusForbidden) {
+			t.Errorf("%s via %q: expected allowed %v, got %d", test.peer, test.forwarded, test.allowed, recorder.Code)
+		}
+	}
+	if _, err := parseIPAccess(map[string]string{"SUB2PORT_ALLOW": "10.0.0.0/33"}); err == nil {
+		t.Fatal("expected a bad range to fail")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Leveled logging
+
+// SUB2PORT_LOG_LEVEL: debug, info, warn, or error
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	if err := logLevel.UnmarshalText([]byte(cmp.Or(os.Getenv("SUB2PORT_LOG_LEVEL"), "info"))); err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_LOG_LEVEL: %w", err)))
+	}
+	var handler slog.Handler
+	switch format := os.Getenv("SUB2PORT_LOG_FORMAT"); format {
+	case "":
+		if isTerminal(os.Stderr) {
+			handler = newPrettyHandler()
+		} else {
+			handler = &lineHandler{}
+		}
+	case "text":
+		handler = &lineHandler{}
+	case "pretty":
+		handler = newPrettyHandler()
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
+	default:
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_LOG_FORMAT: expected text, pretty, or json, got %q", format)))
+	}
+	slog.SetDefault(slog.New(handler))
+	// Lines of the log package get their level from their prefix.
+	log.SetFlags(0)
+	log.SetOutput(prefixWriter{})
+}
+
+// "! " lines are errors, and the rest, like "# ", "+ ", and "- " lines, are info
+type prefixWriter struct{}
+
+func (prefixWriter) Write(line []byte) (int, error) {
+	message := strings.TrimSuffix(string(line), "\n")
+	level := slog.LevelInfo
+	if strings.HasPrefix(message, "! ") {
+		level = slog.LevelError
+	}
+	slog.Log(context.Background(), level, message)
+	return len(line), nil
+}
+
+// Writes the message like the log package did, leaving the attributes to JSON logs
+type lineHandler struct {
+	sync.Mutex
+}
+
+func (handler *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (handler *lineHandler) Handle(_ context.Context, record slog.Record) error {
+	handler.Lock()
+	defer handler.Unlock()
+	_, err := fmt.Fprintf(os.Stderr, "%s %s\n", record.Time.Format("2006/01/02 15:04:05"), record.Message)
+	return err
+}
+
+func (handler *lineHandler) WithAttrs([]slog.Attr) slog.Handler { return handler }
+func (handler *lineHandler) WithGroup(string) slog.Handler      { return handler }
+
+// Dumps for troubleshooting are only built when they are logged
+func debugLogging() bool {
+	return logLevel.Level() <= slog.LevelDebug
+}
+
+// A route change, as "+ host (backends) -> name:port" with its fields for JSON logs
+func logRoute(change string, host HostName, backends int, name ContainerName, port, via string) {
+	event := map[string]string{"+": "route_added", "-": "route_removed"}[change]
+	recordChange(routeChange{Time: time.Now(), Change: change, Host: host, Backends: backends, Container: name, Port: port})
+	attrs := []any{"event", event, "host", host, "backends", backends, "container", name, "port", port}
+	if via != "" {
+		attrs = append(attrs, "via", strings.TrimPrefix(via, " via "))
+	}
+	slog.Info(fmt.Sprintf("%s %s (%d) -> %s:%s%s", change, host, backends, name, port, via), attrs...)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// Lines of the log package are info unless they start with "! "
+func TestPrefixLevels(t *testing.T) {
+	var out strings.Builder
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+		if attr.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return attr
+	}})))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(0)
+		log.SetOutput(prefixWriter{})
+	})
+
+	for _, line := range []string{"! backend down", "# reloaded", "http: TLS handshake error"} {
+		_, _ = prefixWriter{}.Write([]byte(line + "\n"))
+	}
+	want := "level=ERROR msg=\"! backend down\"\nlevel=INFO msg=\"# reloaded\"\nlevel=INFO msg=\"http: TLS handshake error\"\n"
+	if out.String() != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
@@ -1,17 +1,18 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -19,127 +20,208 @@ type ContainerID string
 type ContainerName string
 type HostName string
 
-// Docker API
+// State
 
-type dockerContainer struct {
-	ID ContainerID `json:"Id"`
-}
+var networkName string
+var hostPort string
 
-type dockerEvent struct {
-	Type   string `json:"Type"`
-	Action string `json:"Action"`
-	Actor  struct {
-		ID         ContainerID       `json:"ID"`
-		Attributes map[string]string `json:"Attributes"`
-	} `json:"Actor"`
+var server = &http.Server{
+	Addr:              cmp.Or(os.Getenv("SUB2PORT_LISTEN"), ":80"),
+	Handler:           http.HandlerFunc(proxy),
+	ReadHeaderTimeout: readHeaderTimeout,
+	ReadTimeout:       readTimeout,
+	WriteTimeout:      writeTimeout,
+	IdleTimeout:       idleTimeout,
+	MaxHeaderBytes:    maxHeaderBytes,
+	ConnState:         trackConn,
+	Protocols:         plainProtocols(),
 }
 
-type dockerInspect struct {
-	Name   string `json:"Name"`
-	Config struct {
-		Env          []string            `json:"Env"`
-		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
-	} `json:"Config"`
-	NetworkSettings struct {
-		Ports map[string][]struct {
-			HostIP   string `json:"HostIp"`
-			HostPort string `json:"HostPort"`
-		} `json:"Ports"`
-		Networks map[string]struct {
-			IPAddress string `json:"IPAddress"`
-		} `json:"Networks"`
-	} `json:"NetworkSettings"`
-}
+// Logged for requests abandoned by the client, as in nginx
+const statusClientClosedRequest = 499
 
-// Types
+var timedOutRequests = newCounterVec("sub2port_requests_timed_out_total", "Requests the backend didn't answer within SUB2PORT_TIMEOUT.", "host")
 
-type route struct {
-	Name ContainerName
-	Host string
-	Port string
-}
+var canceledRequests = newCounterVec("sub2port_requests_canceled_total", "Requests abandoned by the client before the backend answered.", "host")
 
-type hostEntry struct {
-	backends []route
-	counter  uint64
-}
+var requestCount = newCounterVec("sub2port_requests_total", "Requests by host, the backend that answered (- when answered at the proxy), and status class, e.g. 2xx.", "host", "backend", "code")
 
-type binding struct {
-	Domain HostName
-	Name   ContainerName
-}
+var requestDuration = newHistogramVec("sub2port_request_duration_seconds", "Time to answer requests by host and status class, with trace ID exemplars in OpenMetrics.", durationBuckets, "host", "code")
 
-type routeTable struct {
-	sync.RWMutex
-	hosts      map[HostName]*hostEntry
-	containers map[ContainerID][]binding
-}
+// Router
 
-// State
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		os.Exit(soak(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "top" {
+		os.Exit(top(os.Args[2:]))
+	}
+	checkFileLimit()
 
-var networkName string
-var hostPort string
+	var err error
+	networkName, hostPort, err = detectNetwork()
+	if err != nil {
+		fatal(err)
+	}
+	if networkName != "" {
+		log.Printf("# using network %q", networkName)
+	}
+
+	for _, endpoint := range strings.Split(os.Getenv("SUB2PORT_DOCKER_HOSTS"), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
+			continue
+		}
+		daemon, err := newDockerDaemon(endpoint)
+		if err == nil {
+			err = daemon.useDockerTLS()
+		}
+		if err != nil {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_DOCKER_HOSTS: %w", err)))
+		}
+		log.Printf("# watching %s", endpoint)
+		daemons = append(daemons, daemon)
+	}
+
+	prewarmCompose()
+	serveControlPlane()
+	for _, daemon := range daemons {
+		go daemon.watchEvents()
+	}
+	go watchStream()
+	go watchFlaps()
+	go watchSelf()
+	go watchSchedules()
+	go watchStaticRoutes()
+	go watchExpiry()
+	go watchReconcile()
+	go watchSummary()
+	go watchRateLimits()
+	if certs != nil {
+		go serveTLS()
+	}
+	if sniAddr != "" {
+		go serveSNI()
+	}
+	listener, err := listen(server.Addr)
+	if err != nil {
+		fatal(fail(failBind, err))
+	}
+	log.Printf("# listening on :%s", hostPort)
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fatal(err)
+	}
+	select {} // a restart is draining connections
+}
 
-var table = routeTable{
-	hosts:      make(map[HostName]*hostEntry),
-	containers: make(map[ContainerID][]binding),
+// Read a duration setting from the environment
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("%s: %w", name, err)))
+	}
+	return duration
 }
 
-var networkQuery string
-var eventsQuery = "http://localhost" + dockerQuery("/events", map[string][]string{
-	"type":  {"container"},
-	"event": {"start", "stop"},
-})
-
-// dockerClient talks to the Docker daemon over the unix socket.
-var dockerClient = &http.Client{
-	Transport: &http.Transport{
-		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-			return net.Dial("unix", "/var/run/docker.sock")
-		},
-	},
+// Read a decimal setting from the environment
+func envFloat(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("%s: %w", name, err)))
+	}
+	return number
 }
 
-// Router
+// Read a boolean setting from the environment
+func envBool(name string) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("%s: %w", name, err)))
+	}
+	return enabled
+}
 
-func main() {
-	var err error
-	networkName, hostPort, err = detectNetwork()
+// Read a "key=value,..." setting from the environment
+func envMap(name string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// Read an integer setting from the environment
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
 	if err != nil {
-		log.Fatalf("detect network: %v", err)
+		fatal(fail(failConfig, fmt.Errorf("%s: %w", name, err)))
 	}
-	log.Printf("# using network %q", networkName)
-	networkQuery = dockerQuery("/containers/json", map[string][]string{
-		"network": {networkName},
-	})
+	return number
+}
 
-	go watchEvents()
-	log.Printf("# listening on :%s", hostPort)
-	log.Fatal(http.ListenAndServe(":80", http.HandlerFunc(proxy)))
+// The proxy's own container ID, or its short prefix from the hostname
+var selfID ContainerID
+
+func isSelf(containerID ContainerID) bool {
+	return selfID != "" && strings.HasPrefix(string(containerID), string(selfID))
 }
 
 // Inspect the network name and host port
 func detectNetwork() (string, string, error) {
 	hostname, err := os.ReadFile("/etc/hostname")
+	containerID := strings.TrimSpace(string(hostname))
+	var container dockerInspect
+	if err == nil {
+		err = localDaemon.get("/containers/"+containerID+"/json", &container)
+	}
+	// Outside a container, e.g. on another host, there is no network to join.
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errNotFound) {
+		if localDaemon.Addr == "" && os.Getenv("SUB2PORT_DOCKER_HOSTS") == "" {
+			log.Printf("! not running in a container, set SUB2PORT_HOST_GATEWAY to route published ports")
+		}
+		_, port, _ := net.SplitHostPort(server.Addr)
+		return os.Getenv("SUB2PORT_NETWORK"), port, nil
+	}
 	if err != nil {
-		return "", "", fmt.Errorf("read /etc/hostname: %w", err)
+		return "", "", fail(failDocker, fmt.Errorf("inspect self: %w", err))
 	}
-	containerID := strings.TrimSpace(string(hostname))
+	selfID = ContainerID(containerID)
 
-	var container dockerInspect
-	if err := dockerGet("/containers/"+containerID+"/json", &container); err != nil {
-		return "", "", fmt.Errorf("inspect self: %w", err)
+	if name := os.Getenv("SUB2PORT_NETWORK"); name != "" {
+		if err := bootstrapNetwork(name, containerID); err != nil {
+			return "", "", fail(failNetwork, fmt.Errorf("bootstrap network %q: %w", name, err))
+		}
 	}
 
-	var network string
-	for name := range container.NetworkSettings.Networks {
-		if name != "bridge" && name != "host" && name != "none" {
-			network = name
-			break
+	network := os.Getenv("SUB2PORT_NETWORK")
+	if network == "" {
+		for name := range container.NetworkSettings.Networks {
+			if name != "bridge" && name != "host" && name != "none" {
+				network = name
+				break
+			}
 		}
 	}
 	if network == "" {
-		return "", "", fmt.Errorf("no custom network found on container %s", containerID)
+		return "", "", fail(failNetwork, fmt.Errorf("no custom network found on container %s", containerID))
 	}
 
 	// Detect the host port mapped to the container.
@@ -159,164 +241,252 @@ func detectNetwork() (string, string, error) {
 	return network, port, nil
 }
 
-func proxy(writer http.ResponseWriter, request *http.Request) {
-	host := HostName(strings.Split(request.Host, ":")[0])
+// Create the proxy network if it is missing and join it
+func bootstrapNetwork(name, containerID string) error {
+	var network struct {
+		Containers map[string]struct{} `json:"Containers"`
+	}
+	err := localDaemon.get("/networks/"+url.PathEscape(name), &network)
+	if errors.Is(err, errNotFound) {
+		create := map[string]interface{}{
+			"Name":           name,
+			"Driver":         cmp.Or(os.Getenv("SUB2PORT_NETWORK_DRIVER"), "bridge"),
+			"CheckDuplicate": true,
+			"Options":        envMap("SUB2PORT_NETWORK_OPTIONS"),
+		}
+		if subnet := os.Getenv("SUB2PORT_NETWORK_SUBNET"); subnet != "" {
+			create["IPAM"] = map[string]interface{}{
+				"Config": []map[string]string{{"Subnet": subnet}},
+			}
+		}
+		if err := localDaemon.post("/networks/create", create); err != nil {
+			return err
+		}
+		log.Printf("# created network %q", name)
+	} else if err != nil {
+		return err
+	}
 
-	table.Lock()
-	entry := table.hosts[host]
-	if entry == nil {
-		table.Unlock()
-		http.Error(writer, fmt.Sprintf("no backend for %s", host), http.StatusBadGateway)
-		return
+	for id := range network.Containers {
+		if strings.HasPrefix(id, containerID) {
+			return nil
+		}
 	}
-	idx := entry.counter % uint64(len(entry.backends))
-	entry.counter++
-	backend := entry.backends[idx]
-	table.Unlock()
+	return localDaemon.post("/networks/"+url.PathEscape(name)+"/connect", map[string]string{
+		"Container": containerID,
+	})
+}
 
-	target, _ := url.Parse(fmt.Sprintf("http://%s:%s", backend.Host, backend.Port))
-	httputil.NewSingleHostReverseProxy(target).ServeHTTP(writer, request)
+func requestHost(request *http.Request) HostName {
+	return normalizeHost(request.Host)
 }
 
-func watchEvents() {
-	for {
-		if err := eventLoop(); err != nil {
-			log.Printf("events: %v", err)
-		}
-		time.Sleep(time.Second) // back off before reconnecting
+// Host names match without a port, case, or trailing dot, e.g. "App.Test.:8080" is "app.test"
+func normalizeHost(host string) HostName {
+	if name, _, err := net.SplitHostPort(host); err == nil && (!strings.Contains(name, ":") || net.ParseIP(name) != nil) {
+		host = name
+	} else if inner, ok := strings.CutPrefix(host, "["); ok && strings.HasSuffix(inner, "]") && net.ParseIP(inner[:len(inner)-1]) != nil {
+		host = inner[:len(inner)-1]
 	}
+	return HostName(strings.TrimRight(strings.ToLower(host), "."))
 }
 
-// Listen for docker events
-func eventLoop() error {
-	// Start listening for events before scanning to avoid race conditions.
-	response, err := dockerClient.Get(eventsQuery)
-	if err != nil {
-		return err
+func proxy(writer http.ResponseWriter, request *http.Request) {
+	if serveChallenge(writer, request) || redirectAlias(writer, request) {
+		return
 	}
-	defer func() { _ = response.Body.Close() }()
+	host := canonicalHost(requestHost(request))
+	trace := startTrace(writer, request, host)
+	host = table.match(host, request.URL.Path)
 
-	// Scan existing containers on the network.
-	var containers []dockerContainer
-	if err := dockerGet(networkQuery, &containers); err != nil {
-		log.Printf("containers: %v", err)
+	entry := table.lookup(host)
+	if entry == nil {
+		if entry = table.lookup(fallbackHost); entry != nil {
+			trace.log("no route, falling back to %s", fallbackHost)
+			host = fallbackHost
+		}
 	}
-	for _, container := range containers {
-		addRoutes(container.ID)
+	if entry == nil {
+		trace.log("no route")
+		renderError(writer, request, noRoute, http.StatusBadGateway, fmt.Sprintf("no backend for %s", host))
+		return
 	}
-
-	jsonDecoder := json.NewDecoder(response.Body)
-	for {
-		var event dockerEvent
-		if err := jsonDecoder.Decode(&event); err != nil {
-			return err
+	pool := entry.pool.Load()
+	if len(pool.backends) == 0 && len(pool.held) == 0 {
+		// The host was removed after the lookup.
+		renderError(writer, request, noRoute, http.StatusBadGateway, fmt.Sprintf("no backend for %s", host))
+		return
+	}
+	options := pool.options()
+	trace.log("%d backends, %d held", len(pool.backends), len(pool.held))
+	if len(pool.backends) == 0 && options.Sorry != nil {
+		options.Sorry.ServeHTTP(writer, request)
+		return
+	}
+	if len(pool.backends) == 0 {
+		renderError(writer, request, options, http.StatusServiceUnavailable, fmt.Sprintf("%s is temporarily unavailable", host))
+		return
+	}
+	if group := pool.group(request); group != pool {
+		trace.log("%d backends match the request's headers", len(group.backends))
+		pool = group
+	}
+	serving := pool.serving()
+	idx := uint64(options.balancer().pick(entry, serving.backends, request))
+	trace.log("%s picked %s", cmp.Or(options.Vars["SUB2PORT_BALANCE"], "round-robin"), serving.backends[idx].Name)
+	if options.Sticky {
+		// Clients pinned to a draining backend stay on it.
+		if pinned, ok := stickyIndex(request, pool); ok {
+			serving, idx = pool, pinned
+		} else {
+			setSticky(writer, request, serving.backends[idx])
 		}
+		trace.log("sticky cookie picked %s", serving.backends[idx].Name)
+	}
+	pool = serving
+	backend := pool.backends[idx]
+
+	recorder := &accessRecorder{ResponseWriter: writer, backend: backend}
+	writer = recorder
+	started, forwarded := time.Now(), false
+	defer func() { observeRequest(host, recorder, forwarded, traceID(request, trace), started) }()
+	if accessLog != "" {
+		defer func() { logAccess(request, recorder, forwarded, started) }()
+	}
+	if trace != nil {
+		defer func() { trace.log("done with %d, %d bytes", recorder.status, recorder.bytes) }()
+	}
 
-		switch {
-		// Query the container's network on start and add routes if on our network
-		case event.Action == "start":
-			addRoutes(event.Actor.ID)
-		// Remove routes when a container stops
-		case event.Action == "stop":
-			removeRoutes(event.Actor.ID)
+	// Filters key their state by the matched route, so it is known before they run.
+	state := &proxyState{host: host, path: request.URL.Path, url: clientURL(request), pool: pool, index: idx, backend: backend, options: options, recorder: recorder, trace: trace}
+	request = request.WithContext(context.WithValue(request.Context(), proxyStateKey{}, state))
+
+	for _, filter := range filters {
+		if filter(writer, request, options) {
+			trace.log("answered at the proxy")
+			return
 		}
 	}
-}
 
-func dockerGet(path string, out interface{}) error {
-	response, err := dockerClient.Get("http://localhost" + path)
-	if err != nil {
-		return err
+	// gRPC and WebSocket streams can't wait for the whole request.
+	if options.Buffer == "request" && !isGRPC(request) && !isExtendedConnect(request) {
+		if err := bufferBody(request); err != nil {
+			renderError(writer, request, options, http.StatusBadRequest, "the request body could not be read")
+			return
+		}
 	}
-	defer func() { _ = response.Body.Close() }()
-	return json.NewDecoder(response.Body).Decode(out)
-}
 
-// Escape JSON queries for the Docker API
-func dockerQuery(path string, filters interface{}) string {
-	query, _ := json.Marshal(filters)
-	return path + "?filters=" + url.QueryEscape(string(query))
-}
-
-// Parse a container's route config
-func addRoutes(containerID ContainerID) {
-	removeRoutes(containerID)
+	// The transport may pick another backend, so count the one finally sent to.
+	if options.Concurrency != nil {
+		next, ok := admitBackend(pool.backends, idx)
+		if ok && options.Sticky && next != idx {
+			pool.backends[next].inflight.Add(-1)
+			ok = false
+		}
+		if !ok {
+			concurrencyLimited.Inc(string(host))
+			writer.Header().Set("Retry-After", "1")
+			renderError(writer, request, options, http.StatusServiceUnavailable, fmt.Sprintf("%s is overloaded, try again shortly", host))
+			return
+		}
+		if next != idx {
+			trace.log("%s is at its limit, picked %s", backend.Name, pool.backends[next].Name)
+			idx, backend = next, pool.backends[next]
+			state.index, state.backend, recorder.backend = idx, backend, backend
+		}
+	} else {
+		backend.inflight.Add(1)
+	}
+	defer func() { state.backend.inflight.Add(-1) }()
+
+	budget.request()
+
+	// Tell the backend how long it has, so it can shed work it can't finish.
+	if options.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(request.Context(), options.Timeout)
+		defer cancel()
+		request = request.WithContext(ctx)
+		deadline, _ := ctx.Deadline()
+		request.Header.Set("X-Timeout-Ms", strconv.FormatInt(options.Timeout.Milliseconds(), 10))
+		request.Header.Set("X-Request-Deadline", deadline.UTC().Format(time.RFC3339Nano))
+	}
 
-	var container dockerInspect
-	if err := dockerGet("/containers/"+string(containerID)+"/json", &container); err != nil {
-		log.Printf("inspect %s: %v", containerID[:12], err)
+	forwarded = true
+	if options.EarlyHints {
+		sendEarlyHints(writer, request)
+	}
+	if isExtendedConnect(request) {
+		proxyExtendedConnect(writer, request)
 		return
 	}
-
-	// Ignore containers in other networks
-	network, ok := container.NetworkSettings.Networks[networkName]
-	if !ok || network.IPAddress == "" {
+	if options.Buffer == "stream" || isGRPC(request) {
+		streamingProxy.ServeHTTP(writer, request)
 		return
 	}
+	reverseProxy.ServeHTTP(writer, request)
+}
 
-	var config string
-	for _, env := range container.Config.Env {
-		if _config, ok := strings.CutPrefix(env, "SUB2PORT="); ok {
-			config = _config
-			break
-		}
+func observeRequest(host HostName, recorder *accessRecorder, forwarded bool, traceID string, started time.Time) {
+	backend, code := "-", "-"
+	if forwarded {
+		backend = string(recorder.backend.Name)
 	}
-	if config == "" {
-		return
+	if recorder.status > 0 {
+		code = strconv.Itoa(recorder.status/100) + "xx"
 	}
+	requestCount.Inc(string(host), backend, code)
+	requestDuration.Observe(time.Since(started).Seconds(), traceID, string(host), code)
+}
 
-	name := ContainerName(strings.TrimPrefix(container.Name, "/"))
-
-	defaultPort := "80"
-	for _port := range container.Config.ExposedPorts {
-		defaultPort = strings.Split(_port, "/")[0] // "8080/tcp" -> "8080"
-		break
+// The W3C trace ID of the request, or its debug trace ID
+func traceID(request *http.Request, trace *debugTrace) string {
+	// traceparent: version-traceid-parentid-flags
+	if parts := strings.Split(request.Header.Get("Traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	if trace != nil {
+		return trace.id
 	}
+	return ""
+}
 
-	var bindings []binding
-	table.Lock()
-	for _, entry := range strings.Split(config, ",") {
-		entry = strings.TrimSpace(entry)
-		if entry == "" {
-			continue
-		}
-		domain, port := entry, defaultPort
-		if _domain, _port, err := net.SplitHostPort(entry); err == nil {
-			domain = _domain
-			port = _port
-		}
-		hostName := HostName(domain)
-		entry := table.hosts[hostName]
-		if entry == nil {
-			entry = &hostEntry{}
-			table.hosts[hostName] = entry
-		}
-		entry.backends = append(entry.backends, route{Name: name, Host: network.IPAddress, Port: port})
-		bindings = append(bindings, binding{Domain: hostName, Name: name})
-		log.Printf("+ %s (%d) -> %s:%s", domain, len(entry.backends), name, port)
+// Per-host filters that can answer a request before it is proxied
+var filters = []func(http.ResponseWriter, *http.Request, *hostOptions) bool{
+	filterShortCircuit,
+	filterHTTPVersion,
+	filterHTTPS,
+	filterSchedule,
+	filterIPAccess,
+	filterRateLimit,
+	filterAuth,
+	filterForwardAuth,
+	filterMethods,
+	filterReadOnly,
+	filterPath,
+	filterWellKnown,
+}
+
+func filterMethods(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if len(options.Methods) == 0 || slices.Contains(options.Methods, request.Method) {
+		return false
 	}
-	table.containers[containerID] = bindings
-	table.Unlock()
+	writer.Header().Set("Allow", strings.Join(options.Methods, ", "))
+	renderError(writer, request, options, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed for %s", request.Method, request.Host))
+	return true
 }
 
-func removeRoutes(containerID ContainerID) {
-	table.Lock()
-	for _, binding := range table.containers[containerID] {
-		entry := table.hosts[binding.Domain]
-		if entry == nil {
-			continue
-		}
-		for i, route := range entry.backends {
-			if route.Name == binding.Name {
-				log.Printf("- %s (%d) -> %s:%s", binding.Domain, len(entry.backends)-1, route.Name, route.Port)
-				entry.backends = append(entry.backends[:i], entry.backends[i+1:]...)
-				break
-			}
-		}
-		if len(entry.backends) == 0 {
-			delete(table.hosts, binding.Domain)
-		}
+// Answer CalDAV/CardDAV service discovery (RFC 6764) at the proxy
+func filterWellKnown(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	var target string
+	switch request.URL.Path {
+	case "/.well-known/caldav":
+		target = options.CalDAV
+	case "/.well-known/carddav":
+		target = options.CardDAV
+	}
+	if target == "" {
+		return false
 	}
-	delete(table.containers, containerID)
-	table.Unlock()
+	http.Redirect(writer, request, target, http.StatusMovedPermanently)
+	return true
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics in the Prometheus text format
+
+type metricFamily struct {
+	name   string
+	kind   string // counter, gauge, or histogram
+	help   string
+	labels []string
+	// Report every sample as label values and a value
+	collect func(emit func(value float64, labelValues ...string))
+	// Format the samples of families that aren't one value per label set
+	format func(openMetrics bool) []string
+}
+
+var metricRegistry struct {
+	sync.Mutex
+	families []*metricFamily
+	vecs     []expirable
+}
+
+// Series partitioned by labels that can be dropped when their host or container is gone
+type expirable interface {
+	expire(label string, expired func(string) bool)
+}
+
+func registerMetric(family *metricFamily) {
+	metricRegistry.Lock()
+	metricRegistry.families = append(metricRegistry.families, family)
+	metricRegistry.Unlock()
+}
+
+// A gauge computed when scraped
+func newGaugeFunc(name, help string, value func() float64) {
+	registerMetric(&metricFamily{
+		name: name,
+		kind: "gauge",
+		help: help,
+		collect: func(emit func(float64, ...string)) {
+			emit(value())
+		},
+	})
+}
+
+// A counter partitioned by label values
+type counterVec struct {
+	sync.RWMutex
+	labels []string
+	values map[string]*atomic.Uint64 // joined label values -> count
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	counter := &counterVec{labels: labels, values: make(map[string]*atomic.Uint64)}
+	metricRegistry.Lock()
+	metricRegistry.vecs = append(metricRegistry.vecs, counter)
+	metricRegistry.Unlock()
+	registerMetric(&metricFamily{
+		name:   name,
+		kind:   "counter",
+		help:   help,
+		labels: labels,
+		collect: func(emit func(float64, ...string)) {
+			counter.Lock()
+			defer counter.Unlock()
+			for key, value := range counter.values {
+				emit(float64(value.Load()), strings.Split(key, "\x00")...)
+			}
+		},
+	})
+	return counter
+}
+
+func (counter *counterVec) Add(delta uint64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	counter.RLock()
+	value := counter.values[key]
+	counter.RUnlock()
+	if value == nil {
+		counter.Lock()
+		if value = counter.values[key]; value == nil {
+			value = &atomic.Uint64{}
+			counter.values[key] = value
+		}
+		counter.Unlock()
+	}
+	value.Add(delta)
+}
+
+func (counter *counterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+// Drop the series whose value of label is expired
+func (counter *counterVec) expire(label string, expired func(string) bool) {
+	i := slices.Index(counter.labels, label)
+	if i < 0 {
+		return
+	}
+	counter.Lock()
+	defer counter.Unlock()
+	for key := range counter.values {
+		if expired(strings.Split(key, "\x00")[i]) {
+			delete(counter.values, key)
+		}
+	}
+}
+
+// Request durations in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The latest traced observation of a bucket, linking it to the trace
+type exemplar struct {
+	traceID string
+	value   float64
+	time    time.Time
+}
+
+type histogramSeries struct {
+	counts    []atomic.Uint64 // by bucket, not cumulative, the last is +Inf
+	count     atomic.Uint64
+	sum       atomic.Uint64 // float64 bits
+	exemplars struct {
+		sync.Mutex
+		buckets []exemplar
+	}
+}
+
+// A histogram partitioned by label values, with an exemplar per bucket in OpenMetrics
+type histogramVec struct {
+	sync.RWMutex
+	name    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries // joined label values -> observations
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	histogram := &histogramVec{name: name, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	registerMetric(&metricFamily{
+		name:   name,
+		kind:   "histogram",
+		help:   help,
+		labels: labels,
+		format: histogram.format,
+	})
+	metricRegistry.Lock()
+	metricRegistry.vecs = append(metricRegistry.vecs, histogram)
+	metricRegistry.Unlock()
+	return histogram
+}
+
+// Record a value, as the exemplar of its bucket if it has a trace ID
+func (histogram *histogramVec) Observe(value float64, traceID string, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	histogram.RLock()
+	series := histogram.series[key]
+	histogram.RUnlock()
+	if series == nil {
+		histogram.Lock()
+		if series = histogram.series[key]; series == nil {
+			series = &histogramSeries{counts: make([]atomic.Uint64, len(histogram.buckets)+1)}
+			series.exemplars.buckets = make([]exemplar, len(histogram.buckets)+1)
+			histogram.series[key] = series
+		}
+		histogram.Unlock()
+	}
+	bucket := sort.SearchFloat64s(histogram.buckets, value)
+	series.counts[bucket].Add(1)
+	series.count.Add(1)
+	for {
+		sum := series.sum.Load()
+		if series.sum.CompareAndSwap(sum, math.Float64bits(math.Float64frombits(sum)+value)) {
+			break
+		}
+	}
+	if traceID != "" {
+		series.exemplars.Lock()
+		series.exemplars.buckets[bucket] = exemplar{traceID, value, time.Now()}
+		series.exemplars.Unlock()
+	}
+}
+
+// Buckets in order, which OpenMetrics requires, with each bucket's exemplar
+func (histogram *histogramVec) format(openMetrics bool) []string {
+	histogram.RLock()
+	defer histogram.RUnlock()
+	keys := slices.Sorted(maps.Keys(histogram.series))
+	labels := append(slices.Clone(histogram.labels), "le")
+	var samples []string
+	for _, key := range keys {
+		series := histogram.series[key]
+		values := strings.Split(key, "\x00")
+		series.exemplars.Lock()
+		var cumulative uint64
+		for i := range series.counts {
+			cumulative += series.counts[i].Load()
+			le := "+Inf"
+			if i < len(histogram.buckets) {
+				le = strconv.FormatFloat(histogram.buckets[i], 'g', -1, 64)
+			}
+			sample := fmt.Sprintf("%s_bucket%s %d", histogram.name, formatLabels(labels, append(slices.Clone(values), le)), cumulative)
+			if example := series.exemplars.buckets[i]; openMetrics && example.traceID != "" {
+				sample += fmt.Sprintf(` # {trace_id="%s"} %g %.3f`, labelEscaper.Replace(example.traceID), example.value, float64(example.time.UnixMilli())/1000)
+			}
+			samples = append(samples, sample)
+		}
+		series.exemplars.Unlock()
+		samples = append(samples,
+			fmt.Sprintf("%s_sum%s %g", histogram.name, formatLabels(histogram.labels, values), math.Float64frombits(series.sum.Load())),
+			fmt.Sprintf("%s_count%s %d", histogram.name, formatLabels(histogram.labels, values), series.count.Load()))
+	}
+	return samples
+}
+
+// Drop the series whose value of label is expired
+func (histogram *histogramVec) expire(label string, expired func(string) bool) {
+	i := slices.Index(histogram.labels, label)
+	if i < 0 {
+		return
+	}
+	histogram.Lock()
+	defer histogram.Unlock()
+	for key := range histogram.series {
+		if expired(strings.Split(key, "\x00")[i]) {
+			delete(histogram.series, key)
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Format label names and values as name="value" pairs
+func formatLabels(names, values []string) string {
+	var labels []string
+	for i, name := range names {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// Write every family in the Prometheus text format, or OpenMetrics, which carries exemplars
+func writeMetrics(writer io.Writer, openMetrics bool) {
+	metricRegistry.Lock()
+	families := slices.Clone(metricRegistry.families)
+	metricRegistry.Unlock()
+
+	for _, family := range families {
+		var samples []string
+		if family.format != nil {
+			samples = family.format(openMetrics)
+		} else {
+			family.collect(func(value float64, labelValues ...string) {
+				samples = append(samples, fmt.Sprintf("%s%s %g", family.name, formatLabels(family.labels, labelValues), value))
+			})
+		}
+		if family.format == nil {
+			slices.Sort(samples)
+		}
+		name := family.name
+		if openMetrics && family.kind == "counter" {
+			// OpenMetrics names the counter family without the _total of its samples.
+			name = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		for _, sample := range samples {
+			fmt.Fprintln(writer, sample)
+		}
+	}
+	if openMetrics {
+		fmt.Fprintln(writer, "# EOF")
+	}
+}
+
+// A reference of every metric, generated from the registry
+func writeMetricsReference(writer io.Writer) {
+	metricRegistry.Lock()
+	families := slices.Clone(metricRegistry.families)
+	metricRegistry.Unlock()
+	slices.SortFunc(families, func(a, b *metricFamily) int { return strings.Compare(a.name, b.name) })
+
+	fmt.Fprintln(writer, "| Metric | Type | Labels | Description |")
+	fmt.Fprintln(writer, "| --- | --- | --- | --- |")
+	for _, family := range families {
+		fmt.Fprintf(writer, "| `%s` | %s | %s | %s |\n", family.name, family.kind, strings.Join(family.labels, ", "), family.help)
+	}
+}
+
+func init() {
+	adminMux.HandleFunc("GET /metrics", func(writer http.ResponseWriter, request *http.Request) {
+		if strings.Contains(request.Header.Get("Accept"), "application/openmetrics-text") {
+			writer.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			writeMetrics(writer, true)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(writer, false)
+	})
+	adminMux.HandleFunc("GET /metrics/reference", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		writeMetricsReference(writer)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// Pinning a container's routes to particular docker hosts
+
+// A SUB2PORT_NODES constraint, e.g. node.labels.zone==eu
+type nodeConstraint struct {
+	field string // "hostname" or "labels.<key>"
+	value string
+	equal bool // == rather than !=
+}
+
+// Parse constraints separated by commas, all of which must hold
+func parseNodeConstraints(value string) ([]nodeConstraint, error) {
+	var constraints []nodeConstraint
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		constraint := nodeConstraint{equal: true}
+		field, value, ok := strings.Cut(item, "==")
+		if !ok {
+			field, value, ok = strings.Cut(item, "!=")
+			constraint.equal = false
+		}
+		field, _ = strings.CutPrefix(strings.TrimSpace(field), "node.")
+		if !ok || (field != "hostname" && !strings.HasPrefix(field, "labels.")) || field == "labels." {
+			return nil, fmt.Errorf("bad constraint %q, expected node.hostname or node.labels.<key>, == or !=, and a value", item)
+		}
+		constraint.field, constraint.value = field, strings.TrimSpace(value)
+		constraints = append(constraints, constraint)
+	}
+	return constraints, nil
+}
+
+// Whether a docker host meets every constraint
+func (info *dockerInfo) meets(constraints []nodeConstraint) bool {
+	return !slices.ContainsFunc(constraints, func(constraint nodeConstraint) bool {
+		value := info.Name
+		if key, ok := strings.CutPrefix(constraint.field, "labels."); ok {
+			value = info.label(key)
+		}
+		return (value == constraint.value) != constraint.equal
+	})
+}
+
+func (info *dockerInfo) label(key string) string {
+	for _, label := range info.Labels {
+		if name, value, _ := strings.Cut(label, "="); name == key {
+			return value
+		}
+	}
+	return ""
+}
+
+// Whether a container may serve from its daemon's docker host, failing closed
+// when the host can't be described
+func (daemon *dockerDaemon) placed(name ContainerName, constraints []nodeConstraint) (string, bool) {
+	info, err := daemon.host()
+	if err != nil {
+		if len(constraints) > 0 {
+			log.Printf("! %s: SUB2PORT_NODES: %v", name, err)
+		}
+		return "", len(constraints) == 0
+	}
+	return info.Name, info.meets(constraints)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+)
+
+// Containers pinned to other nodes are held out of rotation
+func TestNodePinning(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("pinned-eu", "10.0.0.1", "SUB2PORT=pinned.test", "SUB2PORT_NODES=node.labels.zone==eu,node.hostname!=other")
+	fake.run("pinned-us", "10.0.0.2", "SUB2PORT=pinned.test", "SUB2PORT_NODES=node.labels.zone==us")
+	eventually(t, "one backend and one held", func() bool {
+		backends, held := backendCount("pinned.test")
+		return backends == 1 && held == 1
+	})
+	view := viewHost("pinned.test", table.lookup("pinned.test").pool.Load())
+	if backend := view.Backends[0]; backend.Name != "pinned-eu" || backend.Node != "fake-node" {
+		t.Fatalf("expected pinned-eu on fake-node in rotation, got %+v", backend)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Error pages
+
+type errorPage struct {
+	Host    string
+	Code    int
+	Status  string // localized status text
+	Message string
+	Error   string // what went wrong reaching the backend, empty unless it failed
+	Lang    string
+	Brand   string
+}
+
+// Localized status text, keyed by language then status code
+var statusText = map[string]map[int]string{
+	"en": {
+		http.StatusUnauthorized:       "Please sign in to continue",
+		http.StatusForbidden:          "Access to this page is not allowed",
+		http.StatusNotFound:           "The page was not found",
+		http.StatusMethodNotAllowed:   "Method not allowed",
+		http.StatusBadGateway:         "The service is not reachable right now",
+		http.StatusServiceUnavailable: "The service is temporarily unavailable",
+		http.StatusGatewayTimeout:     "The service took too long to respond",
+	},
+	"de": {
+		http.StatusUnauthorized:       "Bitte melden Sie sich an, um fortzufahren",
+		http.StatusForbidden:          "Der Zugriff auf diese Seite ist nicht erlaubt",
+		http.StatusNotFound:           "Die Seite wurde nicht gefunden",
+		http.StatusMethodNotAllowed:   "Methode nicht erlaubt",
+		http.StatusBadGateway:         "Der Dienst ist derzeit nicht erreichbar",
+		http.StatusServiceUnavailable: "Der Dienst ist vorübergehend nicht verfügbar",
+		http.StatusGatewayTimeout:     "Der Dienst hat zu lange nicht geantwortet",
+	},
+	"fr": {
+		http.StatusUnauthorized:       "Veuillez vous connecter pour continuer",
+		http.StatusForbidden:          "L'accès à cette page n'est pas autorisé",
+		http.StatusNotFound:           "La page est introuvable",
+		http.StatusMethodNotAllowed:   "Méthode non autorisée",
+		http.StatusBadGateway:         "Le service est actuellement injoignable",
+		http.StatusServiceUnavailable: "Le service est temporairement indisponible",
+		http.StatusGatewayTimeout:     "Le service a mis trop de temps à répondre",
+	},
+	"es": {
+		http.StatusUnauthorized:       "Inicie sesión para continuar",
+		http.StatusForbidden:          "No se permite el acceso a esta página",
+		http.StatusNotFound:           "No se encontró la página",
+		http.StatusMethodNotAllowed:   "Método no permitido",
+		http.StatusBadGateway:         "El servicio no está disponible en este momento",
+		http.StatusServiceUnavailable: "El servicio no está disponible temporalmente",
+		http.StatusGatewayTimeout:     "El servicio tardó demasiado en responder",
+	},
+}
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head><meta charset="utf-8"><title>{{.Code}} {{.Status}}</title></head>
+<body>
+<h1>{{.Code}} {{.Status}}</h1>
+<p>{{.Message}}</p>
+<hr><small>{{.Brand}}</small>
+</body>
+</html>
+`))
+
+func init() {
+	// SUB2PORT_ERROR_TEMPLATE replaces the built-in page of every host without its own
+	page := strings.TrimSpace(os.Getenv("SUB2PORT_ERROR_TEMPLATE"))
+	if page == "" {
+		return
+	}
+	if !strings.HasPrefix(page, "<") {
+		file, err := os.ReadFile(page)
+		if err != nil {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_ERROR_TEMPLATE: %w", err)))
+		}
+		page = string(file)
+	}
+	tmpl, err := template.New("error").Parse(page)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_ERROR_TEMPLATE: %w", err)))
+	}
+	defaultErrorTemplate = tmpl
+}
+
+// Options of requests without a route, whose error pages use the defaults
+var noRoute = &hostOptions{}
+
+// Write an error response using the host's page template and language
+func renderError(writer http.ResponseWriter, request *http.Request, options *hostOptions, code int, message string) {
+	writeErrorPage(writer, request, options, errorPage{Code: code, Message: message})
+}
+
+// Write an error page, filling in its host, status, language, and brand
+func writeErrorPage(writer http.ResponseWriter, request *http.Request, options *hostOptions, data errorPage) {
+	code := data.Code
+	if isGRPC(request) {
+		writeGRPCError(writer, code, cmp.Or(data.Message, http.StatusText(code)))
+		return
+	}
+	lang := negotiateLang(request.Header.Get("Accept-Language"), options.Langs)
+	status := statusText[lang][code]
+	if status == "" {
+		status = http.StatusText(code)
+	}
+	brand := options.Brand
+	if brand == "" {
+		brand = "sub2port"
+	}
+	page := options.ErrorPage
+	if page == nil {
+		page = defaultErrorTemplate
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Content-Language", lang)
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(code)
+	data.Host, data.Status, data.Lang, data.Brand = request.Host, status, lang, brand
+	err := page.Execute(writer, data)
+	if err != nil {
+		log.Printf("error page %s: %v", request.Host, err)
+	}
+}
+
+// Pick the client's most preferred language that the host supports
+func negotiateLang(accept string, langs []string) string {
+	if len(langs) == 0 {
+		langs = []string{"en"}
+	}
+	for _, tag := range acceptedLangs(accept) {
+		primary, _, _ := strings.Cut(tag, "-")
+		for _, lang := range langs {
+			if lang == tag || lang == primary {
+				return lang
+			}
+		}
+	}
+	return langs[0]
+}
+
+// The languages of an Accept-Language header by weight, keeping their order
+// among equals, without those refused with q=0
+func acceptedLangs(accept string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var tags []weighted
+	for _, entry := range strings.Split(accept, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			quality, _ = strconv.ParseFloat(value, 64)
+		}
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && quality > 0 {
+			tags = append(tags, weighted{tag, quality})
+		}
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int { return cmp.Compare(b.quality, a.quality) })
+	langs := make([]string, len(tags))
+	for i, tag := range tags {
+		langs[i] = tag.tag
+	}
+	return langs
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The operator's template renders pages of hosts without a route and of backends that fail
+func TestErrorTemplate(t *testing.T) {
+	previous := defaultErrorTemplate
+	defaultErrorTemplate = template.Must(template.New("error").Parse("{{.Code}} {{.Host}}: {{.Message}}{{with .Error}} ({{.}}){{end}}"))
+	t.Cleanup(func() { defaultErrorTemplate = previous })
+
+	recorder := httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://nowhere.test/", nil))
+	if recorder.Body.String() != "502 nowhere.test: no backend for nowhere.test" {
+		t.Fatalf("expected the template for a missing route, got %q", recorder.Body)
+	}
+
+	// Nothing listens on the backend's port.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	routeTo(t, "refused.test", closed, nil)
+	recorder = httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://refused.test/", nil))
+	if body := recorder.Body.String(); recorder.Code != http.StatusBadGateway || !strings.HasPrefix(body, "502 refused.test: Bad Gateway (") || !strings.Contains(body, "connection refused)") {
+		t.Fatalf("expected the template with the dial error, got %d %q", recorder.Code, body)
+	}
+
+	// The built-in page keeps the backend's address to the logs.
+	defaultErrorTemplate = previous
+	recorder = httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://refused.test/", nil))
+	if host, _, _ := net.SplitHostPort(closed.Listener.Addr().String()); strings.Contains(recorder.Body.String(), host) {
+		t.Fatalf("expected the page not to name the backend, got %q", recorder.Body)
+	}
+}
+
+// The most preferred language wins, whatever its place in the header, and q=0 refuses one
+func TestNegotiateLang(t *testing.T) {
+	langs := []string{"en", "de", "fr"}
+	for accept, want := range map[string]string{
+		"":                             "en",
+		"de-AT, fr;q=0.9":              "de",
+		"fr;q=0.5, de;q=0.8, en;q=0.1": "de",
+		"de;q=0, fr":                   "fr",
+		"ja, fr;q=0.3, de;q=0.3":       "fr",
+		"de;q=0":                       "en",
+	} {
+		if lang := negotiateLang(accept, langs); lang != want {
+			t.Errorf("%q: expected %s, got %s", accept, want, lang)
+		}
+	}
+}
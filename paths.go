@@ -0,0 +1,64 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Path canonicalization
+
+var repeatedSlashes = regexp.MustCompile(`/{2,}`)
+
+// Redirect to the canonical form of the path, so each page has one URL
+func filterPath(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if options.Slashes == "" && !options.MergeSlashes {
+		return false
+	}
+	canonical := request.URL.Path
+	if options.MergeSlashes {
+		canonical = repeatedSlashes.ReplaceAllString(canonical, "/")
+	}
+	switch options.Slashes {
+	case "add":
+		// Paths of files, like /app.js, keep their form.
+		if !strings.HasSuffix(canonical, "/") && !strings.Contains(path.Base(canonical), ".") {
+			canonical += "/"
+		}
+	case "strip":
+		if canonical != "/" {
+			canonical = strings.TrimRight(canonical, "/")
+		}
+	}
+	// A leading "//" would make the Location another host's, e.g. //evil.com.
+	canonical = "/" + strings.TrimLeft(canonical, "/")
+	if canonical == request.URL.Path {
+		return false
+	}
+	target := url.URL{Path: canonical, RawQuery: request.URL.RawQuery}
+	code := http.StatusMovedPermanently
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		code = http.StatusPermanentRedirect // keep the method and body
+	}
+	http.Redirect(writer, request, target.String(), code)
+	return true
+}
+
+// Replace the path prefix a request was routed on, e.g. /api/users to /users
+func rewritePrefix(request *http.Request, route HostName, rewrite string) {
+	_, prefix := splitRoute(route)
+	rewrite = strings.TrimSuffix(rewrite, "/")
+	request.URL.Path = cmp.Or(rewrite+strings.TrimPrefix(request.URL.Path, prefix), "/")
+	// An encoded path that doesn't start with the prefix is encoded again from the new path.
+	if rest, ok := strings.CutPrefix(request.URL.RawPath, prefix); ok && request.URL.RawPath != "" {
+		request.URL.RawPath = cmp.Or(rewrite+rest, "/")
+	} else {
+		request.URL.RawPath = ""
+	}
+	if prefix != "" {
+		request.Header.Set("X-Forwarded-Prefix", prefix)
+	}
+}
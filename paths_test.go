@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewritePrefix(t *testing.T) {
+	for _, test := range []struct {
+		route   HostName
+		rewrite string
+		path    string
+		want    string
+	}{
+		{"app.test/api", "/", "/api/users", "/users"},
+		{"app.test/api", "/", "/api", "/"},
+		{"app.test/api", "/v1", "/api/users", "/v1/users"},
+		{"app.test/api", "/v1/", "/api", "/v1"},
+		{"app.test", "/v1", "/users", "/v1/users"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "http://app.test"+test.path, nil)
+		rewritePrefix(request, test.route, test.rewrite)
+		if request.URL.Path != test.want {
+			t.Errorf("expected %s on %s -> %s to be %s, got %s", test.path, test.route, test.rewrite, test.want, request.URL.Path)
+		}
+	}
+}
+
+// Redirects stay on the requested host, however many slashes the path starts with
+func TestFilterPath(t *testing.T) {
+	for _, test := range []struct {
+		slashes string
+		path    string
+		want    string
+	}{
+		{"strip", "/docs/", "/docs"},
+		{"strip", "//evil.com/", "/evil.com"},
+		{"add", "//evil.com", "/evil.com"},
+		{"add", "//evil", "/evil/"},
+		{"add", "/app.js", ""},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+		request.URL.Path = test.path
+		redirected := filterPath(recorder, request, &hostOptions{Slashes: test.slashes})
+		if location := recorder.Header().Get("Location"); redirected != (test.want != "") || location != test.want {
+			t.Errorf("%s %s: expected a redirect to %q, got %q", test.slashes, test.path, test.want, location)
+		}
+	}
+}
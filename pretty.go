@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Colored, aligned logs for developers tailing the proxy in a terminal
+
+const (
+	colorReset  = "\033[0m"
+	colorDim    = "\033[2m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// How often the pretty log sums up the route table, when it changed
+var summaryInterval = envDuration("SUB2PORT_LOG_SUMMARY", 30*time.Second)
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type prettyHandler struct {
+	sync.Mutex
+	out   io.Writer
+	color bool
+	width int // of the widest host logged, to align route changes
+}
+
+func newPrettyHandler() *prettyHandler {
+	// https://no-color.org
+	return &prettyHandler{out: os.Stderr, color: os.Getenv("NO_COLOR") == "", width: 24}
+}
+
+func (handler *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (handler *prettyHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := make(map[string]slog.Value)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+		return true
+	})
+	handler.Lock()
+	defer handler.Unlock()
+	line := record.Message
+	color := colorRed
+	switch {
+	case attrs["event"].String() == "route_added" || attrs["event"].String() == "route_removed":
+		host := attrs["host"].String()
+		handler.width = max(handler.width, len(host))
+		line = fmt.Sprintf("%s %-*s %3d -> %s:%s", line[:1], handler.width, host, attrs["backends"].Int64(), attrs["container"], attrs["port"])
+		if via, ok := attrs["via"]; ok {
+			line += " via " + via.String()
+		}
+		color = map[bool]string{true: colorGreen, false: colorYellow}[line[0] == '+']
+	case strings.HasPrefix(line, "! "):
+		color = colorYellow
+	case strings.HasPrefix(line, "# "), record.Level < slog.LevelWarn:
+		color = ""
+	}
+	stamp := record.Time.Format("15:04:05")
+	if !handler.color {
+		_, err := fmt.Fprintf(handler.out, "%s %s\n", stamp, line)
+		return err
+	}
+	if color == "" {
+		_, err := fmt.Fprintf(handler.out, "%s%s%s %s\n", colorDim, stamp, colorReset, line)
+		return err
+	}
+	_, err := fmt.Fprintf(handler.out, "%s%s%s %s%s%s\n", colorDim, stamp, colorReset, color, line, colorReset)
+	return err
+}
+
+func (handler *prettyHandler) WithAttrs([]slog.Attr) slog.Handler { return handler }
+func (handler *prettyHandler) WithGroup(string) slog.Handler      { return handler }
+
+// Log a one-line summary of the route table now and then, in the pretty log
+func watchSummary() {
+	if _, ok := slog.Default().Handler().(*prettyHandler); !ok || summaryInterval <= 0 {
+		return
+	}
+	last := ""
+	for range time.Tick(summaryInterval) {
+		if summary := tableSummary(); summary != last {
+			log.Printf("# %s", summary)
+			last = summary
+		}
+	}
+}
+
+func tableSummary() string {
+	hosts, backends, held := 0, 0, 0
+	table.hosts.Range(func(_, value any) bool {
+		pool := value.(*hostEntry).pool.Load()
+		hosts, backends, held = hosts+1, backends+len(pool.backends), held+len(pool.held)
+		return true
+	})
+	return fmt.Sprintf("%d hosts, %d backends, %d held", hosts, backends, held)
+}
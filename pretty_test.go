@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPrettyLog(t *testing.T) {
+	var out strings.Builder
+	handler := &prettyHandler{out: &out, width: 12}
+	logger := slog.New(handler)
+	logger.Info("+ app.test (1) -> web-1:80", "event", "route_added", "host", "app.test", "backends", 1, "container", "web-1", "port", "80")
+	logger.Info("- api.app.test (0) -> api-1:8080", "event", "route_removed", "host", "api.app.test", "backends", 0, "container", "api-1", "port", "8080")
+	logger.Warn("! web-1: unknown option")
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		"+ app.test       1 -> web-1:80",
+		"- api.app.test   0 -> api-1:8080",
+		"! web-1: unknown option",
+	}
+	for i, line := range lines {
+		if _, text, _ := strings.Cut(line, " "); text != want[i] {
+			t.Errorf("line %d: got %q, want %q", i, text, want[i])
+		}
+	}
+
+	out.Reset()
+	handler.color = true
+	logger.Warn("! web-1: unknown option")
+	if !strings.Contains(out.String(), colorYellow+"! web-1") {
+		t.Errorf("warning not yellow: %q", out.String())
+	}
+}
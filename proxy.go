@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// The shared reverse proxy
+
+// What proxy decided about a request, carried to the reverse proxy hooks
+type proxyState struct {
+	host     HostName
+	path     string // the client's, before a prefix rewrite
+	url      string // the client's, before the request is pointed at its backend
+	pool     *hostPool
+	index    uint64 // of the backend in pool.backends
+	backend  route
+	answered route // the backend whose answer is forwarded, another than backend after a retry or hedge
+	options  *hostOptions
+	recorder *accessRecorder
+	trace    *debugTrace // nil unless the request is traced
+}
+
+type proxyStateKey struct{}
+
+func stateOf(request *http.Request) *proxyState {
+	return request.Context().Value(proxyStateKey{}).(*proxyState)
+}
+
+// Backends that can't be reached in time are answered with 502, and a backend
+// that accepts a request but never answers holds it only this long.
+var dialTimeout = envDuration("SUB2PORT_DIAL_TIMEOUT", 30*time.Second)
+var responseHeaderTimeout = envDuration("SUB2PORT_RESPONSE_HEADER_TIMEOUT", 0)
+
+// Backends that don't need their own TLS settings share one pool of connections
+var sharedTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ResponseHeaderTimeout: responseHeaderTimeout,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          envInt("SUB2PORT_MAX_IDLE_CONNS", 4096),
+	MaxIdleConnsPerHost:   envInt("SUB2PORT_MAX_IDLE_CONNS_PER_BACKEND", 64),
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// One reverse proxy serves every backend, instead of one per request
+var reverseProxy = &httputil.ReverseProxy{
+	Director:       direct,
+	Transport:      stateTransport{},
+	ModifyResponse: modifyResponse,
+	ErrorHandler:   proxyError,
+}
+
+// Flushes every write to the client, for hosts with SUB2PORT_BUFFER=stream and gRPC calls
+var streamingProxy = &httputil.ReverseProxy{
+	Director:       direct,
+	Transport:      stateTransport{},
+	ModifyResponse: modifyResponse,
+	ErrorHandler:   proxyError,
+	FlushInterval:  -1,
+}
+
+// Point the request at its backend; the scheme belongs to the backend, not the host
+func direct(request *http.Request) {
+	state := stateOf(request)
+	request.URL.Scheme = state.backend.Options.Scheme
+	request.URL.Host = state.backend.Host + ":" + state.backend.Port
+	setForwarded(request)
+	normalizeHeaders(request.Header, state.options)
+	applyHeaderRules(request.Header, state.options.RequestHeaders)
+	if state.backend.Tenant != "" {
+		setTenant(request, state.backend.Tenant)
+	}
+	if state.backend.Rewrite != "" {
+		rewritePrefix(request, state.host, state.backend.Rewrite)
+	}
+	if _, ok := request.Header["User-Agent"]; !ok {
+		// Don't send Go's default user agent in place of a missing one.
+		request.Header.Set("User-Agent", "")
+	}
+}
+
+// Send the request with its backend's transport, retrying or hedging as the host asks
+type stateTransport struct{}
+
+func (stateTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	state := stateOf(request)
+	if state.backend.removed() && !state.repick(request) {
+		return nil, errRemoved
+	}
+	state.backend.served.Add(1)
+	options, backends := state.options, state.pool.backends
+	transport := transportFor(state.backend)
+	state.trace.log("sending to %s:%s", state.backend.Host, state.backend.Port)
+	if options.Retries > 0 && len(backends) > 1 {
+		transport = &retryTransport{host: state.host, backends: backends, first: int(state.index), retries: options.Retries}
+	}
+	if options.Hedge > 0 && !options.Sticky && len(backends) > 1 && hedgeable(request) {
+		transport = &hedgedTransport{
+			host:      state.host,
+			primary:   transport,
+			alternate: backends[(state.index+1)%uint64(len(backends))],
+			delay:     options.Hedge,
+			answered:  state.backend,
+		}
+	}
+	started := time.Now()
+	response, err := transport.RoundTrip(request)
+	state.answered = state.backend
+	switch transport := transport.(type) {
+	case *retryTransport:
+		state.answered = transport.answered
+	case *hedgedTransport:
+		state.answered = transport.answered
+	}
+	// Clients that hang up say nothing about the backend, but timeouts do.
+	if limiter := state.answered.limiter; limiter != nil && !errors.Is(request.Context().Err(), context.Canceled) {
+		if latency := time.Since(started); err != nil || loadSample(response, options, latency) {
+			limiter.observe(latency, err != nil || overloadStatus(response.StatusCode), time.Now())
+		}
+	}
+	return response, err
+}
+
+var errRemoved = errors.New("backend was removed")
+
+// Pick again from the host's current backends, after the picked one was removed
+func (state *proxyState) repick(request *http.Request) bool {
+	entry := table.lookup(state.host)
+	if entry == nil {
+		return false
+	}
+	pool := entry.pool.Load().group(request).serving()
+	if len(pool.backends) == 0 {
+		return false
+	}
+	index := uint64(state.options.balancer().pick(entry, pool.backends, request))
+	backend := pool.backends[index]
+	state.trace.log("%s was removed, picked %s", state.backend.Name, backend.Name)
+	backend.inflight.Add(1)
+	state.backend.inflight.Add(-1)
+	state.pool, state.index, state.backend = pool, index, backend
+	state.recorder.backend = backend
+	request.URL.Scheme = backend.Options.Scheme
+	request.URL.Host = backend.Host + ":" + backend.Port
+	return !backend.removed()
+}
+
+func modifyResponse(response *http.Response) error {
+	state := stateOf(response.Request)
+	state.trace.log("%s answered %s", response.Request.URL.Host, response.Status)
+	state.recorder.backend = servedBy(state.pool, state.backend, response)
+	if _, ok := state.options.Intercept[response.StatusCode]; ok {
+		return interceptedStatus(response.StatusCode)
+	}
+	applyHeaderRules(response.Header, state.options.ResponseHeaders)
+	if state.options.EarlyHints {
+		rememberEarlyHints(response)
+	}
+	if state.options.Compress != nil && response.Header.Get("Content-Encoding") == "" && compressibleType(response.Header, state.options) {
+		varyEncoding(response.Header)
+		if compressible(response, state.options) {
+			compressResponse(response)
+		}
+	}
+	if backendHeader {
+		response.Header.Set("X-Sub2port-Backend", state.recorder.backend.String())
+	}
+	return nil
+}
+
+func proxyError(writer http.ResponseWriter, request *http.Request, err error) {
+	state := stateOf(request)
+	host, backend, options := state.host, state.backend, state.options
+	var intercepted interceptedStatus
+	if errors.As(err, &intercepted) {
+		state.trace.log("intercepted %d", int(intercepted))
+		serveIntercept(writer, request, options, int(intercepted))
+		return
+	}
+	state.trace.log("failed: %v", err)
+	if errors.Is(request.Context().Err(), context.DeadlineExceeded) {
+		timedOutRequests.Inc(string(host))
+		writeErrorPage(writer, request, options, errorPage{Code: http.StatusGatewayTimeout, Message: fmt.Sprintf("%s did not answer within %s", host, options.Timeout), Error: err.Error()})
+		return
+	}
+	// The client went away, which already aborted the backend request.
+	if request.Context().Err() != nil {
+		canceledRequests.Inc(string(host))
+		writer.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	log.Printf("proxy %s -> %s:%s: %v", host, backend.Name, backend.Port, err)
+	recordUpstreamError(host, backend.Name, err)
+	if options.Sorry != nil {
+		options.Sorry.ServeHTTP(writer, request)
+		return
+	}
+	// Dial errors name the backend's address, which only templates that ask for .Error show.
+	writeErrorPage(writer, request, options, errorPage{Code: http.StatusBadGateway, Message: http.StatusText(http.StatusBadGateway), Error: err.Error()})
+}
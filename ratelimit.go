@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting per host, and optionally per client address
+
+type rateLimit struct {
+	rate  float64 // requests per second
+	burst int
+	perIP bool // a bucket per client address instead of one for the host
+}
+
+// Parse SUB2PORT_RATELIMIT, e.g. "100r/s burst=50 per=ip", in requests per s, m, or h
+func parseRateLimit(value string) (*rateLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	count, unit, ok := strings.Cut(fields[0], "r/")
+	rate, err := strconv.ParseFloat(count, 64)
+	per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+	if !ok || err != nil || rate <= 0 || per == 0 {
+		return nil, fmt.Errorf("expected a rate like 100r/s, 10r/m, or 1000r/h, got %q", fields[0])
+	}
+	limit := &rateLimit{rate: rate / per, burst: max(1, int(math.Ceil(rate/per)))}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "burst":
+			if limit.burst, err = strconv.Atoi(value); err != nil || limit.burst < 1 {
+				return nil, fmt.Errorf("expected a positive burst, got %q", value)
+			}
+		case "per":
+			if value != "ip" && value != "host" {
+				return nil, fmt.Errorf("expected per=ip or per=host, got %q", value)
+			}
+			limit.perIP = value == "ip"
+		default:
+			return nil, fmt.Errorf("unknown setting %q, expected burst= or per=", field)
+		}
+	}
+	return limit, nil
+}
+
+type rateKey struct {
+	host  HostName
+	ip    string // empty for the host's bucket
+	limit rateLimit
+}
+
+// Buckets by matched route and client, dropped once they refill
+var rateBuckets sync.Map // rateKey -> *tokenBucket
+
+var rateLimited = newCounterVec("sub2port_rate_limited_total", "Requests rejected with 429 by SUB2PORT_RATELIMIT.", "host")
+
+// Reject requests over the host's rate with 429
+func filterRateLimit(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if options.RateLimit == nil {
+		return false
+	}
+	key := rateKey{host: stateOf(request).host, limit: *options.RateLimit}
+	if key.limit.perIP {
+		key.ip = clientIP(request)
+	}
+	bucket, ok := rateBuckets.Load(key)
+	if !ok {
+		bucket, _ = rateBuckets.LoadOrStore(key, newTokenBucket(key.limit.rate, key.limit.burst))
+	}
+	delay := bucket.(*tokenBucket).take()
+	if delay == 0 {
+		return false
+	}
+	rateLimited.Inc(string(key.host))
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	renderError(writer, request, options, http.StatusTooManyRequests, fmt.Sprintf("%s is getting too many requests, try again shortly", request.Host))
+	return true
+}
+
+// Drop the buckets of clients that stopped sending
+func watchRateLimits() {
+	for range time.Tick(time.Minute) {
+		rateBuckets.Range(func(key, bucket any) bool {
+			if bucket.(*tokenBucket).full() {
+				rateBuckets.Delete(key)
+			}
+			return true
+		})
+	}
+}
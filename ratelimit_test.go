@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	limit, err := parseRateLimit("60r/m burst=2 per=ip")
+	if err != nil || *limit != (rateLimit{rate: 1, burst: 2, perIP: true}) {
+		t.Fatalf("unexpected limit %+v, %v", limit, err)
+	}
+	for _, bad := range []string{"100", "100r/d", "0r/s", "10r/s burst=0", "10r/s per=user", "10r/s queue=5"} {
+		if _, err := parseRateLimit(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	options := &hostOptions{RateLimit: limit}
+	send := func(client string) *httptest.ResponseRecorder {
+		request := matchedTo(httptest.NewRequest(http.MethodGet, "http://limited.test/", nil), "limited.test", options)
+		request.RemoteAddr = client + ":1234"
+		recorder := httptest.NewRecorder()
+		if !filterRateLimit(recorder, request, options) {
+			recorder.Code = 0
+		}
+		return recorder
+	}
+	for range 2 {
+		if recorder := send("10.0.0.1"); recorder.Code != 0 {
+			t.Fatalf("expected the burst to pass, got %d", recorder.Code)
+		}
+	}
+	recorder := send("10.0.0.1")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After: 1, got %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder := send("10.0.0.2"); recorder.Code != 0 {
+		t.Fatalf("expected another client to have its own bucket, got %d", recorder.Code)
+	}
+}
+
+func TestRateLimitWildcard(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(backend.Close)
+	limit, _ := parseRateLimit("1r/m burst=1")
+	routeTo(t, "*.limited.test", backend, &hostOptions{RateLimit: limit})
+
+	for _, want := range []struct {
+		host string
+		code int
+	}{{"a.limited.test", http.StatusOK}, {"b.limited.test", http.StatusTooManyRequests}} {
+		recorder := httptest.NewRecorder()
+		proxy(recorder, httptest.NewRequest(http.MethodGet, "http://"+want.host+"/", nil))
+		if recorder.Code != want.code {
+			t.Fatalf("expected %s to share the route's bucket and get %d, got %d", want.host, want.code, recorder.Code)
+		}
+	}
+}
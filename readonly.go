@@ -0,0 +1,60 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Read-only mode
+
+// Admin overrides of the read-only option, by route, e.g. *.app.test for its tenants
+var readOnlyOverrides sync.Map // HostName -> bool
+
+// Methods that only read, RFC 9110's safe methods and WebDAV's, e.g. PROPFIND
+var safeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, "PROPFIND", "REPORT", "SEARCH"}
+
+func init() {
+	adminMux.HandleFunc("PUT /hosts/{host}/read-only", func(writer http.ResponseWriter, request *http.Request) {
+		var enabled bool
+		if err := json.NewDecoder(request.Body).Decode(&enabled); err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "expected true or false"})
+			return
+		}
+		readOnlyOverrides.Store(normalizeRoute(request.PathValue("host")), enabled)
+		writeJSON(writer, http.StatusOK, map[string]bool{"read_only": enabled})
+	})
+	adminMux.HandleFunc("DELETE /hosts/{host}/read-only", func(writer http.ResponseWriter, request *http.Request) {
+		readOnlyOverrides.Delete(normalizeRoute(request.PathValue("host")))
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Reject requests that could write while the host is read-only
+func filterReadOnly(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if slices.Contains(safeMethods, request.Method) {
+		return false
+	}
+	code := options.ReadOnly
+	// Keyed by the matched route, so aliases, tenants, path routes, and the fallback find their override.
+	if enabled, ok := readOnlyOverrides.Load(stateOf(request).host); ok {
+		code = 0
+		if enabled.(bool) {
+			code = cmp.Or(options.ReadOnly, http.StatusServiceUnavailable)
+		}
+	}
+	if code == 0 {
+		return false
+	}
+	if code == http.StatusMethodNotAllowed {
+		writer.Header().Set("Allow", strings.Join(safeMethods, ", "))
+	} else {
+		writer.Header().Set("Retry-After", "60")
+	}
+	renderError(writer, request, options, code, fmt.Sprintf("%s is read-only right now", request.Host))
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// An override set on a wildcard route applies to each of its tenants
+func TestReadOnlyOverride(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(backend.Close)
+	routeTo(t, "*.readonly.test", backend, nil)
+	t.Cleanup(func() { readOnlyOverrides.Delete(HostName("*.readonly.test")) })
+
+	// Keys are matched like host names.
+	set := httptest.NewRequest(http.MethodPut, "/hosts/*.ReadOnly.test./read-only", strings.NewReader("true"))
+	adminMux.ServeHTTP(httptest.NewRecorder(), set)
+	for method, want := range map[string]int{http.MethodPost: http.StatusServiceUnavailable, "PROPFIND": http.StatusNotFound} {
+		recorder := httptest.NewRecorder()
+		proxy(recorder, httptest.NewRequest(method, "http://Acme.readonly.test:8080/save", nil))
+		if recorder.Code != want {
+			t.Fatalf("expected the tenant's %s to be answered with %d, got %d", method, want, recorder.Code)
+		}
+	}
+}
+
+// Hosts served by the fallback route find its override
+func TestReadOnlyFallback(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(backend.Close)
+	routeTo(t, fallbackHost, backend, nil)
+	readOnlyOverrides.Store(fallbackHost, true)
+	t.Cleanup(func() { readOnlyOverrides.Delete(fallbackHost) })
+
+	recorder := httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodDelete, "http://unrouted.readonly.test/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the fallback to be read-only, got %d", recorder.Code)
+	}
+}
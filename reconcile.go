@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Periodic reconciliation with the Docker API
+
+// Rescan this often to repair routes missed between event streams, 0 disables
+var reconcileInterval = envDuration("SUB2PORT_RECONCILE_INTERVAL", time.Minute)
+
+var reconcileRepairs = newCounterVec("sub2port_reconcile_repairs_total", "Routes added or removed by a periodic rescan.", "daemon", "action")
+
+func watchReconcile() {
+	if reconcileInterval <= 0 {
+		return
+	}
+	for range time.Tick(reconcileInterval) {
+		for _, daemon := range daemons {
+			// A disconnected stream rescans when it reconnects.
+			if !daemon.connected.Load() {
+				continue
+			}
+			added, removed := daemon.scanContainers()
+			reconcileRepairs.Add(uint64(added), daemon.Endpoint, "added")
+			reconcileRepairs.Add(uint64(removed), daemon.Endpoint, "removed")
+			if added+removed > 0 {
+				log.Printf("! reconciled %s: %d added and %d removed containers the events missed", daemon, added, removed)
+			}
+		}
+	}
+}
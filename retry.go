@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Retry budget
+
+// Retries are capped at a share of recent requests, so a failing backend
+// can't multiply the traffic sent to the remaining replicas.
+type retryBudget struct {
+	sync.Mutex // serializes moving the window and spending retries
+	ratio      float64
+	minimum    uint64        // retries always allowed per window
+	slot       time.Duration // window / len(requests)
+	epoch      atomic.Int64  // the current slot since the unix epoch
+	requests   [10]atomic.Uint64
+	retries    [10]uint64
+}
+
+var budget = &retryBudget{
+	ratio:   envFloat("SUB2PORT_RETRY_BUDGET", 0.2),
+	minimum: uint64(envInt("SUB2PORT_RETRY_MIN", 10)),
+	slot:    max(envDuration("SUB2PORT_RETRY_WINDOW", 10*time.Second)/10, time.Millisecond),
+}
+
+var retryCount = newCounterVec("sub2port_retries_total", "Retries and hedges by whether the budget allowed them.", "result")
+
+// Move the window forward, clearing expired slots, while holding the lock
+func (budget *retryBudget) advance(epoch int64) {
+	for i := max(budget.epoch.Load(), epoch-int64(len(budget.requests))); i < epoch; i++ {
+		slot := (i + 1) % int64(len(budget.requests))
+		budget.requests[slot].Store(0)
+		budget.retries[slot] = 0
+	}
+	budget.epoch.Store(epoch)
+}
+
+// Count a request, locking only when the window moves
+func (budget *retryBudget) request() {
+	epoch := time.Now().UnixNano() / int64(budget.slot)
+	if budget.epoch.Load() < epoch {
+		budget.Lock()
+		if budget.epoch.Load() < epoch {
+			budget.advance(epoch)
+		}
+		budget.Unlock()
+	}
+	budget.requests[epoch%int64(len(budget.requests))].Add(1)
+}
+
+// Spend a retry if the window has budget left
+func (budget *retryBudget) retry() bool {
+	budget.Lock()
+	defer budget.Unlock()
+	if epoch := time.Now().UnixNano() / int64(budget.slot); budget.epoch.Load() < epoch {
+		budget.advance(epoch)
+	}
+	var requests, retries uint64
+	for i := range budget.requests {
+		requests += budget.requests[i].Load()
+		retries += budget.retries[i]
+	}
+	if retries >= budget.minimum && float64(retries) >= budget.ratio*float64(requests) {
+		retryCount.Inc("denied")
+		return false
+	}
+	budget.retries[budget.epoch.Load()%int64(len(budget.retries))]++
+	retryCount.Inc("allowed")
+	return true
+}
+
+// Retry requests on the next backends when a backend can't be dialed
+type retryTransport struct {
+	host     HostName
+	backends []route
+	first    int // the index of the picked backend
+	retries  int
+	answered route // the backend tried last
+}
+
+// Nothing reached the backend, so the request can be sent again
+func dialFailed(err error) bool {
+	if errors.Is(err, errRemoved) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (retry *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	backend := retry.backends[retry.first]
+	charged := false // whether backend's slot was taken here rather than by proxy
+	response, err := transportFor(backend).RoundTrip(request)
+	resendable := hedgeable(request) || request.Method == http.MethodPut || request.Method == http.MethodDelete
+	for attempt := 1; attempt <= min(retry.retries, len(retry.backends)-1); attempt++ {
+		if err == nil || !dialFailed(err) || !resendable || !replayable(request) || request.Context().Err() != nil {
+			break
+		}
+		// Retries take a slot like any request, and let go of it when they fail too.
+		alternate := retry.backends[(retry.first+attempt)%len(retry.backends)]
+		if !alternate.admit() {
+			stateOf(request).trace.log("not retrying on %s, it is at its limit", alternate.Name)
+			continue
+		}
+		if !budget.retry() {
+			alternate.inflight.Add(-1)
+			break
+		}
+		log.Printf("proxy %s -> %s:%s: %v, retrying", retry.host, backend.Name, backend.Port, err)
+		recordUpstreamError(retry.host, backend.Name, err)
+		if charged {
+			backend.inflight.Add(-1)
+		}
+		backend, charged = alternate, true
+		stateOf(request).trace.log("retrying on %s after: %v", backend.Name, err)
+		next := request.Clone(request.Context())
+		if request.GetBody != nil {
+			next.Body, _ = request.GetBody()
+		}
+		next.URL.Scheme = backend.Options.Scheme
+		next.URL.Host = backend.Host + ":" + backend.Port
+		response, err = transportFor(backend).RoundTrip(next)
+	}
+	retry.answered = backend
+	if charged {
+		if err != nil {
+			backend.inflight.Add(-1)
+		} else {
+			response.Body = &releaseOnClose{ReadCloser: response.Body, backend: backend}
+		}
+	}
+	return response, err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A buffered body is sent again to the next backend when the first can't be dialed
+func TestBufferedRetry(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.Copy(writer, request.Body)
+	}))
+	t.Cleanup(up.Close)
+	options := &hostOptions{Retries: 1, Buffer: "request"}
+	routeTo(t, "buffer.test", down, options)
+	routeTo(t, "buffer.test", up, options)
+
+	// Every other request picks the backend that is down first.
+	for range 2 {
+		recorder := httptest.NewRecorder()
+		proxy(recorder, httptest.NewRequest(http.MethodPut, "http://buffer.test/", strings.NewReader("body")))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "body" {
+			t.Fatalf("expected the body echoed, got %d: %q", recorder.Code, recorder.Body)
+		}
+	}
+}
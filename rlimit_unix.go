@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+	"cmp"
+	"log"
+	"syscall"
+)
+
+// Check the open file limit against the expected connections, each of which
+// holds a client and a backend socket.
+func checkFileLimit() {
+	expected := cmp.Or(maxConns, envInt("SUB2PORT_EXPECTED_CONNS", 4096))
+	required := uint64(2*expected + 256) // room for the docker API, listeners, and files
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		log.Printf("getrlimit: %v", err)
+		return
+	}
+	if limit.Cur >= required {
+		return
+	}
+	// Go already raised the soft limit to the hard limit when it started.
+	if limit.Max >= required {
+		limit.Cur = required
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+			log.Printf("# raised open file limit to %d", required)
+			return
+		}
+	}
+	log.Printf("! open file limit %d is below the %d needed for %d connections", limit.Cur, required, expected)
+	log.Printf("! raise it with `docker run --ulimit nofile=%d:%d` or lower SUB2PORT_EXPECTED_CONNS", required, required)
+}
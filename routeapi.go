@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Admin API for the route table
+
+type backendView struct {
+	Name     ContainerName `json:"name"`
+	ID       ContainerID   `json:"id,omitempty"`
+	Image    string        `json:"image,omitempty"`
+	Project  string        `json:"project,omitempty"`
+	Address  string        `json:"address"`
+	Node     string        `json:"node,omitempty"`
+	Held     bool          `json:"held"`  // quarantined or disabled
+	State    string        `json:"state"` // live, draining, disabled, or why the container is held, e.g. starting
+	Hedge    string        `json:"hedge,omitempty"`
+	ReadOnly int           `json:"read_only,omitempty"`
+	Weight   float64       `json:"weight"`
+	Active   int64         `json:"active"`          // requests in flight, 0 once a draining backend can be stopped
+	Limit    int           `json:"limit,omitempty"` // adaptive limit on active, 0 for none
+	Requests uint64        `json:"requests"`        // sent since the backend was added
+	Share    float64       `json:"share"`           // of the host's requests, for checking the balance
+}
+
+type hostView struct {
+	Host     HostName      `json:"host"`
+	Backends []backendView `json:"backends"`
+}
+
+func viewHost(host HostName, pool *hostPool) hostView {
+	view := hostView{Host: host, Backends: []backendView{}}
+	routes := slices.Concat(pool.backends, pool.held)
+	shares := shares(routes)
+	for i, route := range routes {
+		backend := backendView{
+			Name:     route.Name,
+			ID:       route.ID,
+			Image:    route.Image,
+			Project:  route.Project,
+			Address:  net.JoinHostPort(route.Host, route.Port),
+			Node:     route.Node,
+			Held:     i >= len(pool.backends),
+			ReadOnly: route.Options.ReadOnly,
+			Weight:   route.weight(),
+			Share:    math.Round(shares[i]*1000) / 1000,
+		}
+		switch {
+		case !backend.Held && route.Draining:
+			backend.State = "draining"
+		case !backend.Held:
+			backend.State = "live"
+		case route.State == "" || route.State == "routed":
+			backend.State = "disabled"
+		default:
+			backend.State = route.State
+		}
+		backend.Active = route.active()
+		if route.limiter != nil {
+			backend.Limit = route.limiter.current()
+		}
+		if route.served != nil {
+			backend.Requests = route.served.Load()
+		}
+		if route.Options.Hedge > 0 {
+			backend.Hedge = route.Options.Hedge.String()
+		}
+		view.Backends = append(view.Backends, backend)
+	}
+	return view
+}
+
+func init() {
+	// Filter with ?host=<glob>, ?container=<name>, and ?project=<name>, page with
+	// ?offset= and ?limit=, and read it as text with ?format=table
+	adminMux.HandleFunc("GET /hosts", func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		offset, limit, err := pageParams(query)
+		if err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		hosts := []hostView{}
+		table.hosts.Range(func(key, value any) bool {
+			if view, ok := filterHost(viewHost(key.(HostName), value.(*hostEntry).pool.Load()), query); ok {
+				hosts = append(hosts, view)
+			}
+			return true
+		})
+		slices.SortFunc(hosts, func(a, b hostView) int {
+			return strings.Compare(string(a.Host), string(b.Host))
+		})
+		writer.Header().Set("X-Total-Count", strconv.Itoa(len(hosts)))
+		start := min(offset, len(hosts))
+		hosts = hosts[start : start+min(limit, len(hosts)-start)]
+		if query.Get("format") == "table" {
+			writeHostTable(writer, hosts)
+			return
+		}
+		writeJSON(writer, http.StatusOK, hosts)
+	})
+	adminMux.HandleFunc("GET /hosts/{host}", func(writer http.ResponseWriter, request *http.Request) {
+		host := HostName(request.PathValue("host"))
+		entry := table.lookup(host)
+		if entry == nil {
+			writeJSON(writer, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no backend for %s", host)})
+			return
+		}
+		writeJSON(writer, http.StatusOK, viewHost(host, entry.pool.Load()))
+	})
+
+	// Add a backend by address, e.g. to route around a missed event
+	adminMux.HandleFunc("POST /hosts/{host}/backends", func(writer http.ResponseWriter, request *http.Request) {
+		host := HostName(request.PathValue("host"))
+		var manual struct {
+			Backend string            `json:"backend"` // host:port
+			Options map[string]string `json:"options"` // SUB2PORT_<OPTION> -> value
+		}
+		if err := json.NewDecoder(request.Body).Decode(&manual); err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		table.Lock()
+		err := bindAddress(ContainerID("manual:"+string(host)+"->"+manual.Backend), host, manual.Backend, manual.Options)
+		table.Unlock()
+		if err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(writer, http.StatusCreated, viewHost(host, table.lookup(host).pool.Load()))
+	})
+	adminMux.HandleFunc("DELETE /hosts/{host}/backends/{name}", func(writer http.ResponseWriter, request *http.Request) {
+		host, name := HostName(request.PathValue("host")), ContainerName(request.PathValue("name"))
+		if !unbindBackend(host, name) {
+			writeJSON(writer, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s has no backend %s", host, name)})
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	})
+	// Hold a backend out of rotation, or put it back
+	adminMux.HandleFunc("PUT /hosts/{host}/backends/{name}/disabled", func(writer http.ResponseWriter, request *http.Request) {
+		host, name := HostName(request.PathValue("host")), ContainerName(request.PathValue("name"))
+		var disabled bool
+		if err := json.NewDecoder(request.Body).Decode(&disabled); err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "expected true or false"})
+			return
+		}
+		if !holdBackend(host, name, disabled) {
+			writeJSON(writer, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s has no backend %s", host, name)})
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]bool{"disabled": disabled})
+	})
+	// Stop sending a backend new clients ahead of a manual docker stop, or undo it
+	adminMux.HandleFunc("PUT /hosts/{host}/backends/{name}/draining", func(writer http.ResponseWriter, request *http.Request) {
+		host, name := HostName(request.PathValue("host")), ContainerName(request.PathValue("name"))
+		var draining bool
+		if err := json.NewDecoder(request.Body).Decode(&draining); err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "expected true or false"})
+			return
+		}
+		if !drainBackend(host, name, draining) {
+			writeJSON(writer, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s has no backend %s", host, name)})
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]bool{"draining": draining})
+	})
+}
+
+func pageParams(query url.Values) (offset, limit int, err error) {
+	offset, limit = 0, math.MaxInt
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("bad offset %q", value)
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("bad limit %q", value)
+		}
+	}
+	return offset, limit, nil
+}
+
+// Keep the host if it matches the glob, and only its backends of the container or project
+func filterHost(view hostView, query url.Values) (hostView, bool) {
+	if glob := query.Get("host"); glob != "" {
+		if matched, _ := path.Match(glob, string(view.Host)); !matched {
+			return view, false
+		}
+	}
+	container, project := query.Get("container"), query.Get("project")
+	if container == "" && project == "" {
+		return view, true
+	}
+	view.Backends = slices.DeleteFunc(view.Backends, func(backend backendView) bool {
+		return container != "" && string(backend.Name) != container || project != "" && backend.Project != project
+	})
+	return view, len(view.Backends) > 0
+}
+
+func writeHostTable(writer http.ResponseWriter, hosts []hostView) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	columns := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(columns, "HOST\tBACKEND\tADDRESS\tSTATE\tWEIGHT\tSHARE\tIMAGE")
+	for _, host := range hosts {
+		for _, backend := range host.Backends {
+			fmt.Fprintf(columns, "%s\t%s\t%s\t%s\t%g\t%.1f%%\t%s\n", host.Host, backend.Name, backend.Address, backend.State, backend.Weight, backend.Share*100, orDash(backend.Image))
+		}
+	}
+	_ = columns.Flush()
+}
+
+// Remove one backend of a host, whatever added it
+func unbindBackend(host HostName, name ContainerName) bool {
+	table.Lock()
+	defer table.Unlock()
+	for id, bindings := range table.containers {
+		index := slices.Index(bindings, binding{Domain: host, Name: name})
+		if index < 0 {
+			continue
+		}
+		owner, owned := table.owners[id]
+		table.containers[id] = []binding{bindings[index]}
+		unbindRoutes(id, false, true)
+		if rest := slices.Delete(bindings, index, index+1); len(rest) > 0 {
+			table.containers[id] = rest
+			if owned {
+				table.owners[id] = owner
+			}
+		}
+		log.Printf("# removed %s from %s by request", name, host)
+		return true
+	}
+	return false
+}
+
+// Move a backend between the host's backends and its held routes
+func holdBackend(host HostName, name ContainerName, hold bool) bool {
+	table.Lock()
+	defer table.Unlock()
+	entry := table.lookup(host)
+	if entry == nil {
+		return false
+	}
+	pool := entry.pool.Load().clone()
+	from, to := &pool.held, &pool.backends
+	if hold {
+		from, to = to, from
+	}
+	index := slices.IndexFunc(*from, func(route route) bool { return route.Name == name })
+	if index < 0 {
+		return slices.ContainsFunc(*to, func(route route) bool { return route.Name == name })
+	}
+	moved := (*from)[index]
+	*to = append(*to, moved)
+	*from = slices.Delete(*from, index, index+1)
+	entry.pool.Store(pool)
+	if moved.retired != nil {
+		moved.retired.Store(hold)
+	}
+	log.Printf("# %s %s on %s by request", map[bool]string{true: "disabled", false: "enabled"}[hold], name, host)
+	return true
+}
+
+// Mark a host's backend as draining, or back in rotation
+func drainBackend(host HostName, name ContainerName, draining bool) bool {
+	table.Lock()
+	defer table.Unlock()
+	entry := table.lookup(host)
+	if entry == nil {
+		return false
+	}
+	pool := entry.pool.Load().clone()
+	index := slices.IndexFunc(pool.backends, func(route route) bool { return route.Name == name })
+	if index < 0 {
+		return false
+	}
+	pool.backends[index].Draining = draining
+	entry.pool.Store(pool)
+	log.Printf("# %s %s on %s by request", map[bool]string{true: "draining", false: "undrained"}[draining], name, host)
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A draining backend gets no new clients, but keeps the ones pinned to it
+func TestDrainBackend(t *testing.T) {
+	options := &hostOptions{Sticky: true}
+	for _, name := range []ContainerName{"drain-1", "drain-2"} {
+		backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			fmt.Fprint(writer, name)
+		}))
+		t.Cleanup(backend.Close)
+		routeTo(t, "drain.test", backend, options)
+	}
+	get := func(cookie *http.Cookie) string {
+		request := httptest.NewRequest(http.MethodGet, "http://drain.test/", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		proxy(recorder, request)
+		return recorder.Body.String()
+	}
+	pinned := &http.Cookie{Name: stickyCookie, Value: stickyID(route{Name: "drain-1"})}
+
+	recorder := httptest.NewRecorder()
+	adminMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/hosts/drain.test/backends/drain-1/draining", strings.NewReader("true")))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the backend to drain, got %d %s", recorder.Code, recorder.Body)
+	}
+	for range 4 {
+		if backend := get(nil); backend != "drain-2" {
+			t.Fatalf("expected new clients on drain-2, got %q", backend)
+		}
+	}
+	if backend := get(pinned); backend != "drain-1" {
+		t.Fatalf("expected the pinned client to stay on drain-1, got %q", backend)
+	}
+	if state := viewHost("drain.test", table.lookup("drain.test").pool.Load()).Backends[0].State; state != "draining" {
+		t.Fatalf("expected drain-1 to be draining, got %s", state)
+	}
+}
@@ -0,0 +1,712 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Route table
+
+type route struct {
+	Name    ContainerName
+	ID      ContainerID // empty for static routes
+	Image   string
+	Project string // the compose project
+	Node    string // the docker host's name, empty when unknown
+	Host    string
+	Port    string
+	Options *hostOptions
+	Rewrite string  // replaces the route's path prefix before forwarding, "" to keep it
+	Weight  float64 // share of the host's requests relative to other backends, 1 when 0
+	Tenant  string  // header the subdomain of a wildcard route is forwarded in, "" for none
+	State   string  // the container's state when it was bound, e.g. routed or starting
+
+	Draining bool // kept for clients pinned to it, but not picked for new ones
+
+	inflight *inflight      // requests in flight, shared by copies of the route
+	retired  *atomic.Bool   // set once the route leaves rotation, shared by copies
+	served   *atomic.Uint64 // requests sent since the route was added, shared by copies
+	limiter  *aimdLimiter   // the adaptive limit on inflight, shared by copies, nil for none
+}
+
+// Removed or held since it was picked, so it must not be dialed
+func (route route) removed() bool {
+	return route.retired != nil && route.retired.Load()
+}
+
+// Per-host options declared with SUB2PORT_<OPTION> env vars
+type hostOptions struct {
+	Vars map[string]string // the SUB2PORT_<OPTION> vars the options were parsed from
+
+	Methods []string // allowed request methods, any when empty
+
+	Slashes      string // "add" or "strip" trailing slashes, "" to leave them
+	MergeSlashes bool   // collapse repeated slashes
+
+	Favicon bool     // answer /favicon.ico with 204
+	Pings   []string // paths answered with 200 at the proxy
+
+	CalDAV  string // /.well-known/caldav redirect target
+	CardDAV string // /.well-known/carddav redirect target
+
+	ErrorPage *template.Template // error page template, built-in when nil
+	Langs     []string           // error page languages, first is the default
+	Brand     string             // name shown on built-in error pages
+
+	HTTP1Clients  bool // refuse HTTP/2 from clients
+	HTTP1Backends bool // speak only HTTP/1.1 to backends
+	EarlyHints    bool // answer 103 with the preload links of the path's last page
+
+	Scheme    string          // the backend scheme, http or https
+	Transport *http.Transport // verifies https backends, default when nil
+
+	Balance balancer // picks backends, round-robin when nil
+	Sticky  bool     // pin clients to a backend with a cookie
+
+	Hedge   time.Duration // send idempotent requests to a second replica after this long
+	Timeout time.Duration // the backend's time budget per request, 0 for none
+	Retries int           // backends tried after one can't be dialed
+	Buffer  string        // "request" buffers bodies so they can be retried, "stream" flushes every write
+
+	Compress    []string // media type patterns of responses gzipped for clients, none when nil
+	CompressMin int      // bytes a response needs before it's worth compressing
+
+	ReadOnly int // the status rejecting writes, 0 when writable
+
+	IPAccess  *ipAccess  // the client addresses let in, any when nil
+	RateLimit *rateLimit // requests over it are rejected with 429, unlimited when nil
+
+	Concurrency *concurrencyLimit // bounds of each backend's adaptive limit on requests in flight, unlimited when nil
+
+	Auth        map[string]*bcryptHash // users allowed in with Basic auth, anyone when nil
+	ForwardAuth *forwardAuth           // the service deciding who gets in, anyone when nil
+
+	HTTPSRedirect int      // the status redirecting plain requests to HTTPS, 0 to serve them
+	Tenants       []string // subdomains of a wildcard route ordered their own certificate
+
+	Schedule []scheduleToggle // turns the host on and off
+
+	Nodes []nodeConstraint // docker hosts the container may serve from, any when empty
+	Match *headerMatch     // requests the container serves out of its host's, any when nil
+
+	Intercept map[int]string // backend statuses answered at the proxy, with "page" or a redirect URL
+
+	MergeHeaders  []string // repeated headers joined into one line
+	DedupeHeaders []string // repeated headers cut to their first line
+	CookieLimit   int      // bytes of cookies forwarded, 0 for all
+
+	RequestHeaders  []headerRule // applied to requests before they are forwarded
+	ResponseHeaders []headerRule // applied to the backend's responses
+
+	Sorry *sorryServer // answers in place of backends that can't, nil for an error page
+}
+
+type hostEntry struct {
+	pool    atomic.Pointer[hostPool]
+	counter atomic.Uint64
+}
+
+// A host's backends, replaced rather than modified so requests never lock
+type hostPool struct {
+	backends []route
+	held     []route // routes of quarantined containers
+}
+
+func (pool *hostPool) clone() *hostPool {
+	return &hostPool{backends: slices.Clone(pool.backends), held: slices.Clone(pool.held)}
+}
+
+// The oldest backend's options apply when replicas disagree
+func (pool *hostPool) options() *hostOptions {
+	if len(pool.backends) == 0 {
+		return pool.held[0].Options
+	}
+	return pool.backends[0].Options
+}
+
+// The backends new clients may be sent to, all of them while every one is draining
+func (pool *hostPool) serving() *hostPool {
+	if !slices.ContainsFunc(pool.backends, func(backend route) bool { return backend.Draining }) {
+		return pool
+	}
+	serving := &hostPool{held: pool.held}
+	for _, backend := range pool.backends {
+		if !backend.Draining {
+			serving.backends = append(serving.backends, backend)
+		}
+	}
+	if len(serving.backends) == 0 {
+		return pool
+	}
+	return serving
+}
+
+type binding struct {
+	Domain HostName
+	Name   ContainerName
+}
+
+type routeTable struct {
+	sync.RWMutex          // serializes route changes and guards containers and owners
+	hosts        sync.Map // HostName -> *hostEntry, read without locking
+	paths        sync.Map // HostName -> []string, the path prefixes routed on a host, longest first
+	containers   map[ContainerID][]binding
+	owners       map[ContainerID]*dockerDaemon
+}
+
+var table = routeTable{
+	containers: make(map[ContainerID][]binding),
+	owners:     make(map[ContainerID]*dockerDaemon),
+}
+
+// The route serving requests whose host matches no other: SUB2PORT_DEFAULT, or a container's SUB2PORT=*
+var fallbackHost = cmp.Or(normalizeRoute(os.Getenv("SUB2PORT_DEFAULT")), "*")
+
+func (table *routeTable) lookup(host HostName) *hostEntry {
+	if entry, ok := table.hosts.Load(host); ok {
+		return entry.(*hostEntry)
+	}
+	return nil
+}
+
+// Get or create a host's entry while holding the table lock
+func (table *routeTable) entry(host HostName) *hostEntry {
+	if entry := table.lookup(host); entry != nil {
+		return entry
+	}
+	entry := &hostEntry{}
+	entry.pool.Store(&hostPool{})
+	table.hosts.Store(host, entry)
+	if port, ok := tcpPort(host); ok {
+		listenTCP(host, port)
+	}
+	if domain, prefix := splitRoute(host); prefix != "" {
+		prefixes, _ := table.paths.Load(domain)
+		paths, _ := prefixes.([]string)
+		paths = append(slices.Clone(paths), prefix)
+		slices.SortFunc(paths, func(a, b string) int { return len(b) - len(a) })
+		table.paths.Store(domain, paths)
+	}
+	return entry
+}
+
+// Remove a host's entry while holding the table lock
+func (table *routeTable) remove(host HostName) {
+	table.hosts.Delete(host)
+	if _, ok := tcpPort(host); ok {
+		closeTCP(host)
+	}
+	if domain, prefix := splitRoute(host); prefix != "" {
+		prefixes, _ := table.paths.Load(domain)
+		paths := slices.DeleteFunc(slices.Clone(prefixes.([]string)), func(path string) bool { return path == prefix })
+		if len(paths) == 0 {
+			table.paths.Delete(domain)
+		} else {
+			table.paths.Store(domain, paths)
+		}
+	}
+}
+
+// The route of a request: its host or wildcard, or that and the longest path prefix routed on it
+func (table *routeTable) match(host HostName, path string) HostName {
+	host = table.wildcard(host)
+	prefixes, ok := table.paths.Load(host)
+	if !ok {
+		return host
+	}
+	for _, prefix := range prefixes.([]string) {
+		if rest, ok := strings.CutPrefix(path, prefix); ok && (rest == "" || rest[0] == '/') {
+			return host + HostName(prefix)
+		}
+	}
+	return host
+}
+
+// Split a route, e.g. "app.test/api", into its host name and path prefix
+func splitRoute(route HostName) (HostName, string) {
+	if index := strings.IndexByte(string(route), '/'); index >= 0 {
+		return route[:index], string(route[index:])
+	}
+	return route, ""
+}
+
+// Parse a container's route config
+func addRoutes(daemon *dockerDaemon, containerID ContainerID) {
+	dropRoutes(containerID)
+
+	container, err := daemon.inspect(containerID)
+	if err != nil {
+		log.Printf("inspect %s: %v", containerID[:12], err)
+		return
+	}
+
+	if !container.State.Running {
+		return
+	}
+
+	name := ContainerName(strings.TrimPrefix(container.Name, "/"))
+	vars := containerVars(daemon, containerID, name, container.Config.Env, container.Config.Labels)
+	// The proxy's own SUB2PORT* vars are its settings, not route options.
+	if len(vars) > 0 && !isSelf(containerID) {
+		lintContainer(containerID, name, vars)
+	}
+	config := vars["SUB2PORT"]
+	if config == "" && vars["SUB2PORT_TCP"] == "" && vars["SUB2PORT_PASSTHROUGH"] == "" {
+		return
+	}
+
+	network := container.NetworkSettings.Networks[networkName]
+	if network.IPAddress == "" && daemon.Connect {
+		ip, err := daemon.connect(containerID)
+		if err != nil {
+			log.Printf("%s: connect to network %q: %v", name, networkName, err)
+		} else {
+			log.Printf("# connected %s to network %q", name, networkName)
+			network.IPAddress = ip
+		}
+	}
+	// Ignore containers in other networks, unless their ports are published on a reachable host.
+	if network.IPAddress == "" && daemon.Addr == "" {
+		return
+	}
+	options := parseOptions(name, vars)
+
+	defaultPort := "80"
+	for _port := range container.Config.ExposedPorts {
+		defaultPort = strings.Split(_port, "/")[0] // "8080/tcp" -> "8080"
+		break
+	}
+	if port := strings.TrimSpace(vars["SUB2PORT_PORT"]); port != "" {
+		defaultPort = port
+	}
+
+	// Containers with a health check serve once it passes, unless they opt out.
+	health := "healthy"
+	if container.State.Health != nil && strings.TrimSpace(vars["SUB2PORT_WAIT_HEALTHY"]) != "false" {
+		health = container.State.Health.Status
+	}
+
+	weight := parseWeight(name, vars["SUB2PORT_WEIGHT"])
+	if weight == 0 && limitWeights {
+		weight = daemon.limitWeight(&container)
+	}
+
+	node, placed := daemon.placed(name, options.Nodes)
+
+	logged := recordFlap(daemon, containerID, name)
+	isolated := quarantined(name)
+	held := isolated || health != "healthy" || !placed
+	var bindings []binding
+	status := containerStatus{State: "routed"}
+	if isolated {
+		status.State = "quarantined"
+	} else if !placed {
+		status.State = "unplaced"
+		if logged {
+			log.Printf("# %s is on node %q, which doesn't match SUB2PORT_NODES, holding its routes", name, node)
+		}
+	} else if held {
+		status.State = health
+		if logged {
+			log.Printf("# %s is %s, holding its routes until it is healthy", name, health)
+		}
+	}
+	var entries []routeEntry
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// e.g. "app.test/api:8080->/" strips /api before forwarding
+		entry, rewrite, rewritten := strings.Cut(entry, "->")
+		hostName, port := parseHostEntry(strings.TrimSpace(entry), defaultPort)
+		hostName, tenant := splitTenant(hostName)
+		if rewritten {
+			rewrite = cmp.Or(strings.TrimSpace(rewrite), "/")
+		}
+		entries = append(entries, routeEntry{host: hostName, port: port, rewrite: rewrite, tenant: tenant})
+	}
+	if tcp, err := parseTCPEntries(vars["SUB2PORT_TCP"]); err != nil {
+		log.Printf("%s: SUB2PORT_TCP: %v", name, err)
+	} else {
+		entries = append(entries, tcp...)
+	}
+	if passthrough, err := parsePassthrough(vars["SUB2PORT_PASSTHROUGH"]); err != nil {
+		log.Printf("%s: SUB2PORT_PASSTHROUGH: %v", name, err)
+	} else {
+		entries = append(entries, passthrough...)
+	}
+
+	table.Lock()
+	for _, entry := range entries {
+		hostName, port := entry.host, entry.port
+		route := route{Name: name, ID: containerID, Image: container.Config.Image, Project: container.Config.Labels["com.docker.compose.project"], Host: network.IPAddress, Port: port, Node: node, Options: options, Weight: weight, Rewrite: entry.rewrite, Tenant: entry.tenant, State: status.State}
+		via := ""
+		if route.Host == "" {
+			route.Host, route.Port = daemon.Addr, container.publishedPort(port)
+			if route.Port == "" {
+				log.Printf("%s: not on network %q and port %s is not published on %s", name, networkName, port, daemon)
+				continue
+			}
+			log.Printf("%s: not on network %q, falling back to published port %s:%s", name, networkName, route.Host, route.Port)
+			via = fmt.Sprintf(" via %s:%s", route.Host, route.Port)
+		}
+		bindings = append(bindings, binding{Domain: hostName, Name: name})
+		status.Routes = append(status.Routes, hostStat{Host: hostName, Backend: net.JoinHostPort(route.Host, route.Port)})
+		count := bindRoute(hostName, route, held)
+		if logged && !held {
+			logRoute("+", hostName, count, name, port, via)
+		}
+	}
+	table.containers[containerID] = bindings
+	table.owners[containerID] = daemon
+	table.Unlock()
+	if len(bindings) > 0 {
+		go writeStatus(daemon, containerID, status)
+	}
+}
+
+// A route a container declares, before it is bound
+type routeEntry struct {
+	host    HostName
+	port    string // the container port
+	rewrite string
+	tenant  string
+}
+
+// Split a SUB2PORT entry, host[/path] or host[/path]:port, into its route and container port
+func parseHostEntry(entry, defaultPort string) (HostName, string) {
+	if host, port, err := net.SplitHostPort(entry); err == nil && port != "" {
+		return normalizeRoute(host), port
+	}
+	return normalizeRoute(entry), defaultPort
+}
+
+// A normalized host name, followed by its path prefix without a trailing slash
+func normalizeRoute(route string) HostName {
+	host, path, _ := strings.Cut(route, "/")
+	if path = strings.TrimRight(path, "/"); path != "" {
+		return normalizeHost(host) + HostName("/"+path)
+	}
+	return normalizeHost(host)
+}
+
+// Add a backend, or a held route, to a host while holding the table lock
+func bindRoute(host HostName, route route, held bool) int {
+	if route.inflight == nil {
+		route.inflight = new(inflight)
+	}
+	if route.retired == nil {
+		route.retired = new(atomic.Bool)
+	}
+	if route.served == nil {
+		route.served = new(atomic.Uint64)
+	}
+	if route.limiter == nil && route.Options != nil && route.Options.Concurrency != nil {
+		route.limiter = newAIMDLimiter(*route.Options.Concurrency)
+	}
+	entry := table.entry(host)
+	pool := entry.pool.Load().clone()
+	if held {
+		pool.held = append(pool.held, route)
+	} else {
+		pool.backends = append(pool.backends, route)
+	}
+	entry.pool.Store(pool)
+	return len(pool.backends)
+}
+
+// Parse the SUB2PORT_<OPTION> env vars shared by all of a container's hosts
+func parseOptions(name ContainerName, vars map[string]string) *hostOptions {
+	options := &hostOptions{Vars: make(map[string]string)}
+	for key, value := range vars {
+		if strings.HasPrefix(key, "SUB2PORT_") {
+			options.Vars[key] = value
+		}
+	}
+	for _, method := range strings.Split(vars["SUB2PORT_METHODS"], ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			options.Methods = append(options.Methods, method)
+		}
+	}
+	switch slashes := strings.TrimSpace(vars["SUB2PORT_SLASHES"]); slashes {
+	case "", "add", "strip":
+		options.Slashes = slashes
+	default:
+		log.Printf("%s: SUB2PORT_SLASHES: expected add or strip, got %q", name, slashes)
+	}
+	options.MergeSlashes = strings.TrimSpace(vars["SUB2PORT_MERGE_SLASHES"]) == "true"
+	options.Favicon = strings.TrimSpace(vars["SUB2PORT_FAVICON"]) == "true"
+	for _, ping := range strings.Split(vars["SUB2PORT_PING"], ",") {
+		if ping = strings.TrimSpace(ping); ping != "" {
+			options.Pings = append(options.Pings, ping)
+		}
+	}
+	options.CalDAV = strings.TrimSpace(vars["SUB2PORT_CALDAV"])
+	options.CardDAV = strings.TrimSpace(vars["SUB2PORT_CARDDAV"])
+	if page := vars["SUB2PORT_ERROR_PAGE"]; page != "" {
+		tmpl, err := template.New("error").Parse(page)
+		if err != nil {
+			log.Printf("%s: SUB2PORT_ERROR_PAGE: %v", name, err)
+		} else {
+			options.ErrorPage = tmpl
+		}
+	}
+	for _, lang := range strings.Split(vars["SUB2PORT_LANG"], ",") {
+		if lang = strings.ToLower(strings.TrimSpace(lang)); lang != "" {
+			options.Langs = append(options.Langs, lang)
+		}
+	}
+	options.Brand = strings.TrimSpace(vars["SUB2PORT_BRAND"])
+	for _, tenant := range strings.Split(vars["SUB2PORT_TENANTS"], ",") {
+		if tenant = strings.ToLower(strings.TrimSpace(tenant)); tenant != "" {
+			options.Tenants = append(options.Tenants, tenant)
+		}
+	}
+	if balance := strings.TrimSpace(vars["SUB2PORT_BALANCE"]); balance != "" {
+		options.Balance = balancers[balance]
+		if options.Balance == nil {
+			log.Printf("%s: SUB2PORT_BALANCE: expected round-robin, least-conn, random, or ip-hash, got %q", name, balance)
+		}
+	}
+	switch sticky := strings.TrimSpace(vars["SUB2PORT_STICKY"]); sticky {
+	case "":
+	case "cookie":
+		options.Sticky = true
+	default:
+		log.Printf("%s: SUB2PORT_STICKY: expected cookie, got %q", name, sticky)
+	}
+	if hedge := strings.TrimSpace(vars["SUB2PORT_HEDGE"]); hedge != "" {
+		delay, err := time.ParseDuration(hedge)
+		if err != nil {
+			log.Printf("%s: SUB2PORT_HEDGE: %v", name, err)
+		}
+		options.Hedge = delay
+	}
+	options.Retries = 1
+	if retries := strings.TrimSpace(vars["SUB2PORT_RETRIES"]); retries != "" {
+		count, err := strconv.Atoi(retries)
+		if err != nil || count < 0 {
+			log.Printf("%s: SUB2PORT_RETRIES: expected a count, got %q", name, retries)
+		} else {
+			options.Retries = count
+		}
+	}
+	switch buffer := strings.TrimSpace(vars["SUB2PORT_BUFFER"]); buffer {
+	case "", "request", "stream":
+		options.Buffer = buffer
+	default:
+		log.Printf("%s: SUB2PORT_BUFFER: expected request or stream, got %q", name, buffer)
+	}
+	if timeout := strings.TrimSpace(vars["SUB2PORT_TIMEOUT"]); timeout != "" {
+		budget, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Printf("%s: SUB2PORT_TIMEOUT: %v", name, err)
+		}
+		options.Timeout = budget
+	}
+	switch redirect := strings.TrimSpace(vars["SUB2PORT_HTTPS_REDIRECT"]); redirect {
+	case "", "false", "0":
+	case "true", "1", "301":
+		options.HTTPSRedirect = http.StatusMovedPermanently
+	case "308":
+		options.HTTPSRedirect = http.StatusPermanentRedirect
+	default:
+		log.Printf("%s: SUB2PORT_HTTPS_REDIRECT: expected true, false, 301, or 308, got %q", name, redirect)
+	}
+	switch readOnly := strings.TrimSpace(vars["SUB2PORT_READ_ONLY"]); readOnly {
+	case "", "false", "0":
+	case "true", "1", "503":
+		options.ReadOnly = http.StatusServiceUnavailable
+	case "405":
+		options.ReadOnly = http.StatusMethodNotAllowed
+	default:
+		log.Printf("%s: SUB2PORT_READ_ONLY: expected true, false, 405, or 503, got %q", name, readOnly)
+	}
+	if schedule, err := parseSchedule(vars["SUB2PORT_SCHEDULE"]); err != nil {
+		log.Printf("%s: SUB2PORT_SCHEDULE: %v", name, err)
+	} else {
+		options.Schedule = schedule
+	}
+	options.MergeHeaders = parseHeaderNames(vars["SUB2PORT_MERGE_HEADERS"])
+	options.DedupeHeaders = parseHeaderNames(vars["SUB2PORT_DEDUPE_HEADERS"])
+	for key, rules := range map[string]*[]headerRule{"SUB2PORT_REQUEST_HEADERS": &options.RequestHeaders, "SUB2PORT_RESPONSE_HEADERS": &options.ResponseHeaders} {
+		var err error
+		if *rules, err = parseHeaderRules(vars[key]); err != nil {
+			log.Printf("%s: %s: %v", name, key, err)
+		}
+	}
+	if limit := strings.TrimSpace(vars["SUB2PORT_COOKIE_LIMIT"]); limit != "" {
+		size, err := strconv.Atoi(limit)
+		if err != nil || size < 0 {
+			log.Printf("%s: SUB2PORT_COOKIE_LIMIT: expected a size in bytes, got %q", name, limit)
+		} else {
+			options.CookieLimit = size
+		}
+	}
+	options.Compress = parseCompress(vars["SUB2PORT_COMPRESS"])
+	options.CompressMin = 1024
+	if size := strings.TrimSpace(vars["SUB2PORT_COMPRESS_MIN"]); size != "" {
+		if n, err := strconv.Atoi(size); err != nil || n < 0 {
+			log.Printf("%s: SUB2PORT_COMPRESS_MIN: expected a size in bytes, got %q", name, size)
+		} else {
+			options.CompressMin = n
+		}
+	}
+	if users, err := parseAuth(vars["SUB2PORT_AUTH"]); err != nil {
+		log.Printf("%s: SUB2PORT_AUTH: %v", name, err)
+		// Fail closed rather than exposing the host.
+		options.Auth = map[string]*bcryptHash{}
+	} else {
+		options.Auth = users
+	}
+	if auth, err := parseForwardAuth(vars); err != nil {
+		log.Printf("%s: SUB2PORT_FORWARD_AUTH: %v", name, err)
+		options.ForwardAuth = &forwardAuth{}
+	} else {
+		options.ForwardAuth = auth
+	}
+	if access, err := parseIPAccess(vars); err != nil {
+		// Keep everyone out rather than open the host to the whole network.
+		log.Printf("%s: %v", name, err)
+		options.IPAccess = &ipAccess{deny: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}}
+	} else {
+		options.IPAccess = access
+	}
+	if limit, err := parseRateLimit(vars["SUB2PORT_RATELIMIT"]); err != nil {
+		log.Printf("%s: SUB2PORT_RATELIMIT: %v", name, err)
+	} else {
+		options.RateLimit = limit
+	}
+	if limit, err := parseConcurrency(vars["SUB2PORT_CONCURRENCY"]); err != nil {
+		log.Printf("%s: SUB2PORT_CONCURRENCY: %v", name, err)
+	} else {
+		options.Concurrency = limit
+	}
+	if nodes, err := parseNodeConstraints(vars["SUB2PORT_NODES"]); err != nil {
+		log.Printf("%s: SUB2PORT_NODES: %v", name, err)
+	} else {
+		options.Nodes = nodes
+	}
+	if match, err := parseHeaderMatch(vars["SUB2PORT_MATCH"]); err != nil {
+		log.Printf("%s: SUB2PORT_MATCH: %v", name, err)
+	} else {
+		options.Match = match
+	}
+	if intercepts, err := parseIntercepts(vars["SUB2PORT_INTERCEPT"]); err != nil {
+		log.Printf("%s: SUB2PORT_INTERCEPT: %v", name, err)
+	} else if len(intercepts) > 0 {
+		options.Intercept = intercepts
+	}
+	if sorry, err := newSorryServer(vars); err != nil {
+		log.Printf("%s: %v", name, err)
+	} else {
+		options.Sorry = sorry
+	}
+	options.Scheme = "http"
+	if strings.TrimSpace(vars["SUB2PORT_SCHEME"]) == "https" {
+		options.Scheme = "https"
+		transport, err := backendTransport(name, vars)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+		options.Transport = transport
+	}
+	switch only := strings.TrimSpace(vars["SUB2PORT_HTTP1_ONLY"]); only {
+	case "", "false":
+	case "true":
+		options.HTTP1Clients, options.HTTP1Backends = true, true
+	case "clients":
+		options.HTTP1Clients = true
+	case "backends":
+		options.HTTP1Backends = true
+	default:
+		log.Printf("%s: SUB2PORT_HTTP1_ONLY: expected true, false, clients, or backends, got %q", name, only)
+	}
+	options.EarlyHints = strings.TrimSpace(vars["SUB2PORT_EARLY_HINTS"]) == "true"
+	if options.HTTP1Backends && options.Transport != nil {
+		options.Transport.Protocols = new(http.Protocols)
+		options.Transport.Protocols.SetHTTP1(true)
+	}
+	return options
+}
+
+// Remove a stopped container's routes, holding them while it is quarantined
+func removeRoutes(containerID ContainerID) {
+	lintReports.Delete(containerID)
+	table.Lock()
+	defer table.Unlock()
+	bindings := table.containers[containerID]
+	if len(bindings) == 0 {
+		return
+	}
+	owner := table.owners[containerID]
+	logged := recordFlap(owner, containerID, bindings[0].Name)
+	held := quarantined(bindings[0].Name)
+	unbindRoutes(containerID, held, logged)
+	if _, joined := owner.joined.LoadAndDelete(containerID); joined && !held {
+		go owner.disconnect(containerID)
+	}
+}
+
+// Remove a container's routes, including held ones
+func dropRoutes(containerID ContainerID) {
+	table.Lock()
+	defer table.Unlock()
+	unbindRoutes(containerID, false, true)
+}
+
+func unbindRoutes(containerID ContainerID, hold, logged bool) {
+	for _, binding := range table.containers[containerID] {
+		entry := table.lookup(binding.Domain)
+		if entry == nil {
+			continue
+		}
+		pool := entry.pool.Load().clone()
+		var removed *atomic.Bool
+		for i, route := range pool.backends {
+			if route.Name == binding.Name {
+				removed = route.retired
+				if logged {
+					logRoute("-", binding.Domain, len(pool.backends)-1, route.Name, route.Port, "")
+				}
+				pool.backends = slices.Delete(pool.backends, i, i+1)
+				if hold {
+					route.State = "quarantined"
+					pool.held = append(pool.held, route)
+				}
+				break
+			}
+		}
+		if !hold {
+			pool.held = slices.DeleteFunc(pool.held, func(route route) bool {
+				return route.Name == binding.Name
+			})
+		}
+		entry.pool.Store(pool)
+		// Retire the route after the pool without it is stored, so requests
+		// that picked it pick again from a pool it is no longer in.
+		if removed != nil {
+			removed.Store(true)
+		}
+		if len(pool.backends) == 0 && len(pool.held) == 0 {
+			table.remove(binding.Domain)
+		}
+	}
+	if !hold {
+		delete(table.containers, containerID)
+		delete(table.owners, containerID)
+	}
+}
@@ -0,0 +1,245 @@
+// The image builds as a module, so the admin API's method and wildcard
+// patterns need the current ServeMux outside one too.
+//go:debug httpmuxgo121=0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Fill the table with hosts of two backends each
+func fillTable(b testing.TB, hosts int) []HostName {
+	b.Helper()
+	names := make([]HostName, hosts)
+	table.Lock()
+	defer table.Unlock()
+	for i := range names {
+		names[i] = HostName(fmt.Sprintf("app%d.test", i))
+		for replica := range 2 {
+			name := ContainerName(fmt.Sprintf("app%d-%d", i, replica))
+			bindRoute(names[i], route{Name: name, Host: "10.0.0.1", Port: "80", Options: &hostOptions{}}, false)
+			table.containers[ContainerID(name)] = []binding{{Domain: names[i], Name: name}}
+		}
+	}
+	b.Cleanup(func() {
+		table.Lock()
+		defer table.Unlock()
+		for containerID := range table.containers {
+			unbindRoutes(containerID, false, false)
+		}
+	})
+	return names
+}
+
+// Route a host to a test server until the test ends, as a backend named after
+// the host's first label and numbered, e.g. app-1. Options without a scheme
+// speak plain http to it.
+func routeTo(t testing.TB, host HostName, server *httptest.Server, options *hostOptions) ContainerName {
+	t.Helper()
+	if options == nil {
+		options = &hostOptions{}
+	}
+	if options.Scheme == "" {
+		options.Scheme = "http"
+	}
+	address, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	table.Lock()
+	defer table.Unlock()
+	backends := 0
+	if entry := table.lookup(host); entry != nil {
+		pool := entry.pool.Load()
+		backends = len(pool.backends) + len(pool.held)
+	}
+	label, _, _ := strings.Cut(string(host), ".")
+	name := ContainerName(fmt.Sprintf("%s-%d", label, backends+1))
+	bindRoute(host, route{Name: name, Host: address, Port: port, Options: options}, false)
+	table.containers[ContainerID(name)] = []binding{{Domain: host, Name: name}}
+	t.Cleanup(func() { dropRoutes(ContainerID(name)) })
+	return name
+}
+
+// Attach the state proxy gives a request once it matched route
+func matchedTo(request *http.Request, route HostName, options *hostOptions) *http.Request {
+	state := &proxyState{host: route, path: request.URL.Path, url: clientURL(request), options: options, recorder: &accessRecorder{ResponseWriter: httptest.NewRecorder()}}
+	return request.WithContext(context.WithValue(request.Context(), proxyStateKey{}, state))
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, hosts := range []int{10, 10000} {
+		b.Run(fmt.Sprint(hosts), func(b *testing.B) {
+			names := fillTable(b, hosts)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					entry := table.lookup(names[i%len(names)])
+					pool := entry.pool.Load()
+					_ = pool.backends[entry.counter.Add(1)%uint64(len(pool.backends))]
+					i++
+				}
+			})
+		})
+	}
+}
+
+// Lookups while another goroutine churns routes
+func BenchmarkLookupDuringChurn(b *testing.B) {
+	names := fillTable(b, 10000)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		churn := route{Name: "churn", Host: "10.0.0.2", Port: "80", Options: &hostOptions{}}
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			host := names[i%len(names)]
+			table.Lock()
+			bindRoute(host, churn, false)
+			table.containers["churn"] = []binding{{Domain: host, Name: "churn"}}
+			unbindRoutes("churn", false, false)
+			table.Unlock()
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if entry := table.lookup(names[i%len(names)]); entry != nil {
+				_ = entry.pool.Load().backends
+			}
+			i++
+		}
+	})
+}
+
+// The full handler for a host with no backends
+func BenchmarkProxyMiss(b *testing.B) {
+	fillTable(b, 10000)
+	request := httptest.NewRequest(http.MethodGet, "http://missing.test/", nil)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			proxy(httptest.NewRecorder(), request)
+		}
+	})
+}
+
+// The full handler for a routed host, forwarding to a local backend
+func BenchmarkProxyHit(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer backend.Close()
+	fillTable(b, 10000)
+	routeTo(b, "hit.test", backend, nil)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			proxy(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://hit.test/", nil))
+		}
+	})
+}
+
+// A backend removed after it was picked isn't sent the request
+func TestRemovedBackendNotDialed(t *testing.T) {
+	served := make(map[ContainerName]*atomic.Int64)
+	options := &hostOptions{Retries: 1}
+	for range 2 {
+		count := new(atomic.Int64)
+		server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { count.Add(1) }))
+		t.Cleanup(server.Close)
+		served[routeTo(t, "removed.test", server, options)] = count
+	}
+
+	pool := table.lookup("removed.test").pool.Load()
+	index := uint64(slices.IndexFunc(pool.backends, func(route route) bool { return route.Name == "removed-1" }))
+	picked := pool.backends[index]
+	picked.inflight.Add(1)
+	dropRoutes("removed-1")
+
+	request := httptest.NewRequest(http.MethodGet, "http://"+picked.Host+":"+picked.Port+"/", nil)
+	request.RequestURI = ""
+	state := &proxyState{host: "removed.test", pool: pool, index: index, backend: picked, options: picked.Options, recorder: &accessRecorder{ResponseWriter: httptest.NewRecorder()}}
+	request = request.WithContext(context.WithValue(request.Context(), proxyStateKey{}, state))
+	response, err := stateTransport{}.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if first, second := served["removed-1"].Load(), served["removed-2"].Load(); first != 0 || second != 1 {
+		t.Fatalf("expected the request on removed-2 only, got %d on removed-1 and %d on removed-2", first, second)
+	}
+	if state.backend.Name != "removed-2" || picked.inflight.Load() != 0 || state.backend.inflight.Load() != 1 {
+		t.Fatalf("expected the request counted on removed-2, got %s", state.backend.Name)
+	}
+}
+
+// Requests go to the longest path prefix routed on their host
+func TestPathRouting(t *testing.T) {
+	table.Lock()
+	for _, key := range []HostName{"paths.test", "paths.test/api", "paths.test/api/v2"} {
+		name := ContainerName(strings.ReplaceAll(string(key), "/", "_"))
+		bindRoute(key, route{Name: name, Host: "10.0.0.1", Port: "80", Options: &hostOptions{}}, false)
+		table.containers[ContainerID(name)] = []binding{{Domain: key, Name: name}}
+	}
+	table.Unlock()
+	t.Cleanup(func() {
+		table.Lock()
+		defer table.Unlock()
+		for _, name := range []ContainerID{"paths.test", "paths.test_api", "paths.test_api_v2"} {
+			unbindRoutes(name, false, false)
+		}
+	})
+
+	for path, want := range map[string]HostName{
+		"/":            "paths.test",
+		"/apis":        "paths.test",
+		"/api":         "paths.test/api",
+		"/api/users":   "paths.test/api",
+		"/api/v2/":     "paths.test/api/v2",
+		"/api/v2/user": "paths.test/api/v2",
+	} {
+		if got := table.match("paths.test", path); got != want {
+			t.Errorf("expected %s to route to %s, got %s", path, want, got)
+		}
+	}
+	table.Lock()
+	unbindRoutes("paths.test_api", false, false)
+	table.Unlock()
+	if got := table.match("paths.test", "/api/users"); got != "paths.test" {
+		t.Errorf("expected the removed prefix to fall back to the host, got %s", got)
+	}
+}
+
+// Hosts without a route are served by a container's SUB2PORT=*
+func TestFallbackRoute(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprintf(writer, "landing page for %s", request.Host)
+	}))
+	t.Cleanup(backend.Close)
+
+	request := httptest.NewRequest(http.MethodGet, "http://unknown.test/", nil)
+	recorder := httptest.NewRecorder()
+	proxy(recorder, request)
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 without a fallback, got %d", recorder.Code)
+	}
+
+	host, _ := parseHostEntry("*", "80")
+	routeTo(t, host, backend, nil)
+	recorder = httptest.NewRecorder()
+	proxy(recorder, request)
+	if recorder.Body.String() != "landing page for unknown.test" {
+		t.Fatalf("expected the fallback's landing page, got %d %q", recorder.Code, recorder.Body)
+	}
+}
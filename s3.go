@@ -0,0 +1,121 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3 objects, fetched with AWS Signature Version 4 instead of an SDK
+
+// An object named by s3://<bucket>/<key>, with the credentials of the AWS_* environment
+type s3Object struct {
+	url    string // virtual-hosted, or path-style under AWS_ENDPOINT_URL_S3
+	region string
+	keyID  string // empty for anonymous requests
+	secret string
+	token  string // of temporary credentials
+}
+
+func parseS3Object(value string) (*s3Object, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(value, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return nil, errors.New("expected s3://<bucket>/<key>")
+	}
+	object := &s3Object{
+		region: cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		keyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	path := awsEscapePath(key)
+	if endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")); endpoint != "" {
+		// S3-compatible stores like MinIO serve buckets under their path.
+		object.url = strings.TrimSuffix(endpoint, "/") + "/" + awsEscapePath(bucket) + "/" + path
+	} else if strings.Contains(bucket, ".") {
+		// Dotted bucket names don't match the wildcard certificate of their virtual host.
+		object.url = "https://s3." + object.region + ".amazonaws.com/" + bucket + "/" + path
+	} else {
+		object.url = "https://" + bucket + ".s3." + object.region + ".amazonaws.com/" + path
+	}
+	if _, err := url.Parse(object.url); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// Sign a request for the object once its headers are set
+func (object *s3Object) sign(request *http.Request, now time.Time) {
+	if object.keyID == "" {
+		return
+	}
+	if object.token != "" {
+		request.Header.Set("X-Amz-Security-Token", object.token)
+	}
+	signV4(request, object.region, "s3", object.keyID, object.secret, now)
+}
+
+// The SHA-256 of an empty body, which a GET sends
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Add an AWS Signature Version 4 Authorization header covering the request's headers
+func signV4(request *http.Request, region, service, keyID, secret string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	request.Header.Set("X-Amz-Date", stamp)
+	request.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonical strings.Builder
+	canonical.WriteString(request.Method + "\n/" + awsEscapePath(strings.TrimPrefix(request.URL.Path, "/")) + "\n")
+	canonical.WriteString(strings.ReplaceAll(request.URL.Query().Encode(), "+", "%20") + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + emptySHA256)
+
+	scope := stamp[:8] + "/" + region + "/" + service + "/aws4_request"
+	digest := sha256.Sum256([]byte(canonical.String()))
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{stamp[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, "AWS4-HMAC-SHA256\n"+stamp+"\n"+scope+"\n"+hex.EncodeToString(digest[:])))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keyID+"/"+scope+", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Escape a key as SigV4 canonicalizes paths, every byte but unreserved ones and slashes
+func awsEscapePath(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', strings.IndexByte("-._~/", b) >= 0:
+			escaped.WriteByte(b)
+		default:
+			escaped.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return escaped.String()
+}
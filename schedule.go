@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // schedules follow TZ without tzdata in the image
+)
+
+// Scheduled route toggles
+
+// A cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bitsets of allowed values
+	anyDOM, anyDOW                bool
+}
+
+// Turn a host on or off when the schedule matches
+type scheduleToggle struct {
+	On   bool
+	When cronSchedule
+}
+
+var cronFields = []struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+func parseCron(expression string) (cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, fmt.Errorf("expected 5 fields in %q", expression)
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		for _, part := range strings.Split(field, ",") {
+			span, step := part, 1
+			if before, after, ok := strings.Cut(part, "/"); ok {
+				span = before
+				var err error
+				if step, err = strconv.Atoi(after); err != nil || step < 1 {
+					return cronSchedule{}, fmt.Errorf("bad step in %q", part)
+				}
+			}
+			low, high := cronFields[i].min, cronFields[i].max
+			if span != "*" {
+				before, after, isRange := strings.Cut(span, "-")
+				var err error
+				if low, err = strconv.Atoi(before); err != nil {
+					return cronSchedule{}, fmt.Errorf("bad value in %q", part)
+				}
+				high = low
+				if isRange {
+					if high, err = strconv.Atoi(after); err != nil {
+						return cronSchedule{}, fmt.Errorf("bad range in %q", part)
+					}
+				} else if step > 1 {
+					high = cronFields[i].max
+				}
+			}
+			if low < cronFields[i].min || high > cronFields[i].max || low > high {
+				return cronSchedule{}, fmt.Errorf("%q is out of range", part)
+			}
+			for value := low; value <= high; value += step {
+				sets[i] |= 1 << value
+			}
+		}
+	}
+	return cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		anyDOM: fields[2] == "*", anyDOW: fields[4] == "*",
+	}, nil
+}
+
+func (cron cronSchedule) matches(t time.Time) bool {
+	if cron.minute&(1<<t.Minute()) == 0 || cron.hour&(1<<t.Hour()) == 0 || cron.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := cron.dom&(1<<t.Day()) != 0, cron.dow&(1<<int(t.Weekday())) != 0
+	// Like cron, either day field matches when both are restricted.
+	if !cron.anyDOM && !cron.anyDOW {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Parse "on|off <cron>;..." toggles
+func parseSchedule(value string) ([]scheduleToggle, error) {
+	var toggles []scheduleToggle
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		action, expression, _ := strings.Cut(entry, " ")
+		if action != "on" && action != "off" {
+			return nil, fmt.Errorf("expected on or off, got %q", action)
+		}
+		when, err := parseCron(expression)
+		if err != nil {
+			return nil, err
+		}
+		toggles = append(toggles, scheduleToggle{On: action == "on", When: when})
+	}
+	return toggles, nil
+}
+
+// The state set by the latest matching toggle within a week, on by default
+func scheduledOn(toggles []scheduleToggle, now time.Time) bool {
+	minute := now.Truncate(time.Minute)
+	for range 7 * 24 * 60 {
+		for _, toggle := range toggles {
+			if toggle.When.matches(minute) {
+				return toggle.On
+			}
+		}
+		minute = minute.Add(-time.Minute)
+	}
+	return true
+}
+
+// Hosts switched off by their schedule, read by every request of a scheduled host
+var scheduledOff sync.Map // HostName -> bool, true while off
+
+// Apply toggles every minute
+func watchSchedules() {
+	for now := time.Now(); ; now = <-time.After(time.Until(now.Truncate(time.Minute).Add(time.Minute))) {
+		seen := make(map[HostName]bool)
+		table.hosts.Range(func(key, value interface{}) bool {
+			host, entry := key.(HostName), value.(*hostEntry)
+			pool := entry.pool.Load()
+			if len(pool.backends) == 0 && len(pool.held) == 0 {
+				return true
+			}
+			toggles := pool.options().Schedule
+			if len(toggles) == 0 {
+				return true
+			}
+			seen[host] = true
+
+			value, known := scheduledOff.Load(host)
+			off, _ := value.(bool)
+			on := !off
+			if !known {
+				on = scheduledOn(toggles, now)
+			} else {
+				for _, toggle := range toggles {
+					if toggle.When.matches(now) {
+						on = toggle.On
+						break
+					}
+				}
+			}
+			if known && on == !off {
+				return true
+			}
+			scheduledOff.Store(host, !on)
+			if !on {
+				log.Printf("# schedule turned %s off", host)
+			} else if known {
+				log.Printf("# schedule turned %s on", host)
+			}
+			return true
+		})
+		scheduledOff.Range(func(key, _ interface{}) bool {
+			if !seen[key.(HostName)] {
+				scheduledOff.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// Answer with the maintenance page while the schedule has the host off
+func filterSchedule(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if len(options.Schedule) == 0 {
+		return false
+	}
+	// Keyed like the route table, by the route the request matched, tenants' wildcard or the fallback.
+	if off, _ := scheduledOff.Load(stateOf(request).host); off != true {
+		return false
+	}
+	renderError(writer, request, options, http.StatusServiceUnavailable, fmt.Sprintf("%s is down for scheduled maintenance", request.Host))
+	return true
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A schedule set on a wildcard route turns off each of its tenants
+func TestScheduleWildcard(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(backend.Close)
+	toggles, err := parseSchedule("off * * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routeTo(t, "*.schedule.test", backend, &hostOptions{Schedule: toggles})
+	scheduledOff.Store(HostName("*.schedule.test"), true)
+	t.Cleanup(func() { scheduledOff.Delete(HostName("*.schedule.test")) })
+
+	recorder := httptest.NewRecorder()
+	proxy(recorder, httptest.NewRequest(http.MethodGet, "http://Acme.schedule.test/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the tenant to be off, got %d", recorder.Code)
+	}
+}
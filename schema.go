@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config schema and linting
+
+type optionSpec struct {
+	Description string   `json:"description"`
+	Enum        []string `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	check       func(string) error
+}
+
+// Every SUB2PORT* env var a container can set
+var optionSchema = map[string]optionSpec{
+	"SUB2PORT": {
+		Description: "Host names to route, as host[/path][:port][->/rewrite], separated by commas",
+		check:       checkHosts,
+	},
+	"SUB2PORT_TCP":                  {Description: "Proxy ports forwarding raw TCP streams, as <port>[-<end>][-><container port>[-<end>]], separated by commas", check: checkTCP},
+	"SUB2PORT_PASSTHROUGH":          {Description: "Host names whose TLS connections on SUB2PORT_SNI are forwarded undecrypted, as host[:port] with port 443 by default, separated by commas", check: checkPassthrough},
+	"SUB2PORT_PORT":                 {Description: "Container port of hosts without one, instead of the first exposed port", check: checkPort},
+	"SUB2PORT_FILE":                 {Description: "Path of a config file in the container, with a host entry or SUB2PORT_<OPTION>=<value> per line"},
+	"SUB2PORT_METHODS":              {Description: "Allowed request methods, separated by commas", Pattern: `^[A-Za-z, ]*$`},
+	"SUB2PORT_SLASHES":              {Description: "Redirect paths to add or strip the trailing slash", Enum: []string{"add", "strip"}},
+	"SUB2PORT_MERGE_SLASHES":        {Description: "Redirect paths with repeated slashes to a single slash", Enum: []string{"true", "false"}},
+	"SUB2PORT_FAVICON":              {Description: "Answer /favicon.ico with 204 at the proxy", Enum: []string{"true", "false"}},
+	"SUB2PORT_PING":                 {Description: "Paths answered with 200 at the proxy, separated by commas", Pattern: `^(/[^,]*)(,\s*/[^,]*)*$`},
+	"SUB2PORT_CALDAV":               {Description: "Redirect target for /.well-known/caldav"},
+	"SUB2PORT_CARDDAV":              {Description: "Redirect target for /.well-known/carddav"},
+	"SUB2PORT_ERROR_PAGE":           {Description: "Go html/template for error pages", check: checkTemplate},
+	"SUB2PORT_LANG":                 {Description: "Languages the error pages are offered in, separated by commas"},
+	"SUB2PORT_BRAND":                {Description: "Name shown on error pages"},
+	"SUB2PORT_BALANCE":              {Description: "How backends are picked", Enum: []string{"round-robin", "least-conn", "random", "ip-hash"}},
+	"SUB2PORT_STICKY":               {Description: "Pin clients to a backend", Enum: []string{"cookie"}},
+	"SUB2PORT_WAIT_HEALTHY":         {Description: "Hold the routes of a container with a health check until it is healthy", Enum: []string{"true", "false"}},
+	"SUB2PORT_HEDGE":                {Description: "Delay before a hedged request is sent to another replica", check: checkDuration},
+	"SUB2PORT_WEIGHT":               {Description: "Share of the host's requests relative to other replicas, which weigh 1 by default", Pattern: `^[0-9]*\.?[0-9]+$`},
+	"SUB2PORT_RETRIES":              {Description: "Other backends tried when one can't be dialed", Pattern: `^[0-9]+$`},
+	"SUB2PORT_COMPRESS":             {Description: "Gzip responses for clients that accept it: true for text, JSON, XML, JavaScript, and SVG, or media type patterns separated by commas", Pattern: `^(true|false|[a-z0-9*.+-]+/[a-z0-9*.+-]+( *, *[a-z0-9*.+-]+/[a-z0-9*.+-]+)*)$`},
+	"SUB2PORT_COMPRESS_MIN":         {Description: "Bytes a response needs before it is compressed", Pattern: `^[0-9]+$`},
+	"SUB2PORT_BUFFER":               {Description: "Buffer request bodies so they can be retried, or stream responses without delay", Enum: []string{"request", "stream"}},
+	"SUB2PORT_TIMEOUT":              {Description: "Time budget of a request, forwarded to the backend in X-Timeout-Ms and X-Request-Deadline", check: checkDuration},
+	"SUB2PORT_HTTPS_REDIRECT":       {Description: "Redirect plain HTTP requests to HTTPS, with 301 or 308 to keep the method", Enum: []string{"true", "false", "0", "1", "301", "308"}},
+	"SUB2PORT_TENANTS":              {Description: "Subdomains of a wildcard host that get their own TLS certificate, separated by commas, e.g. acme,globex"},
+	"SUB2PORT_READ_ONLY":            {Description: "Reject writes with 405 or 503", Enum: []string{"true", "false", "0", "1", "405", "503"}},
+	"SUB2PORT_AUTH":                 {Description: "Users let in with HTTP Basic auth, as <user>:<bcrypt hash>, e.g. from htpasswd -nB, separated by commas", check: checkAuth},
+	"SUB2PORT_FORWARD_AUTH":         {Description: "URL of an auth service asked about every request, which gets in on a 2xx and gets the service's answer otherwise", Pattern: `^https?://`},
+	"SUB2PORT_FORWARD_AUTH_HEADERS": {Description: "Headers of the auth service's 2xx answer forwarded to the backend, separated by commas, e.g. Remote-User,Remote-Email", Pattern: `^[A-Za-z0-9-, ]*$`},
+	"SUB2PORT_FORWARD_AUTH_SIGNIN":  {Description: "Where a 401 from the auth service redirects, {url} in it is the requested page"},
+	"SUB2PORT_ALLOW":                {Description: "Client addresses and CIDR ranges let in, separated by commas, e.g. 10.0.0.0/8,192.168.0.0/16", check: checkPrefixes},
+	"SUB2PORT_DENY":                 {Description: "Client addresses and CIDR ranges kept out with 403, even when allowed", check: checkPrefixes},
+	"SUB2PORT_CONCURRENCY":          {Description: "Limit each backend's requests in flight, adapting to its latency, as \"adaptive [min=<n>] [max=<n>]\"", check: checkConcurrency},
+	"SUB2PORT_RATELIMIT":            {Description: "Requests the host takes before answering 429, as \"<n>r/s|m|h [burst=<n>] [per=ip]\", e.g. 100r/s burst=50", check: checkRateLimit},
+	"SUB2PORT_SCHEDULE":             {Description: "Cron schedule toggling the host, as \"on|off <cron>;...\"", check: checkSchedule},
+	"SUB2PORT_NODES":                {Description: "Docker hosts the container serves from, as node.hostname or node.labels.<key>, == or !=, and a value, separated by commas", check: checkNodes},
+	"SUB2PORT_MATCH":                {Description: "Requests served by the container out of its host's, as \"<header>: <value>[, ...]\", e.g. Accept-Language: de or X-Tenant: acme", check: checkMatch},
+	"SUB2PORT_INTERCEPT":            {Description: "Backend statuses answered at the proxy, as \"<status> page|<url>;...\", {url} in a URL is the requested page", check: checkIntercept},
+	"SUB2PORT_MERGE_HEADERS":        {Description: "Request headers whose repeated lines are joined into one, separated by commas", Pattern: `^[A-Za-z0-9-, ]*$`},
+	"SUB2PORT_REQUEST_HEADERS":      {Description: "Header rules for requests, one per line: \"Name: value\" sets, \"+Name: value\" adds, \"?Name: value\" sets when missing, \"-Name\" removes", check: checkHeaderRules},
+	"SUB2PORT_RESPONSE_HEADERS":     {Description: "Header rules for the backend's responses, e.g. \"?Strict-Transport-Security: max-age=63072000\" or \"-Server\"", check: checkHeaderRules},
+	"SUB2PORT_DEDUPE_HEADERS":       {Description: "Request headers cut to their first line, separated by commas", Pattern: `^[A-Za-z0-9-, ]*$`},
+	"SUB2PORT_COOKIE_LIMIT":         {Description: "Bytes of cookies forwarded, later cookies are dropped", Pattern: `^[0-9]+$`},
+	"SUB2PORT_SORRY":                {Description: "Backup page: true, inline HTML, or the name of a page in the proxy's SUB2PORT_SORRY_DIR"},
+	"SUB2PORT_SORRY_STATUS":         {Description: "Status of the backup page", check: checkStatus},
+	"SUB2PORT_EARLY_HINTS":          {Description: "Answer 103 Early Hints with the preload links of the path's last page while the backend works", Enum: []string{"true", "false"}},
+	"SUB2PORT_HTTP1_ONLY":           {Description: "Speak only HTTP/1.1 with clients, backends, or both, for peers with HTTP/2 bugs", Enum: []string{"true", "false", "clients", "backends"}},
+	"SUB2PORT_SCHEME":               {Description: "Scheme the backend speaks", Enum: []string{"http", "https"}},
+	"SUB2PORT_TLS_CA":               {Description: "PEM bundle, inline or a path in the proxy container, trusted for the backend"},
+	"SUB2PORT_TLS_PINS":             {Description: "Base64 SHA-256 SPKI pins of the backend certificate, separated by commas"},
+	"SUB2PORT_TLS_SERVER_NAME":      {Description: "Server name verified on the backend certificate"},
+	"SUB2PORT_TLS_INSECURE":         {Description: "Skip backend certificate verification", Enum: []string{"true", "false", "0", "1"}},
+}
+
+func (spec optionSpec) MarshalJSON() ([]byte, error) {
+	type plain optionSpec
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		plain
+	}{"string", plain(spec)})
+}
+
+func checkHosts(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		entry, rewrite, rewritten := strings.Cut(entry, "->")
+		if rewrite = strings.TrimSpace(rewrite); rewritten && rewrite != "" && !strings.HasPrefix(rewrite, "/") {
+			return fmt.Errorf("bad rewrite %q, expected a path", rewrite)
+		}
+		entry = strings.TrimSpace(entry)
+		domain, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			domain, port = entry, ""
+		}
+		domain, _, _ = strings.Cut(domain, "/")
+		if domain == "" || strings.Contains(domain, " ") {
+			return fmt.Errorf("bad host %q", entry)
+		}
+		if number, err := strconv.Atoi(port); port != "" && (err != nil || number < 1 || number > 65535) {
+			return fmt.Errorf("bad port in %q", entry)
+		}
+	}
+	return nil
+}
+
+func checkTCP(value string) error {
+	_, err := parseTCPEntries(value)
+	return err
+}
+
+func checkPassthrough(value string) error {
+	_, err := parsePassthrough(value)
+	return err
+}
+
+func checkPort(value string) error {
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || number < 1 || number > 65535 {
+		return errors.New("expected a port between 1 and 65535")
+	}
+	return nil
+}
+
+func checkTemplate(value string) error {
+	_, err := template.New("error").Parse(value)
+	return err
+}
+
+func checkDuration(value string) error {
+	_, err := time.ParseDuration(strings.TrimSpace(value))
+	return err
+}
+
+func checkSchedule(value string) error {
+	_, err := parseSchedule(value)
+	return err
+}
+
+func checkAuth(value string) error {
+	_, err := parseAuth(value)
+	return err
+}
+
+func checkPrefixes(value string) error {
+	_, err := parsePrefixes(value)
+	return err
+}
+
+func checkHeaderRules(value string) error {
+	_, err := parseHeaderRules(value)
+	return err
+}
+
+func checkConcurrency(value string) error {
+	_, err := parseConcurrency(value)
+	return err
+}
+
+func checkRateLimit(value string) error {
+	_, err := parseRateLimit(value)
+	return err
+}
+
+func checkNodes(value string) error {
+	_, err := parseNodeConstraints(value)
+	return err
+}
+
+func checkMatch(value string) error {
+	_, err := parseHeaderMatch(value)
+	return err
+}
+
+func checkIntercept(value string) error {
+	_, err := parseIntercepts(value)
+	return err
+}
+
+func checkStatus(value string) error {
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || code < 200 || code > 599 {
+		return errors.New("expected a status between 200 and 599")
+	}
+	return nil
+}
+
+// Check a container's SUB2PORT* vars against the schema
+func lintOptions(vars map[string]string) []string {
+	var problems []string
+	for key, value := range vars {
+		spec, ok := optionSchema[key]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: unknown option", key))
+		case spec.Enum != nil && !slices.Contains(spec.Enum, strings.TrimSpace(value)):
+			problems = append(problems, fmt.Sprintf("%s: expected one of %s, got %q", key, strings.Join(spec.Enum, ", "), value))
+		case spec.Pattern != "" && !regexp.MustCompile(spec.Pattern).MatchString(strings.TrimSpace(value)):
+			problems = append(problems, fmt.Sprintf("%s: expected a match of %s, got %q", key, spec.Pattern, value))
+		case spec.check != nil:
+			if err := spec.check(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+			}
+		}
+	}
+	_, tcp := vars["SUB2PORT_TCP"]
+	_, passthrough := vars["SUB2PORT_PASSTHROUGH"]
+	if _, ok := vars["SUB2PORT"]; !ok && !tcp && !passthrough {
+		problems = append(problems, "SUB2PORT: options are set but no hosts are routed")
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+type lintReport struct {
+	Container ContainerName `json:"container"`
+	Problems  []string      `json:"problems"`
+}
+
+var lintReports sync.Map // ContainerID -> lintReport
+
+// Lint a container's config at discovery, logging new problems
+func lintContainer(containerID ContainerID, name ContainerName, vars map[string]string) {
+	problems := lintOptions(vars)
+	if len(problems) == 0 {
+		lintReports.Delete(containerID)
+		return
+	}
+	previous, _ := lintReports.Swap(containerID, lintReport{Container: name, Problems: problems})
+	if previous == nil || !slices.Equal(previous.(lintReport).Problems, problems) {
+		for _, problem := range problems {
+			log.Printf("! %s: %s", name, problem)
+		}
+	}
+}
+
+func init() {
+	registerMetric(&metricFamily{
+		name:   "sub2port_config_violations",
+		kind:   "gauge",
+		help:   "Config problems found in running containers.",
+		labels: []string{"container"},
+		collect: func(emit func(float64, ...string)) {
+			lintReports.Range(func(_, value any) bool {
+				report := value.(lintReport)
+				emit(float64(len(report.Problems)), string(report.Container))
+				return true
+			})
+		},
+	})
+
+	// A JSON Schema of the container options, for editors and CI linters
+	adminMux.HandleFunc("GET /schema", func(writer http.ResponseWriter, _ *http.Request) {
+		writeJSON(writer, http.StatusOK, map[string]interface{}{
+			"$schema":              "https://json-schema.org/draft/2020-12/schema",
+			"title":                "sub2port container options",
+			"type":                 "object",
+			"properties":           optionSchema,
+			"additionalProperties": false,
+		})
+	})
+	adminMux.HandleFunc("GET /lint", func(writer http.ResponseWriter, _ *http.Request) {
+		reports := []lintReport{}
+		lintReports.Range(func(_, value any) bool {
+			reports = append(reports, value.(lintReport))
+			return true
+		})
+		slices.SortFunc(reports, func(a, b lintReport) int {
+			return strings.Compare(string(a.Container), string(b.Container))
+		})
+		writeJSON(writer, http.StatusOK, reports)
+	})
+}
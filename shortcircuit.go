@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Answer noise requests at the proxy, without waking the backend
+func filterShortCircuit(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return false
+	}
+	switch {
+	case options.Favicon && request.URL.Path == "/favicon.ico":
+		writer.Header().Set("Cache-Control", "public, max-age=86400")
+		writer.WriteHeader(http.StatusNoContent)
+	case slices.Contains(options.Pings, request.URL.Path):
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.Header().Set("Cache-Control", "no-store")
+		_, _ = writer.Write([]byte("ok\n"))
+	default:
+		return false
+	}
+	return true
+}
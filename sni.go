@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS passthrough, routing connections by the server name of their ClientHello
+
+// The passthrough listen address, e.g. ":443", shared with SUB2PORT_TLS when they're the same
+var sniAddr = os.Getenv("SUB2PORT_SNI")
+
+// Wait this long for a client's ClientHello
+var helloTimeout = envDuration("SUB2PORT_SNI_TIMEOUT", 10*time.Second)
+
+// A passed through server name is routed as "sni:<host>", balanced like a host
+func sniRoute(host HostName) HostName {
+	return "sni:" + host
+}
+
+// Parse SUB2PORT_PASSTHROUGH entries, "<host>[:<container port>]" separated by commas
+func parsePassthrough(value string) ([]routeEntry, error) {
+	var entries []routeEntry
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		host, port := parseHostEntry(item, "443")
+		if host == "" || strings.ContainsAny(string(host), "/ ") {
+			return nil, fmt.Errorf("%q: expected a host name", item)
+		}
+		if err := checkPort(port); err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		entries = append(entries, routeEntry{host: sniRoute(host), port: port})
+	}
+	return entries, nil
+}
+
+// Connections for the TLS listener, when it shares the passthrough address
+var sniHandoff *handoffListener
+
+func init() {
+	if sniAddr != "" && sniAddr == tlsAddr {
+		sniHandoff = &handoffListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+	}
+}
+
+func serveSNI() {
+	listener, err := net.Listen("tcp", sniAddr)
+	if err != nil {
+		fatal(fail(failBind, err))
+	}
+	log.Printf("# tls passthrough listening on %s", sniAddr)
+	acceptSNI(listener)
+}
+
+func acceptSNI(listener net.Listener) {
+	if sniHandoff != nil {
+		sniHandoff.addr = listener.Addr()
+	}
+	for {
+		client, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Printf("! %s: %v", sniAddr, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go routeSNI(client)
+	}
+}
+
+// Pass a connection through to the backend of its server name, or on to the TLS listener
+func routeSNI(client net.Conn) {
+	name, conn, err := sniffServerName(client)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("# sni %s: %v", client.RemoteAddr(), err))
+		_ = client.Close()
+		return
+	}
+	host := normalizeHost(name)
+	if name != "" && table.lookup(sniRoute(host)) == nil {
+		if _, rest, ok := strings.Cut(string(host), "."); ok && table.lookup(sniRoute(HostName("*."+rest))) != nil {
+			host = HostName("*." + rest)
+		}
+	}
+	if name != "" && table.lookup(sniRoute(host)) != nil {
+		proxyTCP(sniRoute(host), conn)
+		return
+	}
+	if sniHandoff != nil && sniHandoff.hand(conn) {
+		return
+	}
+	_ = client.Close()
+}
+
+var errSniffed = errors.New("sniffed")
+
+// Read a connection's ClientHello without answering it, returning its server
+// name and a connection that replays it
+func sniffServerName(client net.Conn) (string, net.Conn, error) {
+	var hello bytes.Buffer
+	var name string
+	_ = client.SetReadDeadline(time.Now().Add(helloTimeout))
+	err := tls.Server(&peekedConn{Conn: client, reader: io.TeeReader(client, &hello), sniffing: true}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = info.ServerName
+			return nil, errSniffed
+		},
+	}).Handshake()
+	_ = client.SetReadDeadline(time.Time{})
+	if !errors.Is(err, errSniffed) {
+		return "", nil, err
+	}
+	return name, &peekedConn{Conn: client, reader: io.MultiReader(&hello, client)}, nil
+}
+
+// A connection whose reads come from another reader, and whose writes are
+// dropped while sniffing
+type peekedConn struct {
+	net.Conn
+	reader   io.Reader
+	sniffing bool
+}
+
+func (conn *peekedConn) Read(buffer []byte) (int, error) {
+	return conn.reader.Read(buffer)
+}
+
+func (conn *peekedConn) Write(buffer []byte) (int, error) {
+	// The sniffing handshake answers with an alert the client mustn't see.
+	if conn.sniffing {
+		return len(buffer), nil
+	}
+	return conn.Conn.Write(buffer)
+}
+
+func (conn *peekedConn) CloseWrite() error {
+	if closer, ok := conn.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return conn.Conn.Close()
+}
+
+// A listener accepting the connections handed to it
+type handoffListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	addr   net.Addr
+}
+
+func (listener *handoffListener) hand(conn net.Conn) bool {
+	select {
+	case listener.conns <- conn:
+		return true
+	case <-listener.closed:
+		return false
+	}
+}
+
+func (listener *handoffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *handoffListener) Close() error {
+	listener.once.Do(func() { close(listener.closed) })
+	return nil
+}
+
+func (listener *handoffListener) Addr() net.Addr {
+	return listener.addr
+}
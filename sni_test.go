@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSNIPassthrough(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.WriteString(writer, "from "+request.TLS.ServerName)
+	}))
+	t.Cleanup(backend.Close)
+	_, backendPort, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go acceptSNI(listener)
+
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+	fake.run("secure", "127.0.0.1", "SUB2PORT_PASSTHROUGH=*.secure.test:"+backendPort)
+	eventually(t, "the passthrough route", routedTo("sni:*.secure.test", 1))
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	response, err := client.Get("https://app.secure.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if string(body) != "from app.secure.test" {
+		t.Fatalf("expected the backend to terminate TLS, got %q", body)
+	}
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "other.test", InsecureSkipVerify: true})
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("expected an unrouted server name to be closed")
+	}
+}
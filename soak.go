@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Soak test: synthetic route churn against the live route table while serving traffic
+
+func soak(args []string) int {
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	hosts := flags.Int("hosts", 50, "hosts to route")
+	replicas := flags.Int("replicas", 4, "churned backends per host, next to one that stays")
+	rate := flags.Float64("rate", 200, "route changes per second")
+	clients := flags.Int("clients", 16, "concurrent clients sending requests")
+	duration := flags.Duration("duration", time.Minute, "how long to run")
+	_ = flags.Parse(args)
+	if *hosts < 1 || *replicas < 0 || *rate <= 0 || *clients < 1 {
+		fmt.Fprintln(flags.Output(), "soak: hosts, rate, and clients must be positive")
+		return 2
+	}
+
+	// A few backends answer for every synthetic container.
+	var ports []string
+	for range 4 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Printf("! soak: %v", err)
+			return 1
+		}
+		go func() {
+			_ = http.Serve(listener, http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+				_, _ = writer.Write([]byte("ok\n"))
+			}))
+		}()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		ports = append(ports, port)
+	}
+	bind := func(host, replica int) {
+		name := ContainerName(fmt.Sprintf("soak-%d-%d", host, replica))
+		domain := HostName(fmt.Sprintf("soak%d.test", host))
+		bindRoute(domain, route{Name: name, ID: ContainerID(name), Host: "127.0.0.1", Port: ports[(host+replica)%len(ports)], Options: parseOptions(name, nil)}, false)
+		table.containers[ContainerID(name)] = []binding{{Domain: domain, Name: name}}
+	}
+	// Replica 0 stays, so every request has a backend to go to.
+	table.Lock()
+	for host := range *hosts {
+		for replica := range *replicas + 1 {
+			bind(host, replica)
+		}
+	}
+	table.Unlock()
+	defer func() {
+		table.Lock()
+		defer table.Unlock()
+		for host := range *hosts {
+			for replica := range *replicas + 1 {
+				unbindRoutes(ContainerID(fmt.Sprintf("soak-%d-%d", host, replica)), false, false)
+			}
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Printf("! soak: %v", err)
+		return 1
+	}
+	go func() { _ = http.Serve(listener, http.HandlerFunc(proxy)) }()
+	log.Printf("# soaking %d hosts of %d backends with %.0f route changes/s and %d clients for %s",
+		*hosts, *replicas+1, *rate, *clients, *duration)
+
+	done := make(chan struct{})
+	var requests, failures, changes atomic.Uint64
+	var workers sync.WaitGroup
+	if *replicas > 0 {
+		workers.Go(func() {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				host, replica := rand.IntN(*hosts), 1+rand.IntN(*replicas)
+				containerID := ContainerID(fmt.Sprintf("soak-%d-%d", host, replica))
+				table.Lock()
+				if _, routed := table.containers[containerID]; routed {
+					unbindRoutes(containerID, false, false)
+				} else {
+					bind(host, replica)
+				}
+				table.Unlock()
+				changes.Add(1)
+			}
+		})
+	}
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *clients}, Timeout: 10 * time.Second}
+	for range *clients {
+		workers.Go(func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				request, _ := http.NewRequest(http.MethodGet, "http://"+listener.Addr().String()+"/", nil)
+				request.Host = fmt.Sprintf("soak%d.test", rand.IntN(*hosts))
+				response, err := client.Do(request)
+				requests.Add(1)
+				if err != nil {
+					failures.Add(1)
+					log.Printf("! soak %s: %v", request.Host, err)
+					continue
+				}
+				_, _ = io.Copy(io.Discard, response.Body)
+				_ = response.Body.Close()
+				if response.StatusCode != http.StatusOK {
+					failures.Add(1)
+					log.Printf("! soak %s: %s", request.Host, response.Status)
+				}
+			}
+		})
+	}
+
+	report := func(elapsed time.Duration) {
+		var memory runtime.MemStats
+		runtime.ReadMemStats(&memory)
+		log.Printf("# soak %s: %d requests, %d failed, %d route changes, %d goroutines, %d MiB heap",
+			elapsed.Round(time.Second), requests.Load(), failures.Load(), changes.Load(), runtime.NumGoroutine(), memory.HeapAlloc>>20)
+	}
+	started := time.Now()
+	ticker := time.NewTicker(5 * time.Second)
+	for deadline := time.After(*duration); ; {
+		select {
+		case <-ticker.C:
+			report(time.Since(started))
+			continue
+		case <-deadline:
+		}
+		break
+	}
+	ticker.Stop()
+	close(done)
+	workers.Wait()
+	report(time.Since(started))
+	if failures.Load() > 0 {
+		log.Printf("! soak failed: %d of %d requests", failures.Load(), requests.Load())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sorry server
+
+// A static page served in place of a host's backends while none can answer
+type sorryServer struct {
+	status int
+	body   []byte
+}
+
+var defaultSorryPage = []byte(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Be right back</title></head>
+<body><h1>Be right back</h1><p>This site is temporarily unavailable. Please try again in a few minutes.</p></body>
+</html>
+`)
+
+// Pages the operator mounted for SUB2PORT_SORRY=<name>, read once at startup
+// from SUB2PORT_SORRY_DIR, so containers can't name other files of the proxy
+var sorryPages = loadSorryPages(os.Getenv("SUB2PORT_SORRY_DIR"))
+
+func loadSorryPages(dir string) map[string][]byte {
+	pages := make(map[string][]byte)
+	if dir == "" {
+		return pages
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_SORRY_DIR: %w", err)))
+	}
+	for _, entry := range entries {
+		// Symlinks could lead anywhere, like the cert store.
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			fatal(fail(failConfig, fmt.Errorf("SUB2PORT_SORRY_DIR: %w", err)))
+		}
+		pages[entry.Name()] = body
+	}
+	return pages
+}
+
+// Parse SUB2PORT_SORRY (inline HTML or a page of SUB2PORT_SORRY_DIR) and SUB2PORT_SORRY_STATUS
+func newSorryServer(vars map[string]string) (*sorryServer, error) {
+	page := strings.TrimSpace(vars["SUB2PORT_SORRY"])
+	if page == "" {
+		return nil, nil
+	}
+	sorry := &sorryServer{status: http.StatusServiceUnavailable, body: defaultSorryPage}
+	switch {
+	case page == "true":
+	case strings.HasPrefix(page, "<"):
+		sorry.body = []byte(page)
+	default:
+		body, ok := sorryPages[page]
+		if !ok {
+			return nil, fmt.Errorf("SUB2PORT_SORRY: expected true, inline HTML, or a page in SUB2PORT_SORRY_DIR, got %q", page)
+		}
+		sorry.body = body
+	}
+	if status := strings.TrimSpace(vars["SUB2PORT_SORRY_STATUS"]); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 200 || code > 599 {
+			return nil, fmt.Errorf("SUB2PORT_SORRY_STATUS: bad status %q", status)
+		}
+		sorry.status = code
+	}
+	return sorry, nil
+}
+
+func (sorry *sorryServer) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	header := writer.Header()
+	header.Set("Content-Type", http.DetectContentType(sorry.body))
+	if bytes.HasPrefix(bytes.TrimSpace(sorry.body), []byte("<")) {
+		header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(sorry.body)))
+	header.Set("Cache-Control", "no-store")
+	if sorry.status == http.StatusServiceUnavailable {
+		header.Set("Retry-After", "30")
+	}
+	writer.WriteHeader(sorry.status)
+	_, _ = writer.Write(sorry.body)
+}
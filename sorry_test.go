@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Containers can only name pages the operator put in SUB2PORT_SORRY_DIR
+func TestSorryPages(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "account.key")
+	if err := os.WriteFile(secret, []byte("private"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "maintenance.html"), []byte("<h1>Back soon</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "key.html")); err != nil {
+		t.Fatal(err)
+	}
+	previous := sorryPages
+	sorryPages = loadSorryPages(dir)
+	t.Cleanup(func() { sorryPages = previous })
+
+	sorry, err := newSorryServer(map[string]string{"SUB2PORT_SORRY": "maintenance.html"})
+	if err != nil || string(sorry.body) != "<h1>Back soon</h1>" {
+		t.Fatalf("expected the mounted page, got %v", err)
+	}
+	for _, page := range []string{secret, "key.html", "../" + filepath.Base(dir) + "/maintenance.html", "/proc/self/environ"} {
+		if _, err := newSorryServer(map[string]string{"SUB2PORT_SORRY": page}); err == nil {
+			t.Errorf("expected %q to be refused", page)
+		}
+	}
+}
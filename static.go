@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Static routes from a remote config source
+
+// A JSON route list fetched over HTTP(S), or an S3 object as s3://<bucket>/<key>
+var staticURL = os.Getenv("SUB2PORT_ROUTES_URL")
+
+// The S3 object of an s3:// SUB2PORT_ROUTES_URL, whose requests are signed
+var staticObject = func() *s3Object {
+	if !strings.HasPrefix(staticURL, "s3://") {
+		return nil
+	}
+	object, err := parseS3Object(staticURL)
+	if err != nil {
+		fatal(fail(failConfig, fmt.Errorf("SUB2PORT_ROUTES_URL: %w", err)))
+	}
+	return object
+}()
+var staticInterval = envDuration("SUB2PORT_ROUTES_INTERVAL", 30*time.Second)
+
+type staticRoute struct {
+	Host    HostName          `json:"host"`
+	Backend string            `json:"backend"` // host:port
+	Options map[string]string `json:"options"` // SUB2PORT_<OPTION> -> value
+}
+
+// The ETag is only used by watchStaticRoutes, the ids are guarded by the table lock
+var staticRoutes struct {
+	etag string
+	ids  []ContainerID
+}
+
+var staticFetches = newCounterVec("sub2port_static_fetches_total", "Fetches of SUB2PORT_ROUTES_URL by result.", "result")
+
+func init() {
+	newGaugeFunc("sub2port_static_routes", "Routes loaded from SUB2PORT_ROUTES_URL.", func() float64 {
+		table.RLock()
+		defer table.RUnlock()
+		return float64(len(staticRoutes.ids))
+	})
+}
+
+// Poll the remote route list, replacing the static routes when it changes
+func watchStaticRoutes() {
+	if staticURL == "" {
+		return
+	}
+	client := &http.Client{Timeout: max(staticInterval, 10*time.Second)}
+	ticker := time.NewTicker(staticInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		routes, err := fetchStaticRoutes(client)
+		switch {
+		case err != nil:
+			staticFetches.Inc("error")
+			log.Printf("! SUB2PORT_ROUTES_URL: %v, keeping the last routes", err)
+		case routes == nil:
+			staticFetches.Inc("unchanged")
+		default:
+			staticFetches.Inc("updated")
+			loadStaticRoutes(routes)
+		}
+	}
+}
+
+// Fetch the route list, or nil when its ETag is unchanged
+func fetchStaticRoutes(client *http.Client) ([]staticRoute, error) {
+	target := staticURL
+	if staticObject != nil {
+		target = staticObject.url
+	}
+	request, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if staticRoutes.etag != "" {
+		request.Header.Set("If-None-Match", staticRoutes.etag)
+	}
+	if staticObject != nil {
+		staticObject.sign(request, time.Now())
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("fetch: %s", response.Status)
+	}
+	routes := []staticRoute{}
+	if err := json.NewDecoder(response.Body).Decode(&routes); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	staticRoutes.etag = response.Header.Get("ETag")
+	return routes, nil
+}
+
+// Replace the static routes, which are bound like containers without an owner
+func loadStaticRoutes(routes []staticRoute) {
+	table.Lock()
+	defer table.Unlock()
+	for _, id := range staticRoutes.ids {
+		unbindRoutes(id, false, true)
+	}
+	staticRoutes.ids = nil
+	for _, static := range routes {
+		id := ContainerID("static:" + string(static.Host) + "->" + static.Backend)
+		if err := bindAddress(id, static.Host, static.Backend, static.Options); err != nil {
+			log.Printf("! SUB2PORT_ROUTES_URL: %v", err)
+			continue
+		}
+		staticRoutes.ids = append(staticRoutes.ids, id)
+	}
+}
+
+// Bind a backend that isn't a container while holding the table lock
+func bindAddress(id ContainerID, domain HostName, backend string, options map[string]string) error {
+	domain = normalizeRoute(string(domain))
+	host, port, err := net.SplitHostPort(backend)
+	if err != nil || domain == "" {
+		return fmt.Errorf("bad route %q -> %q", domain, backend)
+	}
+	vars := map[string]string{"SUB2PORT": string(domain)}
+	for key, value := range options {
+		if strings.HasPrefix(key, "SUB2PORT_") {
+			vars[key] = value
+		}
+	}
+	name := ContainerName(host)
+	route := route{Name: name, Host: host, Port: port, Options: parseOptions(name, vars)}
+	count := bindRoute(domain, route, false)
+	table.containers[id] = append(table.containers[id], binding{Domain: domain, Name: name})
+	logRoute("+", domain, count, ContainerName(host), port, "")
+	return nil
+}
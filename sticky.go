@@ -0,0 +1,42 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+)
+
+// Cookie session affinity
+
+const stickyCookie = "sub2port_backend"
+
+// An opaque backend ID, so the cookie doesn't reveal container names
+func stickyID(backend route) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(backend.Name))
+	return strconv.FormatUint(hash.Sum64(), 36)
+}
+
+// The backend in a client's cookie, if it is still in the pool
+func stickyIndex(request *http.Request, pool *hostPool) (uint64, bool) {
+	if cookie, err := request.Cookie(stickyCookie); err == nil {
+		for index, backend := range pool.backends {
+			if stickyID(backend) == cookie.Value {
+				return uint64(index), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Pin a client to the picked backend
+func setSticky(writer http.ResponseWriter, request *http.Request, picked route) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     stickyCookie,
+		Value:    stickyID(picked),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
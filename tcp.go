@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TCP stream proxying for non-HTTP services
+
+// A proxy port is routed as "tcp:<port>", balanced like a host
+func tcpRoute(port int) HostName {
+	return HostName("tcp:" + strconv.Itoa(port))
+}
+
+func tcpPort(host HostName) (string, bool) {
+	return strings.CutPrefix(string(host), "tcp:")
+}
+
+// The most ports a SUB2PORT_TCP range can listen on
+const maxTCPRange = 1000
+
+// Parse SUB2PORT_TCP entries, "<port>[-<end>][-><container port>[-<end>]]"
+// separated by commas, e.g. 5432, 15432->5432, or 3000-3010
+func parseTCPEntries(value string) ([]routeEntry, error) {
+	var entries []routeEntry
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		listen, target, mapped := strings.Cut(item, "->")
+		first, last, err := parsePortRange(listen)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		to := first
+		if mapped {
+			targetFirst, targetLast, err := parsePortRange(target)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", item, err)
+			}
+			if targetLast-targetFirst != last-first {
+				return nil, fmt.Errorf("%q: the ranges differ in size", item)
+			}
+			to = targetFirst
+		}
+		for port := first; port <= last; port++ {
+			entries = append(entries, routeEntry{host: tcpRoute(port), port: strconv.Itoa(to + port - first)})
+		}
+	}
+	return entries, nil
+}
+
+func parsePortRange(value string) (int, int, error) {
+	start, end, ranged := strings.Cut(strings.TrimSpace(value), "-")
+	first, err := strconv.Atoi(strings.TrimSpace(start))
+	last := first
+	if err == nil && ranged {
+		last, err = strconv.Atoi(strings.TrimSpace(end))
+	}
+	switch {
+	case err != nil || first < 1 || last > 65535:
+		return 0, 0, errors.New("expected ports between 1 and 65535")
+	case last < first || last-first >= maxTCPRange:
+		return 0, 0, fmt.Errorf("expected a range of 1 to %d ports", maxTCPRange)
+	}
+	return first, last, nil
+}
+
+// Listeners of the TCP routes, guarded by the table lock
+var tcpListeners = make(map[HostName]net.Listener)
+
+var tcpDialer = &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+
+// Listen on a TCP route's port once it has a backend, while holding the table lock
+func listenTCP(host HostName, port string) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Printf("! %s: %v", host, err)
+		return
+	}
+	log.Printf("# tcp listening on :%s", port)
+	tcpListeners[host] = listener
+	go serveTCP(host, listener)
+}
+
+// Stop listening once the route is removed, while holding the table lock
+func closeTCP(host HostName) {
+	if listener := tcpListeners[host]; listener != nil {
+		_ = listener.Close()
+		delete(tcpListeners, host)
+	}
+}
+
+func serveTCP(host HostName, listener net.Listener) {
+	for {
+		client, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Printf("! %s: %v", host, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go proxyTCP(host, client)
+	}
+}
+
+// Forward a connection to one of the route's backends, trying the next when one can't be dialed
+func proxyTCP(host HostName, client net.Conn) {
+	defer func() { _ = client.Close() }()
+	entry := table.lookup(host)
+	if entry == nil {
+		return
+	}
+	pool := entry.pool.Load().serving()
+	if len(pool.backends) == 0 {
+		return
+	}
+	options := pool.options()
+	// Balancers read the client's address from its request.
+	request := &http.Request{RemoteAddr: client.RemoteAddr().String(), Header: http.Header{}}
+	first := options.balancer().pick(entry, pool.backends, request)
+	var backend route
+	var upstream net.Conn
+	err := errRemoved
+	for attempt := range min(options.Retries, len(pool.backends)-1) + 1 {
+		if backend = pool.backends[(first+attempt)%len(pool.backends)]; backend.removed() {
+			continue
+		}
+		if upstream, err = tcpDialer.Dial("tcp", net.JoinHostPort(backend.Host, backend.Port)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("proxy %s -> %s:%s: %v", host, backend.Name, backend.Port, err)
+		recordUpstreamError(host, backend.Name, err)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+	backend.inflight.Add(1)
+	defer backend.inflight.Add(-1)
+	backend.served.Add(1)
+
+	done := make(chan struct{}, 2)
+	go pipe(upstream, client, done)
+	go pipe(client, upstream, done)
+	<-done
+	<-done
+}
+
+// Copy one direction of a stream, then pass its end on
+func pipe(to, from net.Conn, done chan<- struct{}) {
+	_, _ = io.Copy(to, from)
+	if conn, ok := to.(interface{ CloseWrite() error }); ok {
+		_ = conn.CloseWrite()
+	} else {
+		_ = to.Close()
+	}
+	done <- struct{}{}
+}
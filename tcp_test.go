@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// A SUB2PORT_TCP port forwards raw streams while the container runs
+func TestTCPRoute(t *testing.T) {
+	entries, err := parseTCPEntries("5432, 13000-13002->3000-3002")
+	if err != nil || len(entries) != 4 || entries[0] != (routeEntry{host: "tcp:5432", port: "5432"}) || entries[3] != (routeEntry{host: "tcp:13002", port: "3002"}) {
+		t.Fatalf("unexpected entries %v, %v", entries, err)
+	}
+	for _, bad := range []string{"0", "3000-2000", "1-2000", "3000-3001->4000"} {
+		if _, err := parseTCPEntries(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	free, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(free.Addr().String())
+	_ = free.Close()
+
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+	fake.run("tcp-echo", "127.0.0.1", "SUB2PORT_TCP="+port+"->"+echoPort)
+	eventually(t, "the tcp route", routedTo(HostName("tcp:"+port), 1))
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("ping"))
+	_ = conn.(*net.TCPConn).CloseWrite()
+	if reply, _ := io.ReadAll(conn); string(reply) != "ping" {
+		t.Fatalf("expected the echo, got %q", reply)
+	}
+	_ = conn.Close()
+
+	fake.stop("tcp-echo")
+	eventually(t, "the port to close", func() bool {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err != nil
+	})
+}
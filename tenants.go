@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Wildcard hosts and tenants taken from their subdomain
+
+// Split a "{tenant}.app.test" route into the wildcard "*.app.test" and the
+// header its subdomain is forwarded in, X-Tenant
+func splitTenant(route HostName) (HostName, string) {
+	name, rest, ok := strings.Cut(string(route), "}.")
+	if !ok || !strings.HasPrefix(name, "{") || len(name) < 2 {
+		return route, ""
+	}
+	return HostName("*." + rest), http.CanonicalHeaderKey("X-" + name[1:])
+}
+
+// The wildcard route of a host without its own, e.g. "*.app.test" for "acme.app.test"
+func (table *routeTable) wildcard(host HostName) HostName {
+	if _, ok := table.hosts.Load(host); ok {
+		return host
+	}
+	if _, ok := table.paths.Load(host); ok {
+		return host
+	}
+	_, rest, ok := strings.Cut(string(host), ".")
+	if !ok {
+		return host
+	}
+	wildcard := HostName("*." + rest)
+	if _, ok := table.hosts.Load(wildcard); ok {
+		return wildcard
+	}
+	if _, ok := table.paths.Load(wildcard); ok {
+		return wildcard
+	}
+	return host
+}
+
+// Send the subdomain a wildcard route matched to the backend, replacing any sent by the client
+func setTenant(request *http.Request, header string) {
+	tenant, _, _ := strings.Cut(string(requestHost(request)), ".")
+	request.Header.Set(header, tenant)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A {tenant} subdomain route serves every tenant and tells the backend which one asked
+func TestTenantRoute(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprint(writer, request.Header.Get("X-Tenant"))
+	}))
+	t.Cleanup(backend.Close)
+	address, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.run("tenants", address, "SUB2PORT={tenant}.tenants.test:"+port, "SUB2PORT_TENANTS=acme")
+	eventually(t, "the wildcard route", routedTo("*.tenants.test", 1))
+
+	request := httptest.NewRequest(http.MethodGet, "http://Acme.Tenants.test/", nil)
+	request.Header.Set("X-Tenant", "spoofed")
+	recorder := httptest.NewRecorder()
+	proxy(recorder, request)
+	if recorder.Body.String() != "acme" {
+		t.Fatalf("expected the acme tenant, got %d %q", recorder.Code, recorder.Body)
+	}
+	if !orderable("acme.tenants.test") || orderable("globex.tenants.test") || orderable("tenants.test") || orderable("a.b.tenants.test") {
+		t.Fatal("expected only listed tenants to be ordered a certificate")
+	}
+}
+
+// Tenants that aren't listed get the wildcard certificate, or none, but never start an order
+func TestTenantCertificate(t *testing.T) {
+	previous := certStorage
+	certStorage = dirStore{dir: t.TempDir()}
+	t.Cleanup(func() { certStorage = previous })
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+	fake.run("tenant-certs", "127.0.0.1", "SUB2PORT={tenant}.certs.test:80")
+	eventually(t, "the wildcard route", routedTo("*.certs.test", 1))
+
+	manager := &certManager{}
+	if cert, err := manager.getCertificate(&tls.ClientHelloInfo{ServerName: "made-up.certs.test"}); cert != nil || err == nil {
+		t.Fatalf("expected no certificate without a wildcard one, got %v", err)
+	}
+	wildcard := &tls.Certificate{}
+	manager.certs.Store(HostName("*.certs.test"), wildcard)
+	if cert, err := manager.getCertificate(&tls.ClientHelloInfo{ServerName: "made-up.certs.test"}); cert != wildcard || err != nil {
+		t.Fatalf("expected the wildcard certificate, got %v", err)
+	}
+	if orderable("*.certs.test") {
+		t.Fatal("expected the wildcard itself not to be ordered")
+	}
+}
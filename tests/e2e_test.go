@@ -2,7 +2,6 @@ package tests
 
 import (
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -10,12 +9,12 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"sub2port/tests/sub2porttest"
 )
 
 var testsDir string
 
-var httpClient = &http.Client{Timeout: 5 * time.Second}
-
 func TestMain(m *testing.M) {
 	if _, err := exec.LookPath("docker"); err != nil {
 		fmt.Fprintln(os.Stderr, "docker not found, skipping integration tests")
@@ -41,108 +40,12 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-// compose helpers
-
-func composeUp(t *testing.T, file, project string) {
-	t.Helper()
-	cmd := exec.Command("docker", "compose", "-f", file, "-p", project, "up", "-d")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		t.Fatalf("compose up: %v\n%s", err, out)
-	}
-}
-
-func composeDown(file, project string) {
-	exec.Command("docker", "compose", "-f", file, "-p", project,
-		"down", "-v", "--remove-orphans", "-t", "5").Run()
-}
-
-func composeLogs(file, project string) string {
-	cmd := exec.Command("docker", "compose", "-f", file, "-p", project,
-		"logs", "--no-color", "sub2port")
-	out, _ := cmd.CombinedOutput()
-	return string(out)
-}
-
-// assertion helpers
-
-func containsAll(s string, subs []string) bool {
-	for _, sub := range subs {
-		if !strings.Contains(s, sub) {
-			return false
-		}
-	}
-	return true
-}
-
-func waitForLogs(t *testing.T, file, project string, expected []string, timeout time.Duration) string {
+// Start a compose file of this directory and wait for the proxy's logs
+func setup(t *testing.T, yml string, port int, logs []string) (*sub2porttest.Stack, string) {
 	t.Helper()
-	deadline := time.Now().Add(timeout)
-	for {
-		logs := composeLogs(file, project)
-		if containsAll(logs, expected) {
-			return logs
-		}
-		if time.Now().After(deadline) {
-			t.Fatalf("timeout waiting for logs\nwant: %v\ngot:\n%s", expected, logs)
-		}
-		time.Sleep(500 * time.Millisecond)
-	}
-}
-
-func assertLogSequence(t *testing.T, logs string, seq []string) {
-	t.Helper()
-	pos := 0
-	for _, sub := range seq {
-		idx := strings.Index(logs[pos:], sub)
-		if idx < 0 {
-			t.Fatalf("log sequence broken: %q not found after position %d\nlogs:\n%s", sub, pos, logs)
-		}
-		pos += idx + len(sub)
-	}
-}
-
-func get(t *testing.T, port int, host string) (int, string) {
-	t.Helper()
-	addr := fmt.Sprintf("http://127.0.0.1:%d/", port)
-	var lastErr error
-	for range 10 {
-		req, _ := http.NewRequest("GET", addr, nil)
-		req.Host = host
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			lastErr = err
-			time.Sleep(500 * time.Millisecond)
-			continue
-		}
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return resp.StatusCode, string(body)
-	}
-	t.Fatalf("GET %s via port %d failed after retries: %v", host, port, lastErr)
-	return 0, ""
-}
-
-func whoamiHostname(body string) string {
-	for _, line := range strings.Split(body, "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "Hostname:") {
-			return strings.TrimSpace(strings.TrimPrefix(trimmed, "Hostname:"))
-		}
-	}
-	return ""
-}
-
-func setup(t *testing.T, yml string, logs []string) string {
-	t.Helper()
-	file := filepath.Join(testsDir, yml)
 	project := "sub2port-test-" + strings.TrimSuffix(yml, ".yml")
-
-	composeDown(file, project)
-	t.Cleanup(func() { composeDown(file, project) })
-
-	composeUp(t, file, project)
-	return waitForLogs(t, file, project, logs, 300*time.Second)
+	stack := sub2porttest.Up(t, filepath.Join(testsDir, yml), project, port)
+	return stack, stack.WaitForLogs(logs, 300*time.Second)
 }
 
 // tests
@@ -153,10 +56,10 @@ func TestSingleHost(t *testing.T) {
 		"# listening on",
 		"+ app.test (1)",
 	}
-	logs := setup(t, "single-host.yml", seq)
-	assertLogSequence(t, logs, seq)
+	stack, logs := setup(t, "single-host.yml", 18081, seq)
+	sub2porttest.AssertLogSequence(t, logs, seq)
 
-	code, body := get(t, 18081, "app.test")
+	code, body := stack.Get("app.test")
 	if code != 200 {
 		t.Fatalf("expected 200, got %d", code)
 	}
@@ -172,14 +75,14 @@ func TestRoundRobin(t *testing.T) {
 		"+ app.test (1)",
 		"+ app.test (2)",
 	}
-	logs := setup(t, "round-robin.yml", seq)
-	assertLogSequence(t, logs, seq)
+	stack, logs := setup(t, "round-robin.yml", 18082, seq)
+	sub2porttest.AssertLogSequence(t, logs, seq)
 
-	_, body1 := get(t, 18082, "app.test")
-	_, body2 := get(t, 18082, "app.test")
+	_, body1 := stack.Get("app.test")
+	_, body2 := stack.Get("app.test")
 
-	h1 := whoamiHostname(body1)
-	h2 := whoamiHostname(body2)
+	h1 := sub2porttest.WhoamiHostname(body1)
+	h2 := sub2porttest.WhoamiHostname(body2)
 	if h1 == "" || h2 == "" {
 		t.Fatalf("could not parse whoami hostnames\nbody1:\n%s\nbody2:\n%s", body1, body2)
 	}
@@ -195,10 +98,10 @@ func TestMultiHost(t *testing.T) {
 		"+ a.test (1)",
 		"+ b.test (1)",
 	}
-	logs := setup(t, "multi-host.yml", wait)
-	assertLogSequence(t, logs, []string{"# using network", "# listening on"})
+	stack, logs := setup(t, "multi-host.yml", 18083, wait)
+	sub2porttest.AssertLogSequence(t, logs, []string{"# using network", "# listening on"})
 
-	code, body := get(t, 18083, "a.test")
+	code, body := stack.Get("a.test")
 	if code != 200 {
 		t.Fatalf("a.test: expected 200, got %d", code)
 	}
@@ -206,7 +109,7 @@ func TestMultiHost(t *testing.T) {
 		t.Fatalf("a.test response missing expected Host header\n%s", body)
 	}
 
-	code, body = get(t, 18083, "b.test")
+	code, body = stack.Get("b.test")
 	if code != 200 {
 		t.Fatalf("b.test: expected 200, got %d", code)
 	}
@@ -221,14 +124,14 @@ func TestCustomPort(t *testing.T) {
 		"# listening on",
 		"+ app.test (1)",
 	}
-	logs := setup(t, "custom-port.yml", seq)
-	assertLogSequence(t, logs, seq)
+	stack, logs := setup(t, "custom-port.yml", 18084, seq)
+	sub2porttest.AssertLogSequence(t, logs, seq)
 
 	if !strings.Contains(logs, ":8080") {
 		t.Fatalf("expected route to port 8080\nlogs:\n%s", logs)
 	}
 
-	code, body := get(t, 18084, "app.test")
+	code, body := stack.Get("app.test")
 	if code != 200 {
 		t.Fatalf("expected 200, got %d", code)
 	}
@@ -244,23 +147,17 @@ func TestStopContainer(t *testing.T) {
 		"+ app.test (1)",
 		"+ app.test (2)",
 	}
-	logs := setup(t, "stop-container.yml", seq)
-	assertLogSequence(t, logs, seq)
+	stack, logs := setup(t, "stop-container.yml", 18086, seq)
+	sub2porttest.AssertLogSequence(t, logs, seq)
 
 	// Stop one of the two backends.
-	file := filepath.Join(testsDir, "stop-container.yml")
-	project := "sub2port-test-stop-container"
-	cmd := exec.Command("docker", "compose", "-f", file, "-p", project, "stop", "app2")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		t.Fatalf("compose stop app2: %v\n%s", err, out)
-	}
+	stack.Compose("stop", "app2")
 
 	// Wait for the removal log line.
-	waitForLogs(t, file, project, []string{"- app.test (1)"}, 30*time.Second)
+	stack.WaitForLogs([]string{"- app.test (1)"}, 30*time.Second)
 
 	// The remaining backend should still serve requests.
-	code, body := get(t, 18086, "app.test")
+	code, body := stack.Get("app.test")
 	if code != 200 {
 		t.Fatalf("expected 200, got %d", code)
 	}
@@ -275,10 +172,10 @@ func TestDefaultPort(t *testing.T) {
 		"# listening on",
 		"+ app.test (1)",
 	}
-	logs := setup(t, "default-port.yml", seq)
-	assertLogSequence(t, logs, seq)
+	stack, logs := setup(t, "default-port.yml", 18085, seq)
+	sub2porttest.AssertLogSequence(t, logs, seq)
 
-	code, body := get(t, 18085, "app.test")
+	code, body := stack.Get("app.test")
 	if code != 200 {
 		t.Fatalf("expected 200, got %d", code)
 	}
@@ -286,3 +183,74 @@ func TestDefaultPort(t *testing.T) {
 		t.Fatalf("response missing expected Host header\n%s", body)
 	}
 }
+
+var davHeader = http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}
+
+func TestWebDAV(t *testing.T) {
+	wait := []string{
+		"# using network",
+		"# listening on",
+		"+ dav.test (1)",
+		"+ ro.test (1)",
+	}
+	stack, _ := setup(t, "webdav.yml", 18087, wait)
+
+	code, _, _ := stack.Do("MKCOL", "dav.test", "/docs/", "", davHeader)
+	if code != 201 {
+		t.Fatalf("MKCOL: expected 201, got %d", code)
+	}
+
+	// A large XML body must be streamed through intact.
+	props := strings.Repeat("<D:getetag/><D:getlastmodified/>", 32*1024)
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop>` + props + `</D:prop></D:propfind>`
+	code, _, resp := stack.Do("PROPFIND", "dav.test", "/", body, davHeader)
+	if code != 207 {
+		t.Fatalf("PROPFIND: expected 207, got %d\n%s", code, resp)
+	}
+	if !strings.Contains(resp, "/docs/") {
+		t.Fatalf("PROPFIND response missing collection\n%s", resp)
+	}
+
+	code, _, _ = stack.Do("PROPFIND", "ro.test", "/", "", davHeader)
+	if code != 207 {
+		t.Fatalf("ro.test PROPFIND: expected 207, got %d", code)
+	}
+	code, _, _ = stack.Do("MKCOL", "ro.test", "/docs/", "", davHeader)
+	if code != 405 {
+		t.Fatalf("ro.test MKCOL: expected 405, got %d", code)
+	}
+
+	code, header, _ := stack.Do("GET", "dav.test", "/.well-known/caldav", "", davHeader)
+	if code != 301 || header.Get("Location") != "/remote.php/dav" {
+		t.Fatalf("caldav: expected 301 to /remote.php/dav, got %d %q", code, header.Get("Location"))
+	}
+}
+
+func TestLabels(t *testing.T) {
+	wait := []string{
+		"# using network",
+		"# listening on",
+		"+ label.test (1)",
+		"+ env.test (1)",
+	}
+	stack, logs := setup(t, "labels.yml", 18088, wait)
+
+	if !strings.Contains(logs, ":8080") {
+		t.Fatalf("expected route to port 8080\nlogs:\n%s", logs)
+	}
+	if strings.Contains(logs, "ignored.test") {
+		t.Fatalf("expected SUB2PORT to win over the sub2port.host label\nlogs:\n%s", logs)
+	}
+
+	stack.Admin = "http://127.0.0.1:18188"
+	stack.WaitForBackends("label.test", 1, 30*time.Second)
+	stack.AssertNoLintProblems()
+
+	code, body := stack.Get("label.test")
+	if code != 200 {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if !strings.Contains(body, "Host: label.test") {
+		t.Fatalf("response missing expected Host header\n%s", body)
+	}
+}
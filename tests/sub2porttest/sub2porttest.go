@@ -0,0 +1,236 @@
+// Package sub2porttest runs compose stacks fronted by sub2port and asserts on
+// their routing, so stacks can test their host names in CI.
+package sub2porttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+var httpClient = &http.Client{
+	Timeout: 5 * time.Second,
+	// Redirects answered by the proxy are asserted, not followed.
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// A compose project with a sub2port service
+type Stack struct {
+	t       testing.TB
+	File    string // the compose file
+	Project string // the compose project name
+	Port    int    // the published port of the proxy
+	Admin   string // the admin API base URL, e.g. http://127.0.0.1:18181, if published
+	Service string // the sub2port service, "sub2port" by default
+}
+
+// Start a stack, taking down leftovers of an earlier run, and take it down when the test ends
+func Up(t testing.TB, file, project string, port int) *Stack {
+	t.Helper()
+	stack := &Stack{t: t, File: file, Project: project, Port: port, Service: "sub2port"}
+	stack.Down()
+	t.Cleanup(stack.Down)
+
+	out, err := stack.compose("up", "-d").CombinedOutput()
+	if err != nil {
+		t.Fatalf("compose up: %v\n%s", err, out)
+	}
+	return stack
+}
+
+func (stack *Stack) compose(args ...string) *exec.Cmd {
+	return exec.Command("docker", append([]string{"compose", "-f", stack.File, "-p", stack.Project}, args...)...)
+}
+
+// Remove the stack's containers and volumes
+func (stack *Stack) Down() {
+	_ = stack.compose("down", "-v", "--remove-orphans", "-t", "5").Run()
+}
+
+// Run a compose command, e.g. Compose("stop", "app2")
+func (stack *Stack) Compose(args ...string) {
+	stack.t.Helper()
+	out, err := stack.compose(args...).CombinedOutput()
+	if err != nil {
+		stack.t.Fatalf("compose %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// The proxy's logs so far
+func (stack *Stack) Logs() string {
+	out, _ := stack.compose("logs", "--no-color", stack.Service).CombinedOutput()
+	return string(out)
+}
+
+// Wait until the proxy has logged every expected line
+func (stack *Stack) WaitForLogs(expected []string, timeout time.Duration) string {
+	stack.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		logs := stack.Logs()
+		if containsAll(logs, expected) {
+			return logs
+		}
+		if time.Now().After(deadline) {
+			stack.t.Fatalf("timeout waiting for logs\nwant: %v\ngot:\n%s", expected, logs)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func containsAll(s string, subs []string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fail unless the lines appear in the logs in order
+func AssertLogSequence(t testing.TB, logs string, seq []string) {
+	t.Helper()
+	pos := 0
+	for _, sub := range seq {
+		idx := strings.Index(logs[pos:], sub)
+		if idx < 0 {
+			t.Fatalf("log sequence broken: %q not found after position %d\nlogs:\n%s", sub, pos, logs)
+		}
+		pos += idx + len(sub)
+	}
+}
+
+// GET / of a host through the proxy
+func (stack *Stack) Get(host string) (int, string) {
+	stack.t.Helper()
+	code, _, body := stack.Do(http.MethodGet, host, "/", "", nil)
+	return code, body
+}
+
+// Send a request for a host through the proxy, retrying while the proxy isn't listening yet
+func (stack *Stack) Do(method, host, path, body string, header http.Header) (int, http.Header, string) {
+	stack.t.Helper()
+	addr := fmt.Sprintf("http://127.0.0.1:%d%s", stack.Port, path)
+	var lastErr error
+	for range 10 {
+		req, _ := http.NewRequest(method, addr, strings.NewReader(body))
+		req.Host = host
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header, string(respBody)
+	}
+	stack.t.Fatalf("%s %s via port %d failed after retries: %v", method, host, stack.Port, lastErr)
+	return 0, nil, ""
+}
+
+// The Hostname line of a traefik/whoami response
+func WhoamiHostname(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "Hostname:") {
+			return strings.TrimSpace(strings.TrimPrefix(trimmed, "Hostname:"))
+		}
+	}
+	return ""
+}
+
+// admin API assertions
+
+// A backend as the admin API reports it
+type Backend struct {
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	Project string `json:"project"`
+	Address string `json:"address"`
+	Held    bool   `json:"held"`
+}
+
+// A host and its backends as the admin API reports it
+type Host struct {
+	Host     string    `json:"host"`
+	Backends []Backend `json:"backends"`
+}
+
+// Decode an admin API answer, failing the test unless it's 200
+func (stack *Stack) admin(path string, out interface{}) {
+	stack.t.Helper()
+	if stack.Admin == "" {
+		stack.t.Fatal("the stack's admin API address isn't set")
+	}
+	resp, err := httpClient.Get(stack.Admin + path)
+	if err != nil {
+		stack.t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		stack.t.Fatalf("GET %s: %s\n%s", path, resp.Status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		stack.t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+// Every routed host
+func (stack *Stack) Hosts() []Host {
+	stack.t.Helper()
+	var hosts []Host
+	stack.admin("/hosts", &hosts)
+	return hosts
+}
+
+// Wait until a host has the given number of backends in rotation
+func (stack *Stack) WaitForBackends(host string, backends int, timeout time.Duration) Host {
+	stack.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		var last Host
+		for _, routed := range stack.Hosts() {
+			if routed.Host == host {
+				last = routed
+			}
+		}
+		active := 0
+		for _, backend := range last.Backends {
+			if !backend.Held {
+				active++
+			}
+		}
+		if active == backends {
+			return last
+		}
+		if time.Now().After(deadline) {
+			stack.t.Fatalf("timeout waiting for %d backends of %s, got %+v", backends, host, last.Backends)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Fail if a container of the stack has config problems
+func (stack *Stack) AssertNoLintProblems() {
+	stack.t.Helper()
+	var reports []struct {
+		Container string   `json:"container"`
+		Problems  []string `json:"problems"`
+	}
+	stack.admin("/lint", &reports)
+	for _, report := range reports {
+		stack.t.Errorf("%s: %s", report.Container, strings.Join(report.Problems, "; "))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// TLS session ticket keys, rotated and shared through the certificate store
+
+// Turn off session tickets, so every connection does a full handshake
+var ticketsDisabled = os.Getenv("SUB2PORT_TLS_TICKETS") == "false"
+
+// How long a key encrypts new tickets before the next one takes over
+var ticketRotation = envDuration("SUB2PORT_TLS_TICKET_ROTATION", 12*time.Hour)
+
+// Keys still decrypting tickets, including the one encrypting them
+const ticketKeyCount = 3
+
+const ticketKeysName = "session.tickets"
+
+type ticketKey struct {
+	Created time.Time `json:"created"`
+	Key     []byte    `json:"key"`
+}
+
+// Use the stored keys, adding a new one once the newest is due for rotation
+func rotateTicketKeys(config *tls.Config, keys []ticketKey, now time.Time) []ticketKey {
+	stored, err := certStorage.get(ticketKeysName)
+	if err == nil {
+		var shared []ticketKey
+		if err = json.Unmarshal(stored, &shared); err == nil {
+			keys = shared
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("! tls session tickets: %v", err)
+	}
+	if len(keys) == 0 || now.Sub(keys[0].Created) >= ticketRotation {
+		key := ticketKey{Created: now, Key: make([]byte, 32)}
+		_, _ = rand.Read(key.Key)
+		keys = append([]ticketKey{key}, keys[:min(len(keys), ticketKeyCount-1)]...)
+		// Replicas sharing the store pick it up, and a read-only store keeps them per replica.
+		if encoded, err := json.Marshal(keys); err == nil && !readOnlyStore() {
+			if err := certStorage.put(ticketKeysName, encoded); err != nil {
+				log.Printf("! tls session tickets: %v", err)
+			}
+		}
+		log.Printf("# rotated tls session ticket keys")
+	}
+	raw := make([][32]byte, len(keys))
+	for i, key := range keys {
+		copy(raw[i][:], key.Key)
+	}
+	config.SetSessionTicketKeys(raw)
+	return keys
+}
+
+// Rotate the keys, checking often for keys another replica rotated
+func watchTicketKeys(config *tls.Config, keys []ticketKey) {
+	for range time.Tick(min(time.Minute, ticketRotation)) {
+		keys = rotateTicketKeys(config, keys, time.Now())
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestTicketKeyRotation(t *testing.T) {
+	previous := certStorage
+	certStorage = dirStore{dir: t.TempDir()}
+	t.Cleanup(func() { certStorage = previous })
+
+	start := time.Now()
+	first, second := &tls.Config{}, &tls.Config{}
+	keys := rotateTicketKeys(first, nil, start)
+	if len(keys) != 1 {
+		t.Fatalf("expected a first key, got %d", len(keys))
+	}
+	// Another replica sharing the store uses the same key.
+	if shared := rotateTicketKeys(second, nil, start.Add(time.Minute)); len(shared) != 1 || !bytes.Equal(shared[0].Key, keys[0].Key) {
+		t.Fatalf("expected the stored key, got %v", shared)
+	}
+	for i := range 4 {
+		keys = rotateTicketKeys(first, keys, start.Add(time.Duration(i+1)*ticketRotation))
+	}
+	if len(keys) != ticketKeyCount || !keys[0].Created.Equal(start.Add(4*ticketRotation)) {
+		t.Fatalf("expected the %d newest keys, got %v", ticketKeyCount, keys)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Top: a live view of the routes of a running proxy, read from its admin API
+
+// Requests and 5xx answers since the proxy started, by host and backend
+type requestTally map[[2]string][2]float64
+
+func top(args []string) int {
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	admin := flags.String("admin", adminURL(cmp.Or(os.Getenv("SUB2PORT_ADMIN"), "127.0.0.1:8081")), "URL or address of the admin listener")
+	interval := flags.Duration("interval", 2*time.Second, "time between refreshes")
+	once := flags.Bool("once", false, "print one view, measured over an interval, and exit")
+	_ = flags.Parse(args)
+	if *interval <= 0 {
+		fmt.Fprintln(flags.Output(), "top: interval must be positive")
+		return 2
+	}
+	base := adminURL(*admin)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	previous, err := fetchTally(client, base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "top: %v\n", err)
+		return 1
+	}
+	sampled := time.Now()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+		var hosts []hostView
+		err := fetchJSON(client, base+"/hosts", &hosts)
+		tally, tallyErr := fetchTally(client, base)
+		now := time.Now()
+		if err = cmp.Or(err, tallyErr); err != nil {
+			fmt.Fprintf(os.Stderr, "top: %v\n", err)
+			if *once {
+				return 1
+			}
+			continue
+		}
+		if !*once {
+			// Redraw from the top left of a cleared screen.
+			fmt.Print("\033[H\033[2J")
+		}
+		fmt.Printf("sub2port %s  %s\n\n", base, now.Format("15:04:05"))
+		writeTop(os.Stdout, hosts, tally, previous, now.Sub(sampled).Seconds())
+		if *once {
+			return 0
+		}
+		previous, sampled = tally, now
+	}
+}
+
+// An http:// URL for an admin address like :8081
+func adminURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	return "http://" + addr
+}
+
+// Write the hosts with their request rates and error ratios between two tallies
+func writeTop(out io.Writer, hosts []hostView, tally, previous requestTally, seconds float64) {
+	rate := func(host HostName, backend ContainerName) (float64, string) {
+		key := [2]string{string(host), string(backend)}
+		requests, errors := tally[key][0]-previous[key][0], tally[key][1]-previous[key][1]
+		if requests <= 0 {
+			return 0, "-"
+		}
+		return requests / seconds, fmt.Sprintf("%.1f%%", 100*errors/requests)
+	}
+	backends := 0
+	for _, host := range hosts {
+		backends += len(host.Backends)
+	}
+	fmt.Fprintf(out, "%d hosts, %d backends\n\n", len(hosts), backends)
+
+	writer := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tBACKEND\tSTATE\tACTIVE\tRPS\tERRORS\tREQUESTS")
+	for _, host := range hosts {
+		var requests, errors, active float64
+		var served uint64
+		for _, backend := range host.Backends {
+			key := [2]string{string(host.Host), string(backend.Name)}
+			requests += tally[key][0] - previous[key][0]
+			errors += tally[key][1] - previous[key][1]
+			active += float64(backend.Active)
+			served += backend.Requests
+		}
+		// Requests answered at the proxy, e.g. 502s without a backend, count toward the host.
+		key := [2]string{string(host.Host), "-"}
+		requests += tally[key][0] - previous[key][0]
+		errors += tally[key][1] - previous[key][1]
+		ratio := "-"
+		if requests > 0 {
+			ratio = fmt.Sprintf("%.1f%%", 100*errors/requests)
+		}
+		fmt.Fprintf(writer, "%s\t\t\t%g\t%.1f\t%s\t%d\n", host.Host, active, requests/seconds, ratio, served)
+		for _, backend := range host.Backends {
+			rps, ratio := rate(host.Host, backend.Name)
+			fmt.Fprintf(writer, "\t%s\t%s\t%d\t%.1f\t%s\t%d\n", backend.Name, backend.State, backend.Active, rps, ratio, backend.Requests)
+		}
+	}
+	_ = writer.Flush()
+}
+
+func fetchJSON(client *http.Client, url string, value any) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// Read sub2port_requests_total from the proxy's metrics
+func fetchTally(client *http.Client, base string) (requestTally, error) {
+	response, err := client.Get(base + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s/metrics: %s", base, response.Status)
+	}
+	tally := make(requestTally)
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		sample, ok := strings.CutPrefix(scanner.Text(), "sub2port_requests_total{")
+		if !ok {
+			continue
+		}
+		labels, value, ok := strings.Cut(sample, "} ")
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseFloat(strings.Fields(value)[0], 64)
+		if err != nil {
+			continue
+		}
+		pairs := parseLabels(labels)
+		key := [2]string{pairs["host"], pairs["backend"]}
+		counts := tally[key]
+		counts[0] += count
+		if pairs["code"] == "5xx" {
+			counts[1] += count
+		}
+		tally[key] = counts
+	}
+	return tally, scanner.Err()
+}
+
+// Parse the name="value" pairs of a sample, as written by formatLabels
+func parseLabels(labels string) map[string]string {
+	pairs := make(map[string]string)
+	for labels != "" {
+		name, rest, ok := strings.Cut(labels, `="`)
+		if !ok {
+			break
+		}
+		end := 0
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			break
+		}
+		value, err := strconv.Unquote(`"` + rest[:end] + `"`)
+		if err != nil {
+			value = rest[:end]
+		}
+		pairs[strings.TrimPrefix(name, ",")] = value
+		labels = rest[end+1:]
+	}
+	return pairs
+}
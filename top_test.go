@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTop(t *testing.T) {
+	requestCount.Add(10, "top.test", "top-1", "2xx")
+	admin := httptest.NewServer(adminMux)
+	t.Cleanup(admin.Close)
+	previous, err := fetchTally(admin.Client(), admin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestCount.Add(15, "top.test", "top-1", "2xx")
+	requestCount.Add(5, "top.test", "top-1", "5xx")
+	tally, err := fetchTally(admin.Client(), admin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := tally[[2]string{"top.test", "top-1"}]; counts != [2]float64{30, 5} {
+		t.Fatalf("unexpected tally %v", counts)
+	}
+
+	var out strings.Builder
+	hosts := []hostView{{Host: "top.test", Backends: []backendView{{Name: "top-1", State: "live", Requests: 30}}}}
+	writeTop(&out, hosts, tally, previous, 2)
+	if !strings.Contains(out.String(), "top-1    live   0       10.0  25.0%   30") {
+		t.Fatalf("unexpected view:\n%s", out.String())
+	}
+	if labels := parseLabels(`host="a\"b",code="5xx"`); labels["host"] != `a"b` || labels["code"] != "5xx" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+}
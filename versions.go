@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Per-host HTTP version limits, for clients and backends with HTTP/2 bugs
+
+// Backends limited to HTTP/1.1 share their own pool of connections
+var http1Transport = func() *http.Transport {
+	transport := sharedTransport.Clone()
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP1(true)
+	return transport
+}()
+
+// Offer clients of hosts limited to HTTP/1.1 only http/1.1, by the server name of their handshake.
+// The server's config is cloned per handshake, so its rotated ticket keys still apply.
+func limitProtocols(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		host := HostName(strings.ToLower(strings.TrimSuffix(hello.ServerName, ".")))
+		entry := table.lookup(table.wildcard(host))
+		if entry == nil {
+			return nil, nil
+		}
+		pool := entry.pool.Load()
+		if len(pool.backends)+len(pool.held) == 0 || !pool.options().HTTP1Clients {
+			return nil, nil
+		}
+		config := base.Clone()
+		config.NextProtos = slices.DeleteFunc(slices.Clone(config.NextProtos), func(proto string) bool { return proto == "h2" })
+		return config, nil
+	}
+}
+
+// Turn away HTTP/2 requests that reached a host limited to HTTP/1.1 anyway, e.g. on a
+// connection opened for another host, so the client retries on a new one
+func filterHTTPVersion(writer http.ResponseWriter, request *http.Request, options *hostOptions) bool {
+	if !options.HTTP1Clients || request.ProtoMajor < 2 {
+		return false
+	}
+	code := http.StatusMisdirectedRequest
+	if request.TLS == nil {
+		code = http.StatusHTTPVersionNotSupported
+	}
+	renderError(writer, request, options, code, request.Host+" only speaks HTTP/1.1")
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestHTTP1Only(t *testing.T) {
+	options := parseOptions("old-app", map[string]string{"SUB2PORT_HTTP1_ONLY": "true"})
+	if !options.HTTP1Clients || !options.HTTP1Backends || transportFor(route{Options: options}).(routeTransport).transport != http1Transport {
+		t.Fatalf("expected HTTP/1.1 on both sides, got %+v", options)
+	}
+	table.Lock()
+	bindRoute("old.test", route{Name: "old-app", Host: "127.0.0.1", Port: "80", Options: options}, false)
+	table.containers["old-app"] = []binding{{Domain: "old.test", Name: "old-app"}}
+	table.Unlock()
+	t.Cleanup(func() { dropRoutes("old-app") })
+
+	base := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	offered := limitProtocols(base)
+	// Settings made after the callback is installed, like SUB2PORT_TLS_TICKETS=false, still apply.
+	base.SessionTicketsDisabled = true
+	for host, want := range map[string][]string{"old.test": {"http/1.1"}, "new.test": nil} {
+		config, err := offered(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil || (config == nil) != (want == nil) || (config != nil && !slices.Equal(config.NextProtos, want)) {
+			t.Fatalf("%s: unexpected config %v, %v", host, config, err)
+		}
+		if config != nil && !config.SessionTicketsDisabled {
+			t.Fatalf("%s: expected the server's ticket settings", host)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "https://old.test/", nil)
+	request.ProtoMajor = 2
+	recorder := httptest.NewRecorder()
+	if !filterHTTPVersion(recorder, request, options) || recorder.Code != http.StatusMisdirectedRequest {
+		t.Fatalf("expected 421 for HTTP/2, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Self-monitoring
+
+var watchdogInterval = envDuration("SUB2PORT_WATCHDOG_INTERVAL", 5*time.Minute)
+
+// Leak thresholds, 0 disables
+var maxGoroutines = envInt("SUB2PORT_MAX_GOROUTINES", 0)
+var maxHeapMB = envInt("SUB2PORT_MAX_HEAP_MB", 0)
+var maxFDs = envInt("SUB2PORT_MAX_FDS", 0)
+
+// Restart instead of only warning when a threshold is exceeded
+var watchdogRestart = envBool("SUB2PORT_WATCHDOG_RESTART")
+
+func init() {
+	newGaugeFunc("sub2port_goroutines", "Goroutines currently running.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	newGaugeFunc("sub2port_heap_bytes", "Bytes of allocated heap objects.", func() float64 {
+		return float64(heapBytes())
+	})
+	newGaugeFunc("sub2port_open_fds", "Open file descriptors, including sockets.", func() float64 {
+		return float64(openFDs())
+	})
+}
+
+func heapBytes() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func openFDs() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+// Log resource usage and act on thresholds exceeded for consecutive checks
+func watchSelf() {
+	exceeded := 0
+	for range time.Tick(watchdogInterval) {
+		goroutines, heap, fds := runtime.NumGoroutine(), heapBytes(), openFDs()
+		log.Printf("# self: %d goroutines, %.1f MiB heap, %d fds", goroutines, float64(heap)/(1<<20), fds)
+
+		var leak string
+		switch {
+		case maxGoroutines > 0 && goroutines > maxGoroutines:
+			leak = fmt.Sprintf("%d goroutines exceeds %d", goroutines, maxGoroutines)
+		case maxHeapMB > 0 && heap > uint64(maxHeapMB)<<20:
+			leak = fmt.Sprintf("%.1f MiB heap exceeds %d MiB", float64(heap)/(1<<20), maxHeapMB)
+		case maxFDs > 0 && fds > maxFDs:
+			leak = fmt.Sprintf("%d fds exceeds %d", fds, maxFDs)
+		}
+		if leak == "" {
+			exceeded = 0
+			continue
+		}
+		exceeded++
+		log.Printf("! watchdog: %s (%d checks in a row)", leak, exceeded)
+		if watchdogRestart && exceeded >= 3 {
+			restart(leak)
+		}
+	}
+}
+
+// Drain connections and exit so the restart policy starts a fresh process
+func restart(reason string) {
+	log.Printf("! restarting: %s", reason)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	os.Exit(1)
+}
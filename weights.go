@@ -0,0 +1,52 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Backend weights, set per container or from its resource limits
+
+// Weigh each backend by its container's CPU and memory limits, so small replicas get fewer requests
+var limitWeights = envBool("SUB2PORT_LIMIT_WEIGHTS")
+
+// The share of its docker host a container is limited to, by CPU or memory
+// whichever is smaller, or 0 when it is unlimited
+func (daemon *dockerDaemon) limitWeight(container *dockerInspect) float64 {
+	limits := container.HostConfig
+	cpus := float64(limits.NanoCpus) / 1e9
+	if cpus == 0 && limits.CpuQuota > 0 {
+		cpus = float64(limits.CpuQuota) / float64(cmp.Or(limits.CpuPeriod, 100000))
+	}
+	if cpus == 0 && limits.Memory == 0 {
+		return 0
+	}
+	info, err := daemon.host()
+	if err != nil {
+		log.Printf("! docker %s info: %v", daemon, err)
+		return 0
+	}
+	weight := 1.0
+	if cpus > 0 && info.NCPU > 0 {
+		weight = min(weight, cpus/float64(info.NCPU))
+	}
+	if limits.Memory > 0 && info.MemTotal > 0 {
+		weight = min(weight, float64(limits.Memory)/float64(info.MemTotal))
+	}
+	return weight
+}
+
+// A container's SUB2PORT_WEIGHT, or 0 when it has none
+func parseWeight(name ContainerName, value string) float64 {
+	if value = strings.TrimSpace(value); value == "" {
+		return 0
+	}
+	weight, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(weight > 0) || weight > 1e6 {
+		log.Printf("%s: SUB2PORT_WEIGHT: expected a positive number, got %q", name, value)
+		return 0
+	}
+	return weight
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A replica limited to a quarter of the host gets a quarter of a full one's requests
+func TestLimitWeights(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+	limitWeights = true
+	t.Cleanup(func() { limitWeights = false })
+
+	fake.set("weight-small", func(container *dockerInspect) { container.HostConfig.NanoCpus = 1e9 / 4 })
+	fake.set("weight-memory", func(container *dockerInspect) {
+		container.HostConfig.CpuQuota = 200000
+		container.HostConfig.Memory = 1 << 30
+	})
+	fake.run("weight-full", "10.0.0.1", "SUB2PORT=weight.test")
+	fake.run("weight-small", "10.0.0.2", "SUB2PORT=weight.test")
+	fake.run("weight-memory", "10.0.0.3", "SUB2PORT=weight.test")
+	eventually(t, "three backends", routedTo("weight.test", 3))
+
+	entry := table.lookup("weight.test")
+	pool := entry.pool.Load()
+	want := map[ContainerName]float64{"weight-full": 1, "weight-small": 0.25 / 4, "weight-memory": 0.125}
+	for _, backend := range pool.backends {
+		if backend.weight() != want[backend.Name] {
+			t.Errorf("expected %s to weigh %v, got %v", backend.Name, want[backend.Name], backend.weight())
+		}
+	}
+	counts := map[ContainerName]int{}
+	request := httptest.NewRequest(http.MethodGet, "http://weight.test/", nil)
+	for range 1188 {
+		counts[pool.backends[roundRobin{}.pick(entry, pool.backends, request)].Name]++
+	}
+	if counts["weight-full"] < 995 || counts["weight-full"] > 1005 || counts["weight-small"] < 60 || counts["weight-small"] > 65 || counts["weight-memory"] < 123 || counts["weight-memory"] > 127 {
+		t.Fatalf("expected picks in proportion to the weights, got %v", counts)
+	}
+}
+
+// A canary labeled with a weight gets its share of the requests next to the old replica
+func TestCanaryWeight(t *testing.T) {
+	fake, daemon := newFakeDocker(t)
+	watchFake(t, daemon)
+
+	fake.set("canary-new", func(container *dockerInspect) {
+		container.Config.Labels = map[string]string{"sub2port.weight": "1"}
+	})
+	fake.run("canary-old", "10.0.0.1", "SUB2PORT=canary.test", "SUB2PORT_WEIGHT=9")
+	fake.run("canary-new", "10.0.0.2", "SUB2PORT=canary.test")
+	eventually(t, "two backends", routedTo("canary.test", 2))
+
+	entry := table.lookup("canary.test")
+	pool := entry.pool.Load()
+	counts := map[ContainerName]int{}
+	request := httptest.NewRequest(http.MethodGet, "http://canary.test/", nil)
+	for range 1000 {
+		counts[pool.backends[roundRobin{}.pick(entry, pool.backends, request)].Name]++
+	}
+	if counts["canary-new"] < 95 || counts["canary-new"] > 105 {
+		t.Fatalf("expected a tenth of the requests on the canary, got %v", counts)
+	}
+}
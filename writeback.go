@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// Status writeback
+
+// Docker labels can't change after a container is created, so the status
+// is copied into the container as a file instead.
+var statusFile = os.Getenv("SUB2PORT_STATUS_FILE")
+
+type containerStatus struct {
+	State   string     `json:"state"` // routed, quarantined, unplaced, starting, or unhealthy
+	Routes  []hostStat `json:"routes"`
+	Updated time.Time  `json:"updated"`
+}
+
+type hostStat struct {
+	Host    HostName `json:"host"`
+	Backend string   `json:"backend"`
+}
+
+// Write how sub2port routes a container into the container's status file
+func writeStatus(daemon *dockerDaemon, containerID ContainerID, status containerStatus) {
+	if statusFile == "" {
+		return
+	}
+	status.Updated = time.Now().UTC()
+	body, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return
+	}
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	_ = writer.WriteHeader(&tar.Header{
+		Name:    path.Base(statusFile),
+		Mode:    0o644,
+		Size:    int64(len(body)),
+		ModTime: status.Updated,
+	})
+	_, _ = writer.Write(body)
+	_ = writer.Close()
+
+	query := url.Values{"path": {path.Dir(statusFile)}}
+	if err := daemon.put("/containers/"+string(containerID)+"/archive?"+query.Encode(), "application/x-tar", &archive); err != nil {
+		log.Printf("! write status to %s: %v", containerID[:12], err)
+	}
+}
+
+func (daemon *dockerDaemon) put(path, contentType string, body *bytes.Buffer) error {
+	daemon.throttle()
+	request, err := http.NewRequest(http.MethodPut, daemon.base+path, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	response, err := daemon.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		var message struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&message)
+		return fmt.Errorf("%s: %s", response.Status, message.Message)
+	}
+	return nil
+}
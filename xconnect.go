@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Extended CONNECT (RFC 8441): WebSockets opened on an HTTP/2 stream, bridged
+// to an HTTP/1.1 upgrade so backends don't need to speak HTTP/2.
+// Go's HTTP/2 server only offers it with GODEBUG=http2xconnect=1, which the
+// image sets.
+
+func isExtendedConnect(request *http.Request) bool {
+	return request.Method == http.MethodConnect && request.Header.Get(":protocol") != ""
+}
+
+func proxyExtendedConnect(writer http.ResponseWriter, request *http.Request) {
+	state := stateOf(request)
+	protocol := request.Header.Get(":protocol")
+	if !strings.EqualFold(protocol, "websocket") {
+		// WebTransport needs HTTP/3, which the proxy doesn't serve.
+		renderError(writer, request, state.options, http.StatusNotImplemented, fmt.Sprintf("%s can't be proxied over HTTP/2", protocol))
+		return
+	}
+	upgrade := request.Clone(request.Context())
+	upgrade.Method = http.MethodGet
+	upgrade.Proto, upgrade.ProtoMajor, upgrade.ProtoMinor = "HTTP/1.1", 1, 1
+	upgrade.RequestURI = ""
+	upgrade.Body, upgrade.ContentLength = nil, 0
+	upgrade.Header.Del(":protocol")
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	// The key only proves the backend understood the handshake, which it answered to us.
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	upgrade.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	direct(upgrade)
+
+	response, err := stateTransport{}.RoundTrip(upgrade)
+	if err == nil {
+		err = modifyResponse(response)
+	}
+	if err != nil {
+		if response != nil {
+			_ = response.Body.Close()
+		}
+		proxyError(writer, request, err)
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+	header := writer.Header()
+	for name, values := range response.Header {
+		switch name {
+		case "Connection", "Upgrade", "Sec-Websocket-Accept", "Keep-Alive", "Transfer-Encoding":
+		default:
+			header[name] = values
+		}
+	}
+	backend, ok := response.Body.(io.ReadWriteCloser)
+	if response.StatusCode != http.StatusSwitchingProtocols || !ok {
+		// Refused, e.g. with 403, which the client gets as is.
+		writer.WriteHeader(response.StatusCode)
+		_, _ = io.Copy(writer, response.Body)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(writer)
+	// Sockets live past SUB2PORT_READ_TIMEOUT and SUB2PORT_WRITE_TIMEOUT, as hijacked HTTP/1.1 upgrades do.
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+	if err := controller.Flush(); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(backend, request.Body)
+		// Tell the backend the client is done sending.
+		if conn, ok := backend.(interface{ CloseWrite() error }); ok {
+			_ = conn.CloseWrite()
+		}
+	}()
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := backend.Read(buffer)
+		if n > 0 {
+			if _, err := writer.Write(buffer[:n]); err != nil || controller.Flush() != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	_ = backend.Close()
+	_ = request.Body.Close()
+	<-done
+}
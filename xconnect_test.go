@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A response streamed to the test as it is flushed
+type streamWriter struct {
+	header http.Header
+	status chan int
+	body   *io.PipeWriter
+}
+
+func (stream *streamWriter) Header() http.Header            { return stream.header }
+func (stream *streamWriter) WriteHeader(code int)           { stream.status <- code }
+func (stream *streamWriter) Write(data []byte) (int, error) { return stream.body.Write(data) }
+func (stream *streamWriter) Flush()                         {}
+
+func TestExtendedConnect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Upgrade") != "websocket" || request.Header.Get("Sec-WebSocket-Key") == "" || request.Method != http.MethodGet {
+			http.Error(writer, "not a WebSocket handshake", http.StatusBadRequest)
+			return
+		}
+		conn, buffered, err := http.NewResponseController(writer).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: x\r\nSec-WebSocket-Protocol: chat\r\n\r\n")
+		_, _ = io.Copy(conn, buffered)
+	}))
+	t.Cleanup(backend.Close)
+	routeTo(t, "ws.test", backend, nil)
+
+	open := func(protocol string) (*streamWriter, *io.PipeWriter, *io.PipeReader, chan struct{}) {
+		clientBody, toBackend := io.Pipe()
+		fromBackend, responseBody := io.Pipe()
+		request := httptest.NewRequest(http.MethodConnect, "/socket", clientBody)
+		request.Host = "ws.test"
+		request.Proto, request.ProtoMajor, request.ProtoMinor = "HTTP/2.0", 2, 0
+		request.Header.Set(":protocol", protocol)
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		writer := &streamWriter{header: http.Header{}, status: make(chan int, 1), body: responseBody}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() { _ = responseBody.Close() }()
+			proxy(writer, request)
+		}()
+		return writer, toBackend, fromBackend, done
+	}
+
+	writer, toBackend, fromBackend, done := open("websocket")
+	if status := <-writer.status; status != http.StatusOK || writer.header.Get("Sec-WebSocket-Protocol") != "chat" || writer.header.Get("Sec-WebSocket-Accept") != "" {
+		t.Fatalf("expected the stream to open, got %d %v", status, writer.header)
+	}
+	_, _ = io.WriteString(toBackend, "ping")
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(fromBackend, echo); err != nil || string(echo) != "ping" {
+		t.Fatalf("expected an echo, got %q %v", echo, err)
+	}
+	_ = toBackend.Close()
+	<-done
+
+	writer, toBackend, fromBackend, done = open("webtransport")
+	go func() { _, _ = io.Copy(io.Discard, fromBackend) }()
+	if status := <-writer.status; status != http.StatusNotImplemented {
+		t.Fatalf("expected WebTransport to be refused, got %d", status)
+	}
+	_ = toBackend.Close()
+	<-done
+}